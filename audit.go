@@ -0,0 +1,242 @@
+package bankid
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAuditChainBroken is returned by VerifyAuditChain for audit records that have been altered,
+// removed or reordered
+var ErrAuditChainBroken = errors.New("audit chain broken")
+
+// AuditRecord is an entry of the audit log, written when an order is started and when it ends. Each
+// record holds the hash of the previous one, so that the log can not be altered without detection
+type AuditRecord struct {
+	Seq                uint64    `json:"seq"`
+	Time               time.Time `json:"time"`
+	Status             Status    `json:"status"` // "sent" when the order was started, otherwise how it ended, or "expired"
+	RequestID          string    `json:"requestId"`
+	OrderRef           string    `json:"orderRef,omitempty"`
+	OrderTime          time.Time `json:"orderTime"`
+	EndUserIP          string    `json:"endUserIp,omitempty"`
+	HintCode           HintCode  `json:"hintCode,omitempty"`
+	ErrorCode          string    `json:"errorCode,omitempty"`
+	PersonalNumberHash string    `json:"personalNumberHash,omitempty"` // Pseudonym, or SHA-256, of the personal number
+	SignatureDigest    string    `json:"signatureDigest,omitempty"`    // SHA-256 of the signature of the completion
+	PrevHash           string    `json:"prevHash"`
+	Hash               string    `json:"hash"`
+}
+
+// AuditStore stores the audit log, see SetAuditStore. Implementations must be safe for concurrent use
+type AuditStore interface {
+	// Append adds a record at the end of the log
+	Append(rec AuditRecord) error
+	// Records returns all records of the log, in the order appended
+	Records() ([]AuditRecord, error)
+}
+
+// auditor writes the audit records of a connection
+type auditor struct {
+	mu     sync.Mutex
+	store  AuditStore
+	seq    uint64
+	last   string                  // Hash of the last record
+	orders map[string]*AuditRecord // The started record of ongoing orders, by request ID
+}
+
+// SetAuditStore sets the store of the audit log, continuing the hash chain of the records already in
+// it. Every order started after the call is recorded. If reconcileInterval is set, Reconcile is run
+// at that interval from then on. Should be called before any request is sent
+func (sc *Connection) SetAuditStore(s AuditStore) error {
+	recs, err := s.Records()
+	if err != nil {
+		return fmt.Errorf("could not read audit store: %v", err)
+	}
+	a := &auditor{store: s, orders: make(map[string]*AuditRecord)}
+	if n := len(recs); n > 0 {
+		a.seq, a.last = recs[n-1].Seq, recs[n-1].Hash
+	}
+	sc.auditMu.Lock()
+	// While the connection is created, reconciling is started once the connection is complete
+	start := sc.auditor == nil && sc.cfg.ReconcileInterval > 0 && sc.httpClient != nil
+	sc.auditor = a
+	sc.auditMu.Unlock()
+	if start {
+		sc.stats.goStart()
+		go sc.reconcile(time.Duration(sc.cfg.ReconcileInterval) * time.Millisecond)
+	}
+	return nil
+}
+
+// audit writes the audit record of a status update starting or ending an order
+func (sc *Connection) audit(ev Event) {
+	sc.auditMu.Lock()
+	a := sc.auditor
+	sc.auditMu.Unlock()
+	if a == nil || (ev.Status != StatusSent && !ev.Status.final()) {
+		return
+	}
+	rec := AuditRecord{Time: ev.Time.UTC(), Status: ev.Status, RequestID: ev.RequestID, HintCode: ev.HintCode, ErrorCode: ev.ErrorCode}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if started, ok := a.orders[ev.RequestID]; ok {
+		rec.OrderRef, rec.OrderTime, rec.EndUserIP = started.OrderRef, started.OrderTime, started.EndUserIP
+		if ev.Status.final() {
+			delete(a.orders, ev.RequestID)
+		}
+	}
+	if so := ev.Started; so != nil {
+		rec.OrderRef, rec.OrderTime, rec.EndUserIP = so.OrderRef, so.OrderTime.UTC(), so.EndUserIP
+		a.orders[ev.RequestID] = &rec
+	}
+	if cd := ev.Completion; cd != nil {
+		rec.PersonalNumberHash = sc.hashPersonalNumber(cd.User.PersonalNumber)
+		sum := sha256.Sum256([]byte(cd.Signature))
+		rec.SignatureDigest = hex.EncodeToString(sum[:])
+	}
+	if err := a.append(rec); err != nil {
+		sc.logprint(ERROR, ev.RequestID, ": could not write audit record:", err.Error())
+		sc.warn(fmt.Errorf("could not write audit record of request %s: %v", ev.RequestID, err))
+	}
+}
+
+// append chains rec to the last record and stores it. Must be called with the lock held
+func (a *auditor) append(rec AuditRecord) error {
+	rec.Seq, rec.PrevHash = a.seq+1, a.last
+	rec.Hash = rec.computeHash()
+	if err := a.store.Append(rec); err != nil {
+		return err
+	}
+	a.seq, a.last = rec.Seq, rec.Hash
+	return nil
+}
+
+// computeHash returns the hex encoded SHA-256 of the record, with the hash itself left out
+func (rec AuditRecord) computeHash() string {
+	rec.Hash = ""
+	data, _ := json.Marshal(rec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashPersonalNumber returns the pseudonym of the personal number, if a Pseudonymizer is set, and
+// otherwise its SHA-256. Setting a Pseudonymizer is recommended, as a plain hash of a personal number
+// is easily reversed by trying all of them
+func (sc *Connection) hashPersonalNumber(pnr string) string {
+	if pnr == "" {
+		return ""
+	}
+	if sc.pseudonymizer != nil {
+		return sc.pseudonymizer.Pseudonymize(pnr)
+	}
+	sum := sha256.Sum256([]byte(pnr))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain checks that the records, as returned by AuditStore.Records, form an unbroken hash
+// chain. An error wrapping ErrAuditChainBroken tells the first record found altered or missing
+func VerifyAuditChain(recs []AuditRecord) error {
+	for i, rec := range recs {
+		if rec.Hash != rec.computeHash() {
+			return fmt.Errorf("%w: record %d has been altered", ErrAuditChainBroken, rec.Seq)
+		}
+		if i == 0 {
+			continue
+		}
+		if prev := recs[i-1]; rec.Seq != prev.Seq+1 || rec.PrevHash != prev.Hash {
+			return fmt.Errorf("%w: record(s) missing between %d and %d", ErrAuditChainBroken, prev.Seq, rec.Seq)
+		}
+	}
+	return nil
+}
+
+// MemoryAuditStore is an AuditStore keeping the records in memory, for tests and development
+type MemoryAuditStore struct {
+	mu   sync.Mutex
+	recs []AuditRecord
+}
+
+// Append implements the AuditStore interface
+func (m *MemoryAuditStore) Append(rec AuditRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recs = append(m.recs, rec)
+	return nil
+}
+
+// Records implements the AuditStore interface
+func (m *MemoryAuditStore) Records() ([]AuditRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]AuditRecord(nil), m.recs...), nil
+}
+
+// FileAuditStore is an AuditStore appending the records to a file, one JSON object per line. Every
+// record is synced to disk before Append returns
+type FileAuditStore struct {
+	mu   sync.Mutex
+	name string
+	f    *os.File
+}
+
+// NewFileAuditStore opens, or creates, the audit log file
+func NewFileAuditStore(fileName string) (*FileAuditStore, error) {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditStore{name: fileName, f: f}, nil
+}
+
+// Append implements the AuditStore interface
+func (fs *FileAuditStore) Append(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, err := fs.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return fs.f.Sync()
+}
+
+// Records implements the AuditStore interface
+func (fs *FileAuditStore) Records() ([]AuditRecord, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.records()
+}
+
+// records reads the records of the file. Must be called with the lock held
+func (fs *FileAuditStore) records() ([]AuditRecord, error) {
+	f, err := os.Open(fs.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("could not decode audit record %d: %v", len(recs)+1, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+// Close closes the audit log file
+func (fs *FileAuditStore) Close() error {
+	return fs.f.Close()
+}
@@ -0,0 +1,102 @@
+package bankid
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrAuditExportSignature is returned by VerifyAuditExport for exports not matching their signature
+var ErrAuditExportSignature = errors.New("audit export does not match its signature")
+
+// ExportAudit writes the audit records of the orders from from up to, but not including, to as NDJSON,
+// one JSON object per line, to w, and returns a detached signature of what was written, made with the
+// private key of the RP certificate. A zero from or to leaves the range open at that end. The hash chain
+// of the whole audit log is verified first, and nothing is exported if it is broken. The signature is
+// checked with VerifyAuditExport
+func (sc *Connection) ExportAudit(w io.Writer, from, to time.Time) ([]byte, error) {
+	sc.auditMu.Lock()
+	a := sc.auditor
+	sc.auditMu.Unlock()
+	if a == nil {
+		return nil, errors.New("no audit store set")
+	}
+	signer, err := sc.clientCert.signer()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	recs, err := a.store.Records()
+	a.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("could not read audit store: %v", err)
+	}
+	if err := VerifyAuditChain(recs); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if (!from.IsZero() && rec.OrderTime.Before(from)) || (!to.IsZero() && !rec.OrderTime.Before(to)) {
+			continue
+		}
+		if err := enc.Encode(rec); err != nil {
+			return nil, err
+		}
+	}
+	digest := sha256.Sum256(buf.Bytes())
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign audit export: %v", err)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	sc.logprint(INFO, "audit log exported,", fmt.Sprint(buf.Len()), "bytes")
+	return sig, nil
+}
+
+// VerifyAuditExport checks the detached signature of an export of ExportAudit against the RP certificate
+// of the connection that made it, and the hashes of the exported records. The records of orders outside
+// the date range are left out, so the chain is only checked between consecutive records
+func VerifyAuditExport(export, signature []byte, cert *x509.Certificate) error {
+	digest := sha256.Sum256(export)
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return ErrAuditExportSignature
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+			return ErrAuditExportSignature
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	dec := json.NewDecoder(bytes.NewReader(export))
+	var prev *AuditRecord
+	for n := 1; ; n++ {
+		var rec AuditRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not decode audit record %d: %v", n, err)
+		}
+		if rec.Hash != rec.computeHash() {
+			return fmt.Errorf("%w: record %d has been altered", ErrAuditChainBroken, rec.Seq)
+		}
+		if prev != nil && rec.Seq == prev.Seq+1 && rec.PrevHash != prev.Hash {
+			return fmt.Errorf("%w: record %d does not follow record %d", ErrAuditChainBroken, rec.Seq, prev.Seq)
+		}
+		prev = &rec
+	}
+}
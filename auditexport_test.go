@@ -0,0 +1,91 @@
+package bankid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAuditedConnection returns a simulated connection with an audit store and an RP certificate of ca,
+// having completed orders orders
+func newAuditedConnection(t *testing.T, ca *testCA, store AuditStore, orders int) *Connection {
+	t.Helper()
+	sc := newSimConnection(t, 1000, func(string, string, string) {}, WithAuditStore(store))
+	cert, err := CertificateFromSigner(ca.key, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.SetCertificate(cert)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < orders; i++ {
+		if _, err := sc.AuthenticateAsync(testIP).Wait(ctx); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	return sc
+}
+
+func TestExportAudit(t *testing.T) {
+	ca := newTestCA(t, "RP")
+	sc := newAuditedConnection(t, ca, &MemoryAuditStore{}, 3)
+
+	var buf bytes.Buffer
+	sig, err := sc.ExportAudit(&buf, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("ExportAudit: %v", err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 6 {
+		t.Errorf("%d records exported, want 6", n)
+	}
+	if err := VerifyAuditExport(buf.Bytes(), sig, ca.cert); err != nil {
+		t.Errorf("VerifyAuditExport: %v", err)
+	}
+
+	tampered := bytes.Replace(buf.Bytes(), []byte(`"status":"complete"`), []byte(`"status":"failed"`), 1)
+	if err := VerifyAuditExport(tampered, sig, ca.cert); !errors.Is(err, ErrAuditExportSignature) {
+		t.Errorf("tampered export: got %v, want %v", err, ErrAuditExportSignature)
+	}
+	if err := VerifyAuditExport(buf.Bytes(), sig, newTestCA(t, "other").cert); !errors.Is(err, ErrAuditExportSignature) {
+		t.Errorf("other certificate: got %v, want %v", err, ErrAuditExportSignature)
+	}
+
+	buf.Reset()
+	sig, err = sc.ExportAudit(&buf, sc.clock.Now().Add(time.Hour), time.Time{})
+	if err != nil {
+		t.Fatalf("ExportAudit: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("records exported out of range: %s", buf.String())
+	}
+	if err := VerifyAuditExport(buf.Bytes(), sig, ca.cert); err != nil {
+		t.Errorf("VerifyAuditExport of an empty export: %v", err)
+	}
+}
+
+// brokenAuditStore is a MemoryAuditStore with the first record altered
+type brokenAuditStore struct {
+	MemoryAuditStore
+}
+
+func (b *brokenAuditStore) Records() ([]AuditRecord, error) {
+	recs, err := b.MemoryAuditStore.Records()
+	if len(recs) > 0 {
+		recs[0].EndUserIP = "198.51.100.1"
+	}
+	return recs, err
+}
+
+func TestExportAuditBrokenChain(t *testing.T) {
+	sc := newAuditedConnection(t, newTestCA(t, "RP"), &brokenAuditStore{}, 1)
+	var buf bytes.Buffer
+	if _, err := sc.ExportAudit(&buf, time.Time{}, time.Time{}); !errors.Is(err, ErrAuditChainBroken) {
+		t.Errorf("got %v, want %v", err, ErrAuditChainBroken)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("exported %s", buf.String())
+	}
+}
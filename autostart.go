@@ -0,0 +1,21 @@
+package bankid
+
+import "net/url"
+
+// autoStartBase is the universal link launching the BankID app, on mobile devices as on computers
+const autoStartBase = "https://app.bankid.com/"
+
+// AutoStartURL returns the URL launching the BankID app on the same device as the browser or app the
+// order was started in, with the autostart token of the order. Once the order has been handled the
+// BankID app returns the user to redirect, typically the page that started the order, with enough
+// state in the URL to resume it. With an empty redirect the user is not returned, as with API
+// version 6.0 where the return URL is instead set in the order, see WithReturnURL
+func AutoStartURL(autoStartToken, redirect string) string {
+	v := url.Values{}
+	v.Set("autostarttoken", autoStartToken)
+	if redirect == "" {
+		redirect = "null"
+	}
+	v.Set("redirect", redirect)
+	return autoStartBase + "?" + v.Encode()
+}
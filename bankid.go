@@ -10,57 +10,79 @@ import (
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/rs/xid"
 	"github.com/skip2/go-qrcode"
 
-	"github.com/hossner/bankid/internal/config"
-	"golang.org/x/crypto/pkcs12"
+	"github.com/hossner/bankid/config"
+	"github.com/hossner/bankid/personnummer"
 )
 
 const (
-	version          = "0.1"
-	internalErrorMsg = "error"
+	version = "0.1"
+	// maxConnsPerHost bounds the connections to the BankID server made by a transport, the calls beyond
+	// it waiting for one to be free, and as many are kept idle for the next calls
+	maxConnsPerHost = 32
 )
 
-// The definition of log levels
-const (
-	DEBUG = iota
-	INFO
-	WARN
-	ERROR
-	FATAL
-	PANIC
-)
-
-var logLevel = 0 // Loggin disabled by default
-var logFile *os.File
-var logLevels []string
 var connection *Connection
 
 // Connection holds the connection with the BankID server. The same connection will be
 // reused if multiple calls to 'New' are made.
 type Connection struct {
-	Version        string
-	funcOnResponse FOnResponse
-	cfg            *config.Config
-	httpClient     *http.Client
-	transQueues    map[string]chan byte
-	orderRefs      map[string]string
-	autoStarts     map[string]string
-	qrQuits        map[string]chan struct{}
-	mu             sync.Mutex
+	Version            string
+	funcOnResponse     FOnResponse
+	funcOnEvent        FOnEvent
+	funcOnEventCtx     FOnEventContext
+	funcOnHTTPRequest  FOnHTTPRequest
+	funcOnHTTPResponse FOnHTTPResponse
+	cfg                *config.Config
+	httpClient         *http.Client
+	customClient       *http.Client        // Set with WithHTTPClient
+	pool               *transportPool      // The transports shared by the tenants of a Manager
+	poolKey            string              // The key of the transport of the connection in pool
+	sessions           map[string]*session // Ongoing requests, by request ID
+	byPersonalNumber   map[string]*session // Ongoing requests with a personal number, see DuplicateOrderPolicy
+	sessMu             sync.Mutex
+	qrRenderer         QRRenderer
+	qrMimeType         atomic.Value // Of the last QR code rendered, see QRMimeType
+	tracer             *tracer
+	metrics            Metrics
+	observers          map[string]func(Event)
+	webhook            *webhook
+	log                *logger
+	lifecycle          lifecycle
+	auditor            *auditor
+	auditMu            sync.Mutex
+	retention          RetentionPolicy
+	onReconcile        FOnReconcile
+	warnings           []Event // Held back until an FOnEvent call back function is set
+	warnMu             sync.Mutex
+	limiter            *tokenBucket
+	pseudonymizer      Pseudonymizer
+	onPanic            FOnPanic
+	localizer          Localizer
+	codec              Codec
+	clock              Clock
+	obsMu              sync.Mutex
+	stats              stats
+	clientCert         clientCert
+	certs              map[string]*namedCert // Additional RP certificates, by name
+	certMu             sync.RWMutex
+	secrets            SecretProvider
+	sim                *simulator    // Answers the calls instead of the BankID server, with environment "simulation"
+	rec                *recorder     // Records or replays the calls, with a recording section
+	quit               chan struct{} // Closed by Close, to stop background go routines
 }
 
 // Requirements is used when specific requirements for the sign/auth request are needed.
@@ -73,14 +95,28 @@ type Requirements struct {
 	// AutoStartTokenRequired bool     `json:"autoStartTokenRequired,omitempty"`
 	TokenStartRequired bool `json:"tokenStartRequired,omitempty"`
 	AllowFingerprint   bool `json:"allowFingerprint,omitempty"`
+	PinCode            bool `json:"pinCode,omitempty"` // Require the PIN code, not biometrics. Supported from API version 6.0
 }
 
+// PersonalNumberPolicy decides whether a personal number may, or must, be provided with a request.
+// It is set with "personalNumberPolicy" in the config file
+type PersonalNumberPolicy string
+
+// The available personal number policies. BankID discourages pre-filled personal numbers, as the
+// user then risks starting the app without scanning a QR code or using the autostart token
+const (
+	PersonalNumberAllow   PersonalNumberPolicy = "allow"
+	PersonalNumberRequire PersonalNumberPolicy = "require"
+	PersonalNumberForbid  PersonalNumberPolicy = "forbid"
+)
+
 // FOnResponse is the call back function used to return status updates after a auth/sign request has been made
-// Returns: requestID, status, message
+// Returns: requestID, status, message. When the status is "complete" the message is the personal number of the
+// user; the full user object is available in Event.Completion, see SetEventHandler
 type FOnResponse func(requestID, status, message string)
 
 // FOnNewQRCode is a call back function, used as an argument to SendRequest, that is called every second after
-// the request, providing a new QR code
+// the request, providing a new QR code rendered by the connection's QRRenderer
 type FOnNewQRCode func(QRCode []byte, requestID string)
 
 /*
@@ -89,8 +125,9 @@ type FOnNewQRCode func(QRCode []byte, requestID string)
 =========================================================================================
 */
 
-// New returns a server connection. If a connection allready exists, it will be reused
-func New(configFileName string, responseCallBack FOnResponse) (*Connection, error) {
+// New returns a server connection. If a connection allready exists, it will be reused. The options,
+// e.g. WithLogHandler or WithClock, are applied as the connection is created
+func New(configFileName string, responseCallBack FOnResponse, opts ...Option) (*Connection, error) {
 	if connection != nil { // Reuse if multiple calls are made. No hot reload of change of config in this version
 		return connection, nil
 	}
@@ -101,287 +138,678 @@ func New(configFileName string, responseCallBack FOnResponse) (*Connection, erro
 	if err != nil {
 		return nil, fmt.Errorf("could not create configuration: %v", err)
 	}
-	setupLoggin(cfg)
-	cl, err := getHTTPClient(cfg)
+	return newConnection(cfg, responseCallBack, nil, opts)
+}
+
+// NewWithSecrets returns a server connection like New, with the RP certificate and/or its password
+// retrieved from the secret provider sp instead of the config file
+func NewWithSecrets(configFileName string, responseCallBack FOnResponse, sp SecretProvider, opts ...Option) (*Connection, error) {
+	if responseCallBack == nil {
+		return nil, errors.New("no call back function provided")
+	}
+	cfg, err := config.New(configFileName)
 	if err != nil {
-		logprint(ERROR, "could not create an HTTP client:", err.Error())
-		return nil, fmt.Errorf("could not create an HTTP client: %v", err)
+		return nil, fmt.Errorf("could not create configuration: %v", err)
+	}
+	return newConnection(cfg, responseCallBack, sp, opts)
+}
+
+// NewFromConfig returns a server connection configured from cfg, e.g. built in code and readied with
+// cfg.Prepare, instead of read from a config file
+func NewFromConfig(cfg *config.Config, responseCallBack FOnResponse, opts ...Option) (*Connection, error) {
+	if responseCallBack == nil {
+		return nil, errors.New("no call back function provided")
+	}
+	if cfg == nil {
+		return nil, errors.New("no configuration provided")
 	}
+	return newConnection(cfg, responseCallBack, nil, opts)
+}
+
+// NewFromEnv returns a server connection configured from BANKID_* environment variables only, e.g.
+// BANKID_SERVICE_URL, BANKID_P12_PATH, BANKID_P12_PASSWORD and BANKID_LOG_LEVEL, without a config file
+func NewFromEnv(responseCallBack FOnResponse, opts ...Option) (*Connection, error) {
+	if responseCallBack == nil {
+		return nil, errors.New("no call back function provided")
+	}
+	cfg, err := config.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("could not create configuration: %v", err)
+	}
+	return newConnection(cfg, responseCallBack, nil, opts)
+}
+
+func newConnection(cfg *config.Config, responseCallBack FOnResponse, sp SecretProvider, opts []Option) (*Connection, error) {
 	var sc Connection
+	lg, logErr := newLogger(cfg)
+	lg.out.onFail = sc.warn
+	sc.log = lg
+	sc.secrets = sp
+	sc.cfg = cfg
+	// Every error from here on closes the connection, and with it the log file opened
+	sc.quit = make(chan struct{})
+	if cfg.Environment == config.EnvironmentSimulation {
+		sim, err := newSimulator(cfg, func() time.Time { return sc.clock.Now() })
+		if err != nil {
+			sc.logprint(ERROR, "could not create the simulator:", err.Error())
+			sc.Close()
+			return nil, fmt.Errorf("could not create the simulator: %v", err)
+		}
+		sc.sim = sim
+		sc.logprint(WARN, "simulation mode, no orders are sent to the BankID server")
+	}
+	if cfg.Recording.Mode != "" {
+		rec, err := newRecorder(cfg.RecordingFilePath(), cfg.Recording.Mode == "replay")
+		if err != nil {
+			sc.logprint(ERROR, "could not create the recorder:", err.Error())
+			sc.Close()
+			return nil, fmt.Errorf("could not create the recorder: %v", err)
+		}
+		sc.rec = rec
+		sc.logprint(WARN, cfg.Recording.Mode, "mode, the calls to the BankID server are in", cfg.RecordingFilePath())
+	}
 	sc.Version = version
 	sc.funcOnResponse = responseCallBack
-	sc.cfg = cfg
+	sc.sessions = make(map[string]*session)
+	sc.byPersonalNumber = make(map[string]*session)
+	sc.qrRenderer = defaultQRRenderer
+	sc.tracer = newTracer()
+	sc.metrics = noopMetrics{}
+	sc.codec = stdCodec{}
+	sc.clock = realClock{}
+	sc.localizer = CatalogLocalizer{}
+	sc.observers = make(map[string]func(Event))
+	if cfg.RateLimit > 0 {
+		sc.limiter = newTokenBucket(cfg.RateLimit, cfg.RateLimitBurst)
+	}
+	if cfg.Webhook.URL != "" {
+		sc.webhook = newWebhook(cfg.Webhook.URL, cfg.Webhook.Secret, cfg.Webhook.MaxRetries)
+	}
+	if logErr != nil {
+		sc.warn(logErr)
+	}
+	// The options are applied before the HTTP client is built, so that a client set with WithHTTPClient
+	// is wrapped by the simulator and the recorder as the one of the config file
+	for _, opt := range opts {
+		if err := opt(&sc); err != nil {
+			sc.Close()
+			return nil, fmt.Errorf("could not apply option: %v", err)
+		}
+	}
+	// The certificate may have been set by an option, e.g. WithSigner, or be presented by the client set
+	// with WithHTTPClient. No certificate is needed to simulate or replay orders
+	if !sc.clientCert.loaded() && !sc.offline() && sc.customClient == nil {
+		cert, err := loadClientCertificate(cfg, sp)
+		if err != nil {
+			sc.logprint(ERROR, "could not load client certificate:", err.Error())
+			sc.Close()
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		sc.clientCert.set(cert)
+	}
+	cl, err := sc.newHTTPClient(&sc.clientCert, sc.customClient)
+	if err != nil {
+		sc.logprint(ERROR, "could not create an HTTP client:", err.Error())
+		sc.Close()
+		return nil, fmt.Errorf("could not create an HTTP client: %v", err)
+	}
 	sc.httpClient = cl
-	sc.transQueues = make(map[string]chan byte)
-	sc.orderRefs = make(map[string]string)
-	sc.qrQuits = make(map[string]chan struct{})
-	sc.autoStarts = make(map[string]string)
+	for name, rc := range cfg.CertStore.Certificates {
+		cert, err := loadRPCertificate(cfg, rc)
+		if err == nil {
+			err = sc.AddCertificate(name, cert)
+		}
+		if err != nil {
+			sc.logprint(ERROR, "could not load client certificate", name+":", err.Error())
+			sc.Close()
+			return nil, fmt.Errorf("could not load client certificate %s: %v", name, err)
+		}
+	}
+	if na := sc.CertificateExpiry(); !na.IsZero() && cfg.CertExpiryMinDays > 0 && time.Until(na) < time.Duration(cfg.CertExpiryMinDays)*24*time.Hour {
+		sc.logprint(WARN, "RP certificate expires", na.Format(time.RFC3339))
+	}
+	tr, ok := sc.httpClient.Transport.(*http.Transport)
+	if rt, recording := sc.httpClient.Transport.(*recordTransport); recording {
+		tr, ok = rt.next.(*http.Transport)
+	}
+	if ok && cfg.ConnReapInterval > 0 {
+		sc.stats.goStart()
+		go reapIdleConnections(tr, time.Duration(cfg.ConnReapInterval)*time.Millisecond, &sc.stats, sc.quit)
+	}
+	if sc.auditor != nil && cfg.ReconcileInterval > 0 {
+		sc.stats.goStart()
+		go sc.reconcile(time.Duration(cfg.ReconcileInterval) * time.Millisecond)
+	}
+	if cfg.SessionReapInterval > 0 {
+		sc.stats.goStart()
+		go sc.reapSessions(time.Duration(cfg.SessionReapInterval) * time.Millisecond)
+	}
 	return &sc, nil
 }
 
-// SendRequest sends an auth/sign request to the BankID server. If textToBeSigned is provided it is a sign request,
-// otherwise it's an authentication request. Returns a request ID; the same as the requestID parameter if provided,
-// otherwise a generated one
+// SendRequest sends an auth/sign request to the BankID server. If textToBeSigned, or UserNonVisibleData in the
+// requirements, is provided it is a sign request, otherwise it's an authentication request. Returns a request ID;
+// the same as the requestID parameter if provided, otherwise a generated one. Authenticate and Sign, where the
+// type of request is explicit, are preferred
 func (sc *Connection) SendRequest(endUserIP, requestID, textToBeSigned string, requirements *Requirements, onQRCodeFunc FOnNewQRCode) string {
-	if requestID == "" {
-		requestID = xid.New().String()
-		logprint(DEBUG, "requestID", requestID, "created")
-	}
-	logprint(DEBUG, requestID, ": new request to send")
-	ch := make(chan byte, 1)
-	sc.transQueues[requestID] = ch
-	go sc.handleAuthSignRequest(endUserIP, textToBeSigned, requestID, requirements, ch, onQRCodeFunc)
-	return requestID
+	sign := textToBeSigned != "" || (requirements != nil && requirements.UserNonVisibleData != "")
+	r := newRequest(endUserIP, sign, textToBeSigned, []RequestOption{WithRequestID(requestID), WithRequirement(requirements), WithQRCallback(onQRCodeFunc)})
+	return sc.send(r)
 }
 
-// CancelRequest cancels an ongoing session
+// CancelRequest cancels an ongoing session. The order is cancelled at the BankID server by the go routine
+// handling the request, which then reports the status "cancelled"
 func (sc *Connection) CancelRequest(requestID string) {
-	if _, ex := sc.orderRefs[requestID]; !ex {
-		logprint(WARN, requestID, ": could not cancel requestID", requestID, " - not found")
-		sc.funcOnResponse(requestID, internalErrorMsg, "no session with provided ID")
+	s := sc.session(requestID)
+	if s == nil {
+		sc.logprint(WARN, requestID, ": could not cancel requestID", requestID, " - not found")
+		sc.respond(requestID, StatusError, "no session with provided ID")
 		return
 	}
-	delete(sc.orderRefs, requestID)
-	sc.transQueues[requestID] <- 1
+	s.requestCancel()
 }
 
 // GenerateQRCode generates a QR code based on the request ID received through the SendRequest function. The result is an PNG file
 // returned as a byte slice. Note that if an FOnNewQRCode function was passed as argument to the SendRequest function - meaning that
 // animated QR codes are to be used - the GenerateQRCode function will return an empty byte slice and an error
 func (sc *Connection) GenerateQRCode(reqID string, size int) ([]byte, error) {
-	if sc.qrQuits[reqID] != nil {
+	s := sc.session(reqID)
+	if s == nil {
+		return []byte{}, errors.New("Provided Request ID not found")
+	}
+	_, as, animated := s.order()
+	if animated {
 		return []byte{}, errors.New("Animated QR codes are used for this request")
 	}
-	as, ok := sc.autoStarts[reqID]
-	if !ok {
-		return []byte{}, errors.New("Provided Request ID not found")
+	if as == "" {
+		return []byte{}, errors.New("Order not yet started for the provided Request ID")
 	}
 	var png []byte
 	png, err := qrcode.Encode("bankid:///?autostarttoken="+as, qrcode.Low, size)
 	if err != nil {
-		logprint(ERROR, "", ": failed to generate static QR code", err.Error())
+		sc.logprint(ERROR, "", ": failed to generate static QR code", err.Error())
 		return []byte{}, errors.New("Failed to generate QR code")
 	}
 	return png, nil
 }
 
+// QRCode returns the content of the animated QR code of an order, secondsSinceStart seconds after the
+// order was started, from the qrStartToken and qrStartSecret of the order. It does not depend on a
+// Connection, so the QR codes can be generated in another process from persisted tokens, see
+// StartedOrder. The content is to be rendered as a QR code, e.g. with a QRRenderer
+func QRCode(qrStartToken, qrStartSecret string, secondsSinceStart int) (string, error) {
+	if qrStartToken == "" || qrStartSecret == "" {
+		return "", errors.New("qrStartToken and qrStartSecret must be provided")
+	}
+	if secondsSinceStart < 0 {
+		return "", errors.New("secondsSinceStart cannot be negative")
+	}
+	t := strconv.Itoa(secondsSinceStart)
+	h := hmac.New(sha256.New, []byte(qrStartSecret))
+	h.Write([]byte(t))
+	return "bankid." + qrStartToken + "." + t + "." + hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // Close the Connection
 func (sc *Connection) Close() {
 	// Todo: Loop through sc.transQueues and cancel any ongoing requests...
-	logprint(DEBUG, "log closing")
-	logFile.Close()
+	close(sc.quit)
+	if sc.poolKey != "" {
+		sc.pool.release(sc.poolKey, &sc.clientCert)
+	} else if sc.httpClient != nil {
+		sc.httpClient.CloseIdleConnections()
+	}
+	sc.certMu.RLock()
+	for _, nc := range sc.certs {
+		nc.client.CloseIdleConnections()
+	}
+	sc.certMu.RUnlock()
+	sc.logprint(DEBUG, "log closing")
+	sc.log.close()
 }
 
-func validateParameters(endUserIP, textToBeSigned, requestID string, requirements *Requirements) string {
-	if net.ParseIP(endUserIP) == nil {
-		logprint(ERROR, requestID, ": could not validate IP address", endUserIP)
-		return "invalid IP address: " + endUserIP
+func (sc *Connection) validateParameters(r *request, pnrPolicy PersonalNumberPolicy) string {
+	requestID, requirements := r.requestID, r.requirements
+	if r.phone {
+		if strings.Contains(sc.cfg.ServiceURL, "/rp/v5") {
+			sc.logprint(ERROR, requestID, ": phone order with API version 5")
+			return "phone orders require version 6.0 or later of the RP API"
+		}
+		if r.callInitiator != CallInitiatorUser && r.callInitiator != CallInitiatorRP {
+			sc.logprint(ERROR, requestID, ": invalid call initiator", r.callInitiator)
+			return "parameter callInitiator must be user or RP"
+		}
+		if requirements.PersonalNumber == "" {
+			sc.logprint(ERROR, requestID, ": phone order without personal number")
+			return "parameter personalNumber is required in phone orders"
+		}
+		// The end user is not in front of a screen, so the personal number policy does not apply
+		pnrPolicy = PersonalNumberAllow
+	} else if net.ParseIP(r.endUserIP) == nil {
+		sc.logprint(ERROR, requestID, ": could not validate IP address", r.endUserIP)
+		return "invalid IP address: " + r.endUserIP
+	}
+	hasNonVisible := r.userNonVisibleData != "" || (requirements != nil && requirements.UserNonVisibleData != "")
+	if r.sign && r.userVisibleData == "" {
+		sc.logprint(ERROR, requestID, ": sign request without userVisibleData")
+		return "parameter userVisibleData is required in sign requests"
 	}
-	if textToBeSigned != "" {
-		if err := validateTTBS(textToBeSigned); err != nil {
-			logprint(ERROR, requestID, ": could not validate textToBeSigned:", err.Error())
+	if !r.sign && hasNonVisible {
+		sc.logprint(ERROR, requestID, ": auth request with userNonVisibleData")
+		return "parameter userNonVisibleData is only allowed in sign requests"
+	}
+	if r.userVisibleData != "" {
+		if err := validateTTBS(r.userVisibleData); err != nil {
+			sc.logprint(ERROR, requestID, ": could not validate textToBeSigned:", err.Error())
 			return err.Error()
 		}
+		for _, w := range CheckUserVisibleData(r.userVisibleData) {
+			sc.logprint(WARN, requestID, ":", w)
+		}
+	}
+	if r.web != nil && r.app != nil {
+		sc.logprint(ERROR, requestID, ": both web and app set")
+		return "parameters web and app cannot both be set"
+	}
+	if r.returnURL != "" {
+		if u, err := url.Parse(r.returnURL); err != nil || !u.IsAbs() {
+			sc.logprint(ERROR, requestID, ": could not validate returnUrl")
+			return "parameter returnUrl must be an absolute URL"
+		}
+	}
+	if len(r.userNonVisibleData) > 200000 {
+		sc.logprint(ERROR, requestID, ": could not validate userNonVisibleData")
+		return "parameter userNonVisibleData data too long"
+	}
+	hasPnr := requirements != nil && requirements.PersonalNumber != ""
+	if pnrPolicy == PersonalNumberRequire && !hasPnr {
+		sc.logprint(ERROR, requestID, ": personal number required by policy but not provided")
+		return "parameter personalNumber is required"
+	}
+	if pnrPolicy == PersonalNumberForbid && hasPnr {
+		sc.logprint(ERROR, requestID, ": personal number provided but forbidden by policy")
+		return "parameter personalNumber is not allowed"
 	}
 	if requirements != nil {
-		logprint(DEBUG, requestID, ": requirements struct provided")
+		sc.logprint(DEBUG, requestID, ": requirements struct provided")
 		if err := validateRequirements(requirements); err != nil {
-			logprint(ERROR, requestID, ": could not validate requirements:", err.Error())
+			sc.logprint(ERROR, requestID, ": could not validate requirements:", err.Error())
 			return err.Error()
 		}
 	}
-	logprint(DEBUG, requestID, ": parameters validated")
+	sc.logprint(DEBUG, requestID, ": parameters validated")
 	return ""
 }
 
-func (sc *Connection) generateQRCode(qr1, qr2, requestID string, fOnCode FOnNewQRCode) chan struct{} {
+// generateQRCode passes a new QR code to fOnCode every second, until the returned function is called or
+// the session has ended, i.e. done is closed. The function returns once the last QR code has been passed
+func (sc *Connection) generateQRCode(qr1, qr2, requestID string, fOnCode FOnNewQRCode, done <-chan struct{}) func() {
 	if fOnCode == nil {
 		return nil
 	}
 
 	nr := 0
-	ticker := time.NewTicker(1 * time.Second)
-	quit := make(chan struct{})
+	ticker := sc.clock.NewTicker(1 * time.Second)
+	quit, stopped := make(chan struct{}), make(chan struct{})
+	atomic.AddInt64(&sc.stats.tickers, 1)
+	atomic.AddInt64(&sc.stats.channels, 1)
+	sc.stats.goStart()
 	go func() {
+		defer sc.stats.goStop()
+		defer close(stopped)
+		defer func() {
+			ticker.Stop()
+			atomic.AddInt64(&sc.stats.tickers, -1)
+			atomic.AddInt64(&sc.stats.channels, -1)
+		}()
 		for {
 			select {
-			case <-ticker.C:
-				var png []byte
-				h := hmac.New(sha256.New, []byte(qr2))
-				h.Write([]byte(strconv.Itoa(nr)))
-				png, err := qrcode.Encode("bankid."+qr1+"."+strconv.Itoa(nr)+"."+hex.EncodeToString(h.Sum(nil)), qrcode.Low, -5)
+			case <-ticker.C():
+				select {
+				case <-quit:
+					return // Stopped while the tick was pending
+				default:
+				}
+				content, err := QRCode(qr1, qr2, nr)
+				var img []byte
+				if err == nil {
+					var mimeType string
+					img, mimeType, err = sc.qrRenderer.Render(content)
+					sc.qrMimeType.Store(mimeType)
+				}
 				if err != nil {
-					logprint(ERROR, "", ": failed to generate QR code", err.Error())
-					sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
+					sc.logprint(ERROR, "", ": failed to generate QR code", err.Error())
+					sc.respond(requestID, StatusError, err.Error())
 				}
-				fOnCode(png, requestID)
+				sc.callback(requestID, "FOnNewQRCode", func() { fOnCode(img, requestID) })
 				nr++
+				if nr == sc.cfg.StaleQRAfter/1000 {
+					sc.qrStale(requestID, nr)
+				}
 			case <-quit:
-				ticker.Stop()
+				return
+			case <-done:
 				return
 			}
 		}
 	}()
-	return quit
-
-}
-
-func cancelQRCode(ch chan struct{}, fnq FOnNewQRCode) {
-	if fnq != nil {
-		close(ch)
+	return func() {
+		close(quit)
+		<-stopped
 	}
 }
 
-// handleAuthSignRequest is called as a go routine. Veryfies the request and, if validated,
-// transmits it to the server
+// handleAuthSignRequest is called as the go routine owning the session. Veryfies the request and, if
+// validated, transmits it to the server and polls the order until it has ended or is cancelled
 // Todo: Break this method up in pieces...
-func (sc *Connection) handleAuthSignRequest(endUserIP, textToBeSigned, requestID string, requirements *Requirements, queue chan byte, onQRCodeFunc FOnNewQRCode) {
-	if erMsg := validateParameters(endUserIP, textToBeSigned, requestID, requirements); erMsg != "" {
-		sc.funcOnResponse(requestID, internalErrorMsg, erMsg)
+func (sc *Connection) handleAuthSignRequest(s *session) {
+	r := s.req
+	requestID, onQRCodeFunc := r.requestID, r.onQRCode
+	if onQRCodeFunc == nil && r.onQRCodeCtx != nil {
+		onQRCodeFunc = func(qrCode []byte, requestID string) { r.onQRCodeCtx(s.ctx, qrCode, requestID) }
+	}
+	defer s.stopQR()
+	if r.sign {
+		sc.tracer.start(requestID, "sign")
+	} else {
+		sc.tracer.start(requestID, "auth")
+	}
+	client, cc, err := sc.clientFor(r.certName)
+	if err != nil {
+		sc.logprint(ERROR, requestID, ": request refused:", err.Error())
+		sc.tracer.record(requestID, "", 0, nil, err)
+		sc.respond(requestID, StatusError, err.Error())
+		return
+	}
+	if err := sc.checkCertificateExpiry(cc); err != nil {
+		sc.logprint(ERROR, requestID, ": request refused:", err.Error())
+		sc.tracer.record(requestID, "", 0, nil, err)
+		sc.emit(Event{RequestID: requestID, Status: StatusError, Message: err.Error(), Err: err})
 		return
 	}
+	if erMsg := sc.validateParameters(r, PersonalNumberPolicy(sc.cfg.PersonalNumberPolicy)); erMsg != "" {
+		sc.tracer.record(requestID, "", 0, nil, errors.New(erMsg))
+		sc.respond(requestID, StatusError, erMsg)
+		return
+	}
+	if r.requirements != nil {
+		if err := sc.claimPersonalNumber(s, r.requirements.PersonalNumber); err == errCancelledWhileQueued {
+			sc.logprint(DEBUG, requestID, ": cancelled while queued")
+			if err := s.cancelReason(); err != nil {
+				sc.emit(Event{RequestID: requestID, Status: StatusError, Message: err.Error(), Err: err})
+				return
+			}
+			sc.respond(requestID, StatusCancelled, "")
+			return
+		} else if err != nil {
+			sc.logprint(ERROR, requestID, ": request refused:", err.Error())
+			sc.tracer.record(requestID, "", 0, nil, err)
+			sc.emit(Event{RequestID: requestID, Status: StatusError, Message: err.Error(), Err: err})
+			return
+		}
+	}
+	r.requirements = environmentPolicies(r.requirements, sc.cfg.Environment)
+	if sc.cfg.MaxRisk != "" {
+		r.returnRisk = true
+	}
 	// Create and populate the auth/sign request going to the server...
-	reqType, jsonStr, err := requestToJSON(endUserIP, textToBeSigned, requestID, requirements)
+	reqType, jsonStr, err := requestToJSON(r, sc.codec)
 	if err != nil {
-		logprint(ERROR, requestID, ": could not create JSON from request:", err.Error())
-		sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
+		sc.logprint(ERROR, requestID, ": could not create JSON from request:", err.Error())
+		sc.respond(requestID, StatusError, err.Error())
 		return
 	}
 	// Handle the initial request/response with the server...
-	code, resp, err := sc.transmitRequest(reqType, jsonStr)
+	code, resp, err := sc.transmitRequest(client, reqType, jsonStr)
+	sc.tracer.record(requestID, reqType, code, resp, err)
+	if err == nil && sc.retryDuplicate(code, resp) {
+		sc.logprint(INFO, requestID, ": order already in progress for the user, retrying")
+		code, resp, err = sc.transmitRequest(client, reqType, jsonStr)
+		sc.tracer.record(requestID, reqType, code, resp, err)
+	}
 	if err != nil {
-		logprint(ERROR, requestID, ": failed to transmit request:", err.Error())
-		sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
+		sc.logprint(ERROR, requestID, ": failed to transmit request:", err.Error())
+		sc.respond(requestID, StatusError, err.Error())
 		return
 	}
 	if code != 200 {
 		er, msg := handleServerError(code, resp)
-		logprint(ERROR, requestID, ": received HTTP error", strconv.Itoa(code), ":", er, msg)
-		sc.funcOnResponse(requestID, er, msg)
+		sc.logprint(ERROR, requestID, ": received HTTP error", strconv.Itoa(code), ":", er, msg)
+		sc.respondServerError(requestID, er, msg)
 		return
 	}
 	var sr serverResponse // Should contain orderRef, autoStartToken, qrStartToken and qrStartSecret
-	err = json.Unmarshal(resp, &sr)
+	err = sc.codec.Unmarshal(resp, &sr)
 	if err != nil {
-		logprint(ERROR, requestID, ": failed to JSON decode server response:", err.Error())
-		sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
+		sc.logprint(ERROR, requestID, ": failed to JSON decode server response:", err.Error())
+		sc.respond(requestID, StatusError, err.Error())
 		return
 	}
 	or := sr.OrderRef
-	sc.orderRefs[requestID] = or
-	sr.Status = "pending"
+	s.setOrder(or, sr.AutoStartToken, onQRCodeFunc != nil)
+	sc.log.setOrderRef(requestID, or)
+	sr.Status = string(StatusPending)
 	sr.HintCode = ""
 	oldHint := sr.HintCode // Should be ""
-	sc.autoStarts[requestID] = sr.AutoStartToken
-	sc.funcOnResponse(requestID, "sent", sr.AutoStartToken)
-	if onQRCodeFunc != nil {
-		sc.qrQuits[requestID] = sc.generateQRCode(sr.QRStartToken, sr.QRStartSecret, requestID, onQRCodeFunc)
+	so := &StartedOrder{RequestID: requestID, OrderRef: or, EndUserIP: r.endUserIP, AutoStartToken: sr.AutoStartToken, QRStartToken: sr.QRStartToken, QRStartSecret: sr.QRStartSecret, OrderTime: sc.clock.Now()}
+	if r.onStarted != nil {
+		sc.callback(requestID, "FOnStarted", func() { r.onStarted(*so) })
 	}
-	for sr.Status == "pending" {
+	sc.emit(Event{RequestID: requestID, Status: StatusSent, Message: sr.AutoStartToken, Started: so})
+	s.startQR(sc.generateQRCode(sr.QRStartToken, sr.QRStartSecret, requestID, onQRCodeFunc, s.done))
+	for Status(sr.Status) == StatusPending {
 		select {
-		case _ = <-queue: // Cancel requested...
-			logprint(DEBUG, requestID, ": received cancel command")
-			cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-			code, resp, err = sc.transmitRequest("cancel", []byte(`{"orderRef":"`+or+`"}`))
+		case <-s.cancel: // Cancel requested...
+			sc.logprint(DEBUG, requestID, ": received cancel command")
+			s.stopQR()
+			code, resp, err = sc.transmitRequest(client, "cancel", []byte(`{"orderRef":"`+or+`"}`))
+			sc.tracer.record(requestID, "cancel", code, resp, err)
 			if err != nil {
-				logprint(ERROR, requestID, ": failed to send cancel request to server:", err.Error())
-				sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
+				sc.logprint(ERROR, requestID, ": failed to send cancel request to server:", err.Error())
+				sc.respond(requestID, StatusError, err.Error())
 				return
 			}
 			if code != 200 {
 				er, msg := handleServerError(code, resp)
-				logprint(ERROR, requestID, ": received HTTP error", strconv.Itoa(code), ":", er, msg)
-				sc.funcOnResponse(requestID, er, msg)
+				sc.logprint(ERROR, requestID, ": received HTTP error", strconv.Itoa(code), ":", er, msg)
+				sc.respondServerError(requestID, er, msg)
+				return
+			}
+			if err := s.cancelReason(); err != nil {
+				sc.emit(Event{RequestID: requestID, Status: StatusError, Message: err.Error(), Err: err})
 				return
 			}
-			delete(sc.transQueues, requestID)
-			logprint(DEBUG, requestID, ": cancelled")
-			sc.funcOnResponse(requestID, "cancelled", "")
+			sc.logprint(DEBUG, requestID, ": cancelled")
+			sc.respond(requestID, StatusCancelled, "")
 			return
 		default:
-			code, resp, err = sc.transmitRequest("collect", []byte(`{"orderRef":"`+or+`"}`))
+			// The response is decoded as read, into a buffer reused between polls
+			buf := bufPool.Get().(*bytes.Buffer)
+			buf.Reset()
+			code, err = sc.transmit(client, "collect", []byte(`{"orderRef":"`+or+`"}`), buf, &sr)
+			resp = buf.Bytes()
+			sc.tracer.record(requestID, "collect", code, resp, err)
 			if err != nil {
-				logprint(ERROR, requestID, ": failed to send collect request to server:", err.Error())
-				cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-				sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
+				bufPool.Put(buf)
+				sc.logprint(ERROR, requestID, ": failed to collect from server:", err.Error())
+				s.stopQR()
+				sc.respond(requestID, StatusError, err.Error())
 				return
 			}
 			if code != 200 {
 				er, msg := handleServerError(code, resp)
-				cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-				logprint(ERROR, requestID, ": received HTTP error", strconv.Itoa(code), ":", er, msg)
-				sc.funcOnResponse(requestID, er, msg)
+				bufPool.Put(buf)
+				s.stopQR()
+				sc.logprint(ERROR, requestID, ": received HTTP error", strconv.Itoa(code), ":", er, msg)
+				sc.respondServerError(requestID, er, msg)
 				return
 			}
-			err = json.Unmarshal(resp, &sr)
-			if err != nil {
-				logprint(ERROR, requestID, ": failed to JSON decode server response:", err.Error())
-				cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-				sc.funcOnResponse(requestID, internalErrorMsg, err.Error())
-				return
+			cr := sr.collectResponse(resp, r.rawResponse)
+			var cdExtra map[string]json.RawMessage
+			if Status(sr.Status) == StatusComplete {
+				cdExtra = completionExtra(resp)
+			}
+			bufPool.Put(buf)
+			switch Status(sr.Status) {
+			case StatusPending, StatusFailed, StatusComplete:
+			default:
+				// A status of a later API version; the order is polled on until it ends with a known one
+				sc.logprint(WARN, requestID, ": unknown status", sr.Status, "in response from server, polling on")
+				sr.Status = string(StatusPending)
 			}
-			switch sr.Status {
-			case "pending":
+			switch Status(sr.Status) {
+			case StatusPending:
+				if sr.HintCode != oldHint && !sr.HintCode.Pending() {
+					sc.logprint(WARN, requestID, ": unrecognized hint code", string(sr.HintCode), "for pending request")
+				}
 				if sr.HintCode != oldHint {
-					logprint(DEBUG, requestID, ": status changed to", sr.HintCode)
-					sc.funcOnResponse(requestID, sr.HintCode, sr.Status)
+					sc.logprint(DEBUG, requestID, ": status changed to", string(sr.HintCode))
+					sc.emit(Event{RequestID: requestID, Status: StatusPending, HintCode: sr.HintCode, Collect: cr})
 					oldHint = sr.HintCode
 				}
-				time.Sleep(time.Duration(sc.cfg.PollDelay) * time.Millisecond)
-			case "failed": // "failed" or "complete"
-				logprint(DEBUG, requestID, ": status changed to", sr.HintCode)
-				cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-				sc.funcOnResponse(requestID, sr.Status, sr.HintCode)
-				return
-			case "complete":
-				logprint(DEBUG, requestID, ": status changed to", sr.HintCode)
-				cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-				sc.funcOnResponse(requestID, sr.Status, sr.CompletionData.User.Name+"\n"+sr.CompletionData.User.PersonalNumber)
+				// Wait for the next collect, or until a cancel is requested
+				t := sc.clock.NewTimer(sc.pollDelay(sr.HintCode))
+				select {
+				case <-t.C():
+				case <-s.cancel:
+					t.Stop()
+				}
+			case StatusFailed:
+				sc.logprint(DEBUG, requestID, ": status changed to", string(sr.HintCode))
+				if !isKnownHint(sr.HintCode) {
+					sc.logprint(WARN, requestID, ": unrecognized hint code", string(sr.HintCode), "for failed request")
+				}
+				s.stopQR()
+				sc.emit(Event{RequestID: requestID, Status: StatusFailed, HintCode: sr.HintCode, Collect: cr})
 				return
-			default:
-				logprint(DEBUG, requestID, ": unknown status", sr.Status, "in response from server")
-				cancelQRCode(sc.qrQuits[requestID], onQRCodeFunc)
-				sc.funcOnResponse(requestID, internalErrorMsg, "unknown status in response from server")
+			case StatusComplete:
+				sc.logprint(DEBUG, requestID, ": status changed to", string(sr.HintCode))
+				s.stopQR()
+				if sc.pseudonymizer != nil {
+					sc.logprint(INFO, requestID, ": completed by", sc.pseudonymizer.Pseudonymize(sr.CompletionData.User.PersonalNumber))
+				}
+				cd := sr.completionData()
+				cd.Extra = cdExtra
+				cr.CompletionData = cd
+				if sc.cfg.VerifyOCSP {
+					if err := cd.VerifyOCSP(); err != nil {
+						sc.logprint(WARN, requestID, ": OCSP verification failed:", err.Error())
+					}
+				}
+				if err := sc.checkRisk(cd.Risk); err != nil {
+					sc.logprint(WARN, requestID, ": completion rejected, risk", cd.Risk+":", err.Error())
+					sc.emit(Event{RequestID: requestID, Status: StatusError, Message: err.Error(), Err: err, Collect: cr})
+					return
+				}
+				sc.emit(Event{RequestID: requestID, Status: StatusComplete, Message: sr.CompletionData.User.PersonalNumber, Completion: cd, Collect: cr})
 				return
 			}
 		}
 	}
 }
 
+// pollDelay returns the delay before the next collect of a request pending with the hint code.
+// Allows polling faster once the user has scanned the QR code, and slower while waiting for it
+func (sc *Connection) pollDelay(h HintCode) time.Duration {
+	if d, ok := sc.cfg.PollDelays[string(h)]; ok {
+		return time.Duration(d) * time.Millisecond
+	}
+	return time.Duration(sc.cfg.PollDelay) * time.Millisecond
+}
+
 // transmitRequest handles the communication with the server
 // Returns HTTP response code, HTTP body and an error
-func (sc *Connection) transmitRequest(reqType string, jsonStr []byte) (int, []byte, error) {
-	req, err := http.NewRequest("POST", sc.cfg.ServiceURL+"/"+reqType, bytes.NewBuffer(jsonStr))
+func (sc *Connection) transmitRequest(client *http.Client, reqType string, jsonStr []byte) (int, []byte, error) {
+	var buf bytes.Buffer
+	code, err := sc.transmit(client, reqType, jsonStr, &buf, nil)
 	if err != nil {
 		return 0, nil, err
 	}
-	req.Header.Set("Host", sc.cfg.HTTPClientConfig.RequestHeader.Host)
-	req.Header.Set("Content-Type", sc.cfg.HTTPClientConfig.RequestHeader.ContentType)
-	sc.mu.Lock()
-	resp, err := sc.httpClient.Do(req)
-	defer sc.mu.Unlock()
+	return code, buf.Bytes(), nil
+}
+
+// transmit sends a request to the server with client and reads the body of the response into buf. A
+// response with HTTP status 200 is also decoded into v, if not nil, as it is read. Returns the HTTP status
+func (sc *Connection) transmit(client *http.Client, reqType string, jsonStr []byte, buf *bytes.Buffer, v interface{}) (int, error) {
+	req, err := http.NewRequest("POST", sc.cfg.ServiceURL+"/"+reqType, bytes.NewBuffer(jsonStr))
 	if err != nil {
-		return 0, nil, err
+		return 0, err
 	}
-	defer resp.Body.Close()
-	bd, err := ioutil.ReadAll(resp.Body)
+	setRequestHeaders(req, sc.cfg)
+	if sc.cfg.WireDebug {
+		sc.logprint(DEBUG, "wire >", reqType, sc.log.body(jsonStr, sc.pseudonymizer))
+	}
+	hooked := sc.funcOnHTTPRequest != nil || sc.funcOnHTTPResponse != nil
+	var call HTTPCall
+	if hooked {
+		call = HTTPCall{Method: req.Method, URL: req.URL.String(), Endpoint: reqType, Request: sc.log.body(jsonStr, sc.pseudonymizer)}
+		sc.onHTTPRequest(call)
+	}
+	sc.waitRateLimit()
+	start := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
-		return 0, nil, err
+		sc.observeRequest(reqType, 0, start)
+		if hooked {
+			call.Latency, call.Err = time.Since(start), err
+			sc.onHTTPResponse(call)
+		}
+		return 0, err
+	}
+	sc.observeRequest(reqType, resp.StatusCode, start)
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == 200 {
+		if err := sc.codec.NewDecoder(io.TeeReader(resp.Body, buf)).Decode(v); err != nil {
+			err = fmt.Errorf("could not decode response: %v", err)
+			if hooked {
+				call.Status, call.Latency, call.Err = resp.StatusCode, time.Since(start), err
+				sc.onHTTPResponse(call)
+			}
+			return resp.StatusCode, err
+		}
+	}
+	// The rest of the body, or all of it if not decoded
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return 0, err
+	}
+	if sc.cfg.WireDebug {
+		sc.logprint(DEBUG, "wire <", reqType, strconv.Itoa(resp.StatusCode), sc.log.body(buf.Bytes(), sc.pseudonymizer))
+	}
+	if hooked {
+		call.Status, call.Response, call.Latency = resp.StatusCode, sc.log.body(buf.Bytes(), sc.pseudonymizer), time.Since(start)
+		sc.onHTTPResponse(call)
+	}
+	return resp.StatusCode, nil
+}
+
+// setRequestHeaders sets the Content-Type and User-Agent headers of a call to the BankID server, and
+// the extra headers of httpClientConfig.headers. The Host header is the host of serviceUrl, unless
+// overridden in the config file
+func setRequestHeaders(req *http.Request, cfg *config.Config) {
+	for name, value := range cfg.HTTPClientConfig.Headers {
+		req.Header.Set(name, value)
+	}
+	ct := cfg.HTTPClientConfig.RequestHeader.ContentType
+	if ct == "" {
+		ct = "application/json"
+	}
+	req.Header.Set("Content-Type", ct)
+	req.Header.Set("User-Agent", requestUserAgent(cfg))
+	if h := cfg.HTTPClientConfig.RequestHeader.Host; h != "" {
+		req.Host = h
 	}
-	return resp.StatusCode, bd, nil
 }
 
 // validateRequirements parses through the caller provided Requirements struct and checks to
-// verify that all parameters are correct. If so, a authSignRequestRequirements struct is
-// filled and the pointer to that struct is returned
+// verify that all parameters are correct. A provided personal number is normalized to the
+// 12 digit form expected by the BankID service
 func validateRequirements(req *Requirements) error {
 	if len(req.PersonalNumber) > 0 {
-		if _, err := strconv.Atoi(req.PersonalNumber); err != nil {
-			return errors.New("parameter personalNumber malformed")
-		}
-		if len(req.PersonalNumber) > 0 && len(req.PersonalNumber) != 12 {
-			return errors.New("parameter personalNumber must be 12 digits long")
+		pnr, err := personnummer.Normalize(req.PersonalNumber, time.Now())
+		if err != nil {
+			return fmt.Errorf("parameter personalNumber invalid: %v", err)
 		}
+		req.PersonalNumber = pnr
 	}
 	if len(req.UserNonVisibleData) > 200000 {
 		return errors.New("parameter userNonVisibleData data too long")
@@ -406,32 +834,37 @@ type authSignRequest struct {
 	UserVisibleData    string        `json:"userVisibleData,omitempty"`    // 2.000 bytes/chars
 	UserNonVisibleData string        `json:"userNonVisibleData,omitempty"` // 40.000 bytes/chars
 	Requirement        *Requirements `json:"requirement,omitempty"`
+	ReturnRisk         bool          `json:"returnRisk,omitempty"`
+	ReturnURL          string        `json:"returnUrl,omitempty"`
+	Web                *WebContext   `json:"web,omitempty"`
+	App                *AppContext   `json:"app,omitempty"`
 }
 
 type serverResponse struct {
-	AutoStartToken string `json:"autoStartToken,omitempty"` // Format: "131daac9-16c6-4618-beb0-365768f37288"
-	QRStartToken   string `json:"qrStartToken,omitempty"`
-	QRStartSecret  string `json:"qrStartSecret,omitempty"`
-	OrderRef       string `json:"orderRef,omitempty"`
-	Status         string `json:"status"`
-	HintCode       string `json:"hintCode,omitempty"`
+	AutoStartToken string   `json:"autoStartToken,omitempty"` // Format: "131daac9-16c6-4618-beb0-365768f37288"
+	QRStartToken   string   `json:"qrStartToken,omitempty"`
+	QRStartSecret  string   `json:"qrStartSecret,omitempty"`
+	OrderRef       string   `json:"orderRef,omitempty"`
+	Status         string   `json:"status"`
+	HintCode       HintCode `json:"hintCode,omitempty"`
 	CompletionData struct {
 		User struct {
 			PersonalNumber string `json:"personalNumber"`
 			Name           string `json:"name"`
 			GivenName      string `json:"givenName"`
 			Surname        string `json:"surname"`
-			Device         struct {
-				IPAddress string `json:"ipAddress,omitempty"`
-			} `json:"device,omitempty"`
-			Cert struct {
-				NotBefore string `json:"notBefore"`
-				NotAfter  string `json:"notAfter"`
-			} `json:"cert"`
-			Signature    string `json:"signature"`
-			OSCPResponse string `json:"ocspResponse"`
-		} `json:"user,omitempty"`
-	} `json:"completionData,omitempty"`
+		} `json:"user"`
+		Device struct {
+			IPAddress string `json:"ipAddress"`
+		} `json:"device"`
+		Cert struct {
+			NotBefore string `json:"notBefore"` // Unix time in milliseconds
+			NotAfter  string `json:"notAfter"`
+		} `json:"cert"`
+		Signature    string `json:"signature"`
+		OCSPResponse string `json:"ocspResponse"`
+		Risk         string `json:"risk,omitempty"`
+	} `json:"completionData"`
 }
 
 type serverError struct {
@@ -439,64 +872,145 @@ type serverError struct {
 	Details   string `json:"details"`
 }
 
-func requestToJSON(endUserIP, textToBeSigned, requestID string, requirements *Requirements) (string, []byte, error) {
+// phoneRequest is the phone/auth and phone/sign request, converted to JSON before sent to the server
+type phoneRequest struct {
+	PersonalNumber     string        `json:"personalNumber"`
+	CallInitiator      string        `json:"callInitiator"`                // "user" or "RP"
+	UserVisibleData    string        `json:"userVisibleData,omitempty"`    // 2.000 bytes/chars
+	UserNonVisibleData string        `json:"userNonVisibleData,omitempty"` // 40.000 bytes/chars
+	Requirement        *Requirements `json:"requirement,omitempty"`
+	ReturnRisk         bool          `json:"returnRisk,omitempty"`
+}
+
+func requestToJSON(r *request, c Codec) (string, []byte, error) {
 	reqType := "auth"
+	if r.sign {
+		reqType = "sign"
+	}
+	if r.phone {
+		req := phoneRequest{PersonalNumber: r.requirements.PersonalNumber, CallInitiator: r.callInitiator, UserVisibleData: r.userVisibleData, UserNonVisibleData: r.userNonVisibleData, Requirement: r.requirements, ReturnRisk: r.returnRisk}
+		if req.UserNonVisibleData == "" {
+			req.UserNonVisibleData = r.requirements.UserNonVisibleData
+		}
+		json, err := c.Marshal(req)
+		return "phone/" + reqType, json, err
+	}
 	var req authSignRequest
-	req.RequestID = requestID
-	req.EndUserIP = endUserIP
-	req.UserVisibleData = textToBeSigned
-	req.Requirement = requirements
-	if requirements != nil {
-		if requirements.UserNonVisibleData != "" {
-			req.UserNonVisibleData = requirements.UserNonVisibleData
-			reqType = "sign"
+	req.RequestID = r.requestID
+	req.EndUserIP = r.endUserIP
+	req.UserVisibleData = r.userVisibleData
+	req.UserNonVisibleData = r.userNonVisibleData
+	req.Requirement = r.requirements
+	req.ReturnRisk = r.returnRisk
+	req.ReturnURL, req.Web, req.App = r.returnURL, r.web, r.app
+	if r.requirements != nil {
+		if req.UserNonVisibleData == "" {
+			req.UserNonVisibleData = r.requirements.UserNonVisibleData
 		}
-		req.PersonalNumber = requirements.PersonalNumber
+		req.PersonalNumber = r.requirements.PersonalNumber
 	}
-	json, err := json.Marshal(req)
+	json, err := c.Marshal(req)
 	return reqType, json, err
 }
 
 func handleServerError(code int, resp []byte) (string, string) {
 	var se serverError
 	if err := json.Unmarshal(resp, &se); err != nil {
-		return internalErrorMsg, err.Error()
+		return string(StatusError), err.Error()
 	}
 	return se.ErrorCode, se.Details
 }
 
+// newHTTPClient returns the client of the calls to the BankID server made with the certificate cc, or
+// a copy of custom if set, or one calling the simulator. The transport of the certificate of the
+// connection is shared with the tenants of a Manager calling the same host. The calls are recorded or
+// replayed with a recording section
+func (sc *Connection) newHTTPClient(cc *clientCert, custom *http.Client) (*http.Client, error) {
+	var (
+		cl  *http.Client
+		err error
+	)
+	switch {
+	case sc.rec != nil && sc.rec.replay:
+		cl = &http.Client{}
+	case sc.sim != nil:
+		cl = &http.Client{Transport: sc.sim}
+	case custom != nil:
+		c := *custom
+		if c.Transport == nil {
+			c.Transport = http.DefaultTransport
+		}
+		cl = &c
+	case cc == &sc.clientCert && sc.pool != nil:
+		tr, key, err := sc.pool.acquire(sc.cfg, &sc.stats, cc)
+		if err != nil {
+			return nil, err
+		}
+		sc.poolKey = key
+		cl = &http.Client{Transport: tr}
+	default:
+		if cl, err = getHTTPClient(sc.cfg, &sc.stats, cc); err != nil {
+			return nil, err
+		}
+	}
+	if sc.rec != nil {
+		cl.Transport = sc.rec.transport(cl.Transport)
+	}
+	return cl, nil
+}
+
+// offline reports whether the connection never calls the BankID server, simulating or replaying
+// the orders
+func (sc *Connection) offline() bool {
+	return sc.sim != nil || (sc.rec != nil && sc.rec.replay)
+}
+
 // Initialize a http.Client
-func getHTTPClient(cfg *config.Config) (*http.Client, error) {
-	tlsCfg, err := getTLSConfig(cfg)
+func getHTTPClient(cfg *config.Config, st *stats, cc *clientCert) (*http.Client, error) {
+	tr, err := newTransport(cfg, st, cc)
 	if err != nil {
 		return nil, err
 	}
-	tr := &http.Transport{TLSClientConfig: tlsCfg}
 	return &http.Client{Transport: tr}, nil
 }
 
-// Initialize a tls.Config struct based on the client and server certs
-func getTLSConfig(cfg *config.Config) (*tls.Config, error) {
-	// Todo: Handle case where P12 is split into cert and key file
-	p12, err := ioutil.ReadFile(cfg.GetFilePath("userP12FileName"))
+// newTransport returns the transport of the calls to the BankID server made with the certificate cc
+func newTransport(cfg *config.Config, st *stats, cc *clientCert) (*http.Transport, error) {
+	tlsCfg, err := getTLSConfig(cfg, cc)
 	if err != nil {
 		return nil, err
 	}
-	blocks, err := pkcs12.ToPEM(p12, cfg.CertStore.UserPrivateKeyPassword)
-	if err != nil {
-		return nil, err
-	}
-	var pemData []byte
-	for _, b := range blocks {
-		pemData = append(pemData, pem.EncodeToMemory(b)...)
+	return &http.Transport{
+		Proxy:               proxyFunc(cfg),
+		TLSClientConfig:     tlsCfg,
+		DialContext:         countingDialer(st),
+		IdleConnTimeout:     time.Duration(cfg.IdleConnTimeout) * time.Millisecond,
+		MaxConnsPerHost:     maxConnsPerHost,
+		MaxIdleConnsPerHost: maxConnsPerHost,
+	}, nil
+}
+
+// proxyFunc returns the proxy of the calls to the BankID server, the one of the proxy section of the
+// config file if set, otherwise the one of the HTTPS_PROXY and NO_PROXY environment variables
+func proxyFunc(cfg *config.Config) func(*http.Request) (*url.URL, error) {
+	if cfg.Proxy.URL == "" {
+		return http.ProxyFromEnvironment
 	}
-	cert, err := tls.X509KeyPair(pemData, pemData)
+	u, err := url.Parse(cfg.Proxy.URL)
 	if err != nil {
-		return nil, err
+		return func(*http.Request) (*url.URL, error) { return nil, fmt.Errorf("invalid proxy URL: %v", err) }
 	}
+	if cfg.Proxy.Username != "" {
+		u.User = url.UserPassword(cfg.Proxy.Username, cfg.Proxy.Password)
+	}
+	return http.ProxyURL(u)
+}
 
+// Initialize a tls.Config struct based on the client and server certs. The client certificate is
+// taken from cc at every handshake, so that it can be replaced at runtime
+func getTLSConfig(cfg *config.Config, cc *clientCert) (*tls.Config, error) {
 	// Handle the CA certificate
-	ca, err := ioutil.ReadFile(cfg.GetFilePath("caCertFileName"))
+	ca, err := serverRootCA(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -506,51 +1020,18 @@ func getTLSConfig(cfg *config.Config) (*tls.Config, error) {
 	}
 
 	tlsCfg := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		ClientCAs:          certPool,
-		InsecureSkipVerify: true, // <- This to accept the self-signed CA cert
+		GetClientCertificate: cc.get,
+		RootCAs:              certPool,
+		MinVersion:           cfg.TLSMinVersion(),
+		CipherSuites:         cfg.TLSCipherSuites(),
 	}
 	return tlsCfg, nil
 }
 
 func validateTTBS(ttbs string) error {
 	// TODO: Validate that ttbs is valid Base64
-	if len(ttbs) > 40000 {
+	if len(ttbs) > maxUserVisibleData {
 		return errors.New("parameter userVisibleData data too long")
 	}
 	return nil
 }
-
-func setupLoggin(cfg *config.Config) {
-	logLevel = cfg.LogLevel
-	logLevels = cfg.LogPrefixes
-	log.SetOutput(os.Stderr)
-	if cfg.LogLevel < 1 {
-		return
-	}
-	if cfg.LogFileName != "" {
-		lf, err := os.OpenFile(cfg.GetFilePath("logFile"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-		if err != nil {
-			logprint(ERROR, "could not open log file", cfg.GetFilePath("logFile"), ":", err.Error())
-			return
-		}
-		logFile = lf
-		log.SetOutput(lf)
-		logprint(DEBUG, "log started")
-	}
-}
-
-func logprint(lvl int, a ...string) {
-	if logLevel < 1 || lvl+1 < logLevel || lvl < 0 {
-		return
-	}
-	if lvl >= len(logLevels) {
-		lvl = len(logLevels)
-		log.Println("ERROR: missing log level prefixes in config file!")
-	}
-	if lvl < 0 {
-		log.Println("ERROR:", a)
-		return
-	}
-	log.Println(logLevels[lvl], a)
-}
@@ -0,0 +1,208 @@
+package bankid
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+// fastClock is a Clock running speedup times faster than the wall clock, so that simulated orders go
+// through their hint codes, QR codes and collects in milliseconds, with the timing of real ones
+type fastClock struct {
+	start   time.Time
+	speedup time.Duration
+}
+
+func (c fastClock) Now() time.Time                   { return c.start.Add(time.Since(c.start) * c.speedup) }
+func (c fastClock) NewTicker(d time.Duration) Ticker { return realClock{}.NewTicker(c.scale(d)) }
+func (c fastClock) NewTimer(d time.Duration) Timer   { return realClock{}.NewTimer(c.scale(d)) }
+
+func (c fastClock) scale(d time.Duration) time.Duration {
+	if d /= c.speedup; d <= 0 {
+		return 1
+	}
+	return d
+}
+
+// newSimConnection returns a connection with environment "simulation", running speedup times faster
+// than the wall clock; a simulated order takes 8 seconds, and gets a QR code every second
+func newSimConnection(tb testing.TB, speedup time.Duration, f FOnResponse, opts ...Option) *Connection {
+	tb.Helper()
+	cfg, err := config.FromReader(strings.NewReader(`{"environment": "simulation", "pollDelay": 2000, "enableLogging": false}`), "config.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sc, err := NewFromConfig(cfg, f, append([]Option{WithClock(fastClock{start: time.Now(), speedup: speedup})}, opts...)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(sc.Close)
+	return sc
+}
+
+// responseLog records the FOnResponse and FOnNewQRCode calls of every request
+type responseLog struct {
+	mu    sync.Mutex
+	calls map[string][]string // "status message", or "qrcode", by request ID
+	final map[string]chan struct{}
+}
+
+func newResponseLog() *responseLog {
+	return &responseLog{calls: make(map[string][]string), final: make(map[string]chan struct{})}
+}
+
+func (l *responseLog) onResponse(requestID, status, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls[requestID] = append(l.calls[requestID], status+" "+message)
+	if status != string(StatusSent) && message != string(StatusPending) {
+		if ch, ok := l.final[requestID]; ok {
+			close(ch)
+			delete(l.final, requestID)
+		}
+	}
+}
+
+func (l *responseLog) onQRCode(qrCode []byte, requestID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls[requestID] = append(l.calls[requestID], "qrcode")
+}
+
+// expect returns a channel closed by the first final status of requestID
+func (l *responseLog) expect(requestID string) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan struct{})
+	l.final[requestID] = ch
+	return ch
+}
+
+// TestCancelRequestConcurrently cancels orders at all points of their lifetime, while QR codes are
+// generated and the orders complete, to be run with -race. Every order must end once, either
+// completed or cancelled, and get neither QR codes nor status updates after that, but for cancels of
+// an ended order
+func TestCancelRequestConcurrently(t *testing.T) {
+	log := newResponseLog()
+	sc := newSimConnection(t, 100, log.onResponse)
+
+	const orders = 40
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		requestID := fmt.Sprintf("req-%d", i)
+		done := log.expect(requestID)
+		sc.Authenticate("127.0.0.1", WithRequestID(requestID), WithQRCallback(log.onQRCode))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Spread the cancels from before the order is sent to after it has completed, at 80 ms
+			time.Sleep(time.Duration(i%20) * 5 * time.Millisecond)
+			sc.CancelRequest(requestID)
+			if i%3 == 0 {
+				sc.CancelRequest(requestID) // Twice, racing with itself
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s: no final status", requestID)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	for i := 0; i < orders; i++ {
+		requestID := fmt.Sprintf("req-%d", i)
+		calls := log.calls[requestID]
+		final := -1
+		for j, c := range calls {
+			if c != "qrcode" && !strings.HasPrefix(c, string(StatusSent)+" ") && !strings.HasSuffix(c, " "+string(StatusPending)) {
+				final = j
+				break
+			}
+		}
+		if final < 0 {
+			t.Errorf("%s: no final status in %q", requestID, calls)
+			continue
+		}
+		switch {
+		case calls[final] == string(StatusComplete)+" "+sc.cfg.Simulation.User.PersonalNumber:
+		case strings.HasPrefix(calls[final], string(StatusCancelled)+" "):
+		default:
+			t.Errorf("%s: ended with %q, want complete or cancelled", requestID, calls[final])
+		}
+		for _, c := range calls[final+1:] {
+			if c != string(StatusError)+" no session with provided ID" {
+				t.Errorf("%s: %q after the final status %q", requestID, c, calls[final])
+			}
+		}
+	}
+}
+
+// TestQRMimeType checks that the MIME type of the QR codes is the one of the renderer set
+func TestQRMimeType(t *testing.T) {
+	sc := newSimConnection(t, 100, func(string, string, string) {})
+	sc.SetQRRenderer(SVGRenderer{})
+	if got := sc.QRMimeType(); got != "image/png" {
+		t.Errorf("before any QR code got %q, want image/png", got)
+	}
+	codes := make(chan string, 1)
+	requestID := sc.Authenticate("127.0.0.1", WithQRCallback(func(qrCode []byte, requestID string) {
+		select {
+		case codes <- sc.QRMimeType():
+		default:
+		}
+	}))
+	defer sc.CancelRequest(requestID)
+	select {
+	case got := <-codes:
+		if got != "image/svg+xml" {
+			t.Errorf("got %q, want image/svg+xml", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no QR code")
+	}
+}
+
+// BenchmarkSendCollect sends authentication orders to the simulator and collects them until they have
+// completed, 10000 times faster than the wall clock: each order is sent, collected about four times
+// through its hint codes until completed, and its events delivered
+func BenchmarkSendCollect(b *testing.B) {
+	for _, parallel := range []bool{false, true} {
+		name := "sequential"
+		if parallel {
+			name = "parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			log := newResponseLog()
+			sc := newSimConnection(b, 10000, log.onResponse)
+			var n int64
+			run := func() {
+				requestID := fmt.Sprintf("req-%d", atomic.AddInt64(&n, 1))
+				done := log.expect(requestID)
+				sc.Authenticate("192.0.2.57", WithRequestID(requestID))
+				<-done
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			if parallel {
+				b.SetParallelism(100) // Orders mostly wait between collects, so run many per CPU
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						run()
+					}
+				})
+				return
+			}
+			for i := 0; i < b.N; i++ {
+				run()
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package bankid
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// BatchOutcome is the aggregate result of a batch of sign orders, see SignBatch
+type BatchOutcome string
+
+// The outcomes of a batch
+const (
+	BatchAllSigned BatchOutcome = "allSigned" // Every order completed
+	BatchPartial   BatchOutcome = "partial"   // Every order ended, but not all completed
+	BatchTimedOut  BatchOutcome = "timedOut"  // The timeout passed, the orders still pending were cancelled
+)
+
+// Signer is one of the users expected to sign in a batch
+type Signer struct {
+	EndUserIP      string
+	PersonalNumber string
+}
+
+// BatchOrder is the state of the order of one signer in a batch
+type BatchOrder struct {
+	Signer     Signer
+	RequestID  string
+	Status     Status
+	HintCode   HintCode
+	Completion *CompletionData // Set when Status is StatusComplete
+}
+
+// BatchResult is delivered once every order of a batch has ended, or the timeout has passed
+type BatchResult struct {
+	Outcome BatchOutcome
+	Orders  []BatchOrder // In the order of the signers passed to SignBatch
+}
+
+// Batch is a batch of sign orders started with SignBatch
+type Batch struct {
+	RequestIDs []string           // The request IDs used in call backs, in the order of the signers
+	Result     <-chan BatchResult // Receives the result, once
+}
+
+// SignBatch starts one order per signer to sign the same userVisibleData, e.g. a contract, each
+// restricted to the personal number of the signer. The options, apart from WithRequestID, are applied
+// to every order. The orders are reported to the call back functions as usual; in addition the
+// aggregate result is sent on Batch.Result once every order has ended, or when timeout has passed,
+// in which case the orders still pending are cancelled. A timeout of 0 waits until every order has ended.
+// A batch without signers ends at once, with no orders and the outcome BatchAllSigned
+func (sc *Connection) SignBatch(signers []Signer, userVisibleData string, timeout time.Duration, opts ...RequestOption) *Batch {
+	res := make(chan BatchResult, 1)
+	b := &batch{orders: make([]BatchOrder, len(signers)), index: make(map[string]int), done: make(chan struct{})}
+	reqs := make([]*request, len(signers))
+	ids := make([]string, len(signers))
+	for i, s := range signers {
+		r := newRequest(s.EndUserIP, true, userVisibleData, opts)
+		r.requestID = xid.New().String()
+		reqs[i] = r
+		ids[i] = r.requestID
+		b.orders[i] = BatchOrder{Signer: s, RequestID: r.requestID, Status: StatusSent}
+		b.index[r.requestID] = i
+		b.pending++
+		// The requirements are normalized when validated, so every order needs its own copy
+		var req Requirements
+		if r.requirements != nil {
+			req = *r.requirements
+		}
+		req.PersonalNumber = s.PersonalNumber
+		r.requirements = &req
+		sc.observe(r.requestID, b.update)
+	}
+	if len(signers) == 0 {
+		close(b.done)
+	}
+	for _, r := range reqs {
+		sc.send(r)
+	}
+	sc.stats.goStart()
+	go func() {
+		defer sc.stats.goStop()
+		var timer <-chan time.Time
+		if timeout > 0 {
+			t := sc.clock.NewTimer(timeout)
+			defer t.Stop()
+			timer = t.C()
+		}
+		outcome := BatchPartial
+		select {
+		case <-b.done:
+		case <-timer:
+			outcome = BatchTimedOut
+			for _, id := range b.unfinished() {
+				sc.CancelRequest(id)
+			}
+		}
+		for _, id := range ids {
+			sc.unobserve(id)
+		}
+		orders := b.snapshot()
+		if outcome != BatchTimedOut && allComplete(orders) {
+			outcome = BatchAllSigned
+		}
+		res <- BatchResult{Outcome: outcome, Orders: orders}
+	}()
+	return &Batch{RequestIDs: ids, Result: res}
+}
+
+// batch tracks the orders of a Batch
+type batch struct {
+	mu      sync.Mutex
+	orders  []BatchOrder
+	index   map[string]int
+	pending int
+	done    chan struct{}
+}
+
+// update records a status update of one of the orders
+func (b *batch) update(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i, ok := b.index[ev.RequestID]
+	if !ok || b.orders[i].Status.final() {
+		return
+	}
+	o := &b.orders[i]
+	o.Status, o.HintCode, o.Completion = ev.Status, ev.HintCode, ev.Completion
+	if o.Status.final() {
+		b.pending--
+		if b.pending == 0 {
+			close(b.done)
+		}
+	}
+}
+
+// unfinished returns the request IDs of the orders that have not ended
+func (b *batch) unfinished() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []string
+	for _, o := range b.orders {
+		if !o.Status.final() {
+			ids = append(ids, o.RequestID)
+		}
+	}
+	return ids
+}
+
+func (b *batch) snapshot() []BatchOrder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BatchOrder(nil), b.orders...)
+}
+
+func allComplete(orders []BatchOrder) bool {
+	for _, o := range orders {
+		if o.Status != StatusComplete {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package bankid
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSignBatch(t *testing.T) {
+	sc := newSimConnection(t, 1000, func(requestID, status, message string) {})
+	signers := []Signer{{EndUserIP: "192.0.2.1", PersonalNumber: "198112289874"}, {EndUserIP: "192.0.2.2", PersonalNumber: "190101019990"}}
+	b := sc.SignBatch(signers, "Contract", 0)
+	select {
+	case res := <-b.Result:
+		if res.Outcome != BatchAllSigned {
+			t.Errorf("outcome %s, want %s", res.Outcome, BatchAllSigned)
+		}
+		for i, o := range res.Orders {
+			if o.RequestID != b.RequestIDs[i] || o.Signer != signers[i] {
+				t.Errorf("order %d: %+v, want signer %+v and request ID %s", i, o, signers[i], b.RequestIDs[i])
+			}
+			if o.Completion == nil || o.Completion.User.PersonalNumber != signers[i].PersonalNumber {
+				t.Errorf("order %d: completion %+v, want one of %s", i, o.Completion, signers[i].PersonalNumber)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+	}
+}
+
+// TestSignBatchTimeout checks that the timeout of a batch is measured with the clock of the connection,
+// the orders of the simulator taking 8 seconds of it
+func TestSignBatchTimeout(t *testing.T) {
+	sc := newSimConnection(t, 1000, func(requestID, status, message string) {})
+	b := sc.SignBatch([]Signer{{EndUserIP: "192.0.2.1", PersonalNumber: "198112289874"}}, "Contract", 4*time.Second)
+	select {
+	case res := <-b.Result:
+		if res.Outcome != BatchTimedOut {
+			t.Errorf("outcome %s, want %s", res.Outcome, BatchTimedOut)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+}
+
+// TestSignBatchEmpty checks that a batch without signers ends at once, without a timeout, instead of
+// leaving its go routine waiting forever
+func TestSignBatchEmpty(t *testing.T) {
+	sc := newSimConnection(t, 1000, func(requestID, status, message string) {})
+	before := runtime.NumGoroutine()
+	for _, signers := range [][]Signer{nil, {}} {
+		b := sc.SignBatch(signers, "Contract", 0)
+		select {
+		case res := <-b.Result:
+			if res.Outcome != BatchAllSigned || len(res.Orders) != 0 || len(b.RequestIDs) != 0 {
+				t.Errorf("got %+v, request IDs %v, want no orders", res, b.RequestIDs)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no result")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d go routines left behind", n-before)
+	}
+}
@@ -0,0 +1,344 @@
+package bankid
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed" // For the bundled CA certificates
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid/certstore"
+	"github.com/hossner/bankid/config"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// ErrCertificateExpiring is the error reported for requests refused because the RP certificate is
+// within certExpiryMinDays of its expiry, see the config file setting of the same name
+var ErrCertificateExpiring = errors.New("RP certificate is about to expire, new orders are refused")
+
+// testRootCA is the root certificate of the BankID test environment, "Test BankID SSL Root CA v1 Test"
+//
+//go:embed certs/test_root_ca.crt
+var testRootCA []byte
+
+// bundledRootCAs maps the BankID environments to their bundled root certificates. That of the
+// production environment, "BankID SSL Root CA v1", is not bundled yet, so it has to be set with
+// caCertFileName
+var bundledRootCAs = map[config.Environment][]byte{
+	config.EnvironmentTest: testRootCA,
+}
+
+// serverRootCA returns the PEM encoded root certificate used to verify the BankID server. A CA
+// certificate set in the config file takes precedence over the bundled ones
+func serverRootCA(cfg *config.Config) ([]byte, error) {
+	if cfg.CertStore.CACertFileName != "" {
+		return cfg.ReadCertFile(cfg.CertStore.CACertFileName)
+	}
+	env := cfg.BankIDEnvironment()
+	if ca, ok := bundledRootCAs[env]; ok {
+		return ca, nil
+	}
+	if env == config.EnvironmentProduction {
+		return nil, errors.New("the root certificate of the production environment, BankID SSL Root CA v1, is not bundled, caCertFileName must be set")
+	}
+	u, err := url.Parse(cfg.ServiceURL)
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no bundled CA certificate for " + u.Hostname() + ", caCertFileName must be set")
+}
+
+// clientCert holds the RP client certificate presented to the BankID server
+type clientCert struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (cc *clientCert) set(cert tls.Certificate) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.cert = &cert
+}
+
+// loaded reports whether a certificate has been set
+func (cc *clientCert) loaded() bool {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.cert != nil
+}
+
+// get is used as GetClientCertificate in the tls.Config
+func (cc *clientCert) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.cert, nil
+}
+
+// signer returns the private key of the current certificate
+func (cc *clientCert) signer() (crypto.Signer, error) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if cc.cert == nil {
+		return nil, errors.New("no RP certificate loaded")
+	}
+	key, ok := cc.cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the private key of the RP certificate cannot sign")
+	}
+	return key, nil
+}
+
+// notAfter returns the expiry time of the current certificate, or the zero time if not known
+func (cc *clientCert) notAfter() time.Time {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if cc.cert == nil || len(cc.cert.Certificate) == 0 {
+		return time.Time{}
+	}
+	leaf := cc.cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cc.cert.Certificate[0]); err != nil {
+			return time.Time{}
+		}
+	}
+	return leaf.NotAfter
+}
+
+// loadClientCertificate reads the RP client certificate and key from the P12 file, using the password,
+// in the config. Either may instead come from the secret provider sp, if not nil. Without a P12 from
+// the secret provider, the certificate with userCertThumbprint is taken from the certificate store of
+// the operating system, if set
+func loadClientCertificate(cfg *config.Config, sp SecretProvider) (tls.Certificate, error) {
+	// Todo: Handle case where P12 is split into cert and key file
+	var p12 []byte
+	password := cfg.CertStore.UserPrivateKeyPassword
+	if sp != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+		defer cancel()
+		var err error
+		if p12, err = sp.P12(ctx); err != nil {
+			return tls.Certificate{}, fmt.Errorf("could not get P12 from secret provider: %v", err)
+		}
+		pw, err := sp.P12Password(ctx)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("could not get P12 password from secret provider: %v", err)
+		}
+		if pw != "" {
+			password = pw
+		}
+	}
+	if p12 == nil && cfg.CertStore.UserCertThumbprint != "" {
+		key, cert, err := certstore.Find(cfg.CertStore.UserCertThumbprint)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("could not find the certificate %s: %v", cfg.CertStore.UserCertThumbprint, err)
+		}
+		return CertificateFromSigner(key, cert)
+	}
+	if password == "" && cfg.StrictSecrets {
+		return tls.Certificate{}, errors.New("strictSecrets requires the P12 password in " + config.EnvP12Password + " or from the secret provider")
+	}
+	if p12 == nil {
+		if cfg.CertStore.UserP12FileName == "" {
+			return tls.Certificate{}, errors.New("no P12 file configured")
+		}
+		var err error
+		if p12, err = cfg.ReadCertFile(cfg.CertStore.UserP12FileName); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return parseP12(p12, password)
+}
+
+// ReadP12 reads an RP client certificate and its private key from a P12 file read from r, e.g. to
+// replace the certificate in use with SetCertificate
+func ReadP12(r io.Reader, password string) (tls.Certificate, error) {
+	p12, err := io.ReadAll(r)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return parseP12(p12, password)
+}
+
+func parseP12(p12 []byte, password string) (tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(p12, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var pemData []byte
+	for _, b := range blocks {
+		pemData = append(pemData, pem.EncodeToMemory(b)...)
+	}
+	return tls.X509KeyPair(pemData, pemData)
+}
+
+// CertificateFromSigner returns an RP client certificate whose private key is held by key, e.g. in an
+// HSM or a PKCS#11 token, so that the key never exists as a file. chain holds the certificate, first,
+// and any intermediate certificates. The certificate must be that of the public key of key
+func CertificateFromSigner(key crypto.Signer, chain ...*x509.Certificate) (tls.Certificate, error) {
+	if key == nil {
+		return tls.Certificate{}, errors.New("no private key provided")
+	}
+	if len(chain) == 0 {
+		return tls.Certificate{}, errors.New("no certificate provided")
+	}
+	pub, ok := chain[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(key.Public()) {
+		return tls.Certificate{}, errors.New("the certificate does not match the private key")
+	}
+	cert := tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+// readCertChain reads the certificate chain of the PEM file userCertFileName of the certStore section
+func readCertChain(cfg *config.Config) ([]*x509.Certificate, error) {
+	if cfg.CertStore.UserCertFileName == "" {
+		return nil, errors.New("no certificate provided, and no userCertFileName configured")
+	}
+	data, err := cfg.ReadCertFile(cfg.CertStore.UserCertFileName)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var b *pem.Block
+		if b, data = pem.Decode(data); b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", cfg.CertStore.UserCertFileName, err)
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate found in " + cfg.CertStore.UserCertFileName)
+	}
+	return chain, nil
+}
+
+// SetCertificate replaces the RP client certificate used for new connections to the BankID server,
+// allowing the certificate to be rotated without restarting. Idle connections, established with the
+// previous certificate, are closed
+func (sc *Connection) SetCertificate(cert tls.Certificate) {
+	sc.clientCert.set(cert)
+	sc.httpClient.CloseIdleConnections()
+	sc.logprint(INFO, "client certificate replaced")
+}
+
+// ReloadCertificate reads the RP client certificate from the P12 file in the config file, or from the
+// secret provider, again and replaces the one in use with it. The certificate in use is kept if the new
+// one can not be read
+func (sc *Connection) ReloadCertificate() error {
+	cert, err := loadClientCertificate(sc.cfg, sc.secrets)
+	if err != nil {
+		sc.logprint(ERROR, "could not reload client certificate:", err.Error())
+		return fmt.Errorf("could not reload client certificate: %v", err)
+	}
+	sc.SetCertificate(cert)
+	return nil
+}
+
+// CertificateExpiry returns the time when the RP client certificate in use expires
+func (sc *Connection) CertificateExpiry() time.Time {
+	return sc.clientCert.notAfter()
+}
+
+// checkCertificateExpiry returns ErrCertificateExpiring if the RP certificate cc expires within
+// certExpiryMinDays, unless ignoreCertExpiry is set
+func (sc *Connection) checkCertificateExpiry(cc *clientCert) error {
+	if sc.cfg.CertExpiryMinDays == 0 {
+		return nil
+	}
+	na := cc.notAfter()
+	if na.IsZero() || time.Until(na) > time.Duration(sc.cfg.CertExpiryMinDays)*24*time.Hour {
+		return nil
+	}
+	if sc.cfg.IgnoreCertExpiry {
+		sc.logprint(WARN, "RP certificate expires", na.Format(time.RFC3339), "- ignored by configuration")
+		return nil
+	}
+	return ErrCertificateExpiring
+}
+
+// namedCert is an additional RP certificate of a connection, see WithCertificate. Each has an HTTP
+// client of its own, as connections established with one certificate must not be reused for another
+type namedCert struct {
+	cert   clientCert
+	client *http.Client
+}
+
+// AddCertificate adds an RP certificate, selected for a request with the WithCertificate option, or
+// replaces the one with the same name. The certificates of certStore.certificates in the config file
+// are added as the connection is created. The calls made with the certificate use an HTTP client
+// built from the config file, also when the connection was created with WithHTTPClient
+func (sc *Connection) AddCertificate(name string, cert tls.Certificate) error {
+	if name == "" {
+		return errors.New("no certificate name provided")
+	}
+	sc.certMu.Lock()
+	defer sc.certMu.Unlock()
+	if nc, ok := sc.certs[name]; ok {
+		nc.cert.set(cert)
+		nc.client.CloseIdleConnections()
+		sc.logprint(INFO, "client certificate", name, "replaced")
+		return nil
+	}
+	nc := &namedCert{}
+	nc.cert.set(cert)
+	cl, err := sc.newHTTPClient(&nc.cert, nil)
+	if err != nil {
+		return fmt.Errorf("could not create an HTTP client: %v", err)
+	}
+	nc.client = cl
+	if sc.certs == nil {
+		sc.certs = make(map[string]*namedCert)
+	}
+	sc.certs[name] = nc
+	return nil
+}
+
+// clientFor returns the HTTP client and the certificate of the RP certificate name, the default one
+// of the connection if empty
+func (sc *Connection) clientFor(name string) (*http.Client, *clientCert, error) {
+	if name == "" {
+		return sc.httpClient, &sc.clientCert, nil
+	}
+	sc.certMu.RLock()
+	defer sc.certMu.RUnlock()
+	nc, ok := sc.certs[name]
+	if !ok {
+		return nil, nil, errors.New("no client certificate named " + name)
+	}
+	return nc.client, &nc.cert, nil
+}
+
+// loadRPCertificate reads an additional RP certificate of the config file
+func loadRPCertificate(cfg *config.Config, rc config.RPCertificate) (tls.Certificate, error) {
+	if rc.UserCertThumbprint != "" {
+		key, cert, err := certstore.Find(rc.UserCertThumbprint)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("could not find the certificate %s: %v", rc.UserCertThumbprint, err)
+		}
+		return CertificateFromSigner(key, cert)
+	}
+	p12, err := cfg.ReadCertFile(rc.UserP12FileName)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return parseP12(p12, rc.UserPrivateKeyPassword)
+}
@@ -0,0 +1,41 @@
+package bankid
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hossner/bankid/config"
+)
+
+func TestServerRootCA(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"test environment", `"environment": "test"`, false},
+		{"custom service URL of the test environment", `"serviceUrl": "https://appapi2.test.bankid.com/rp/v6.0"`, false},
+		{"production environment", `"environment": "production"`, true},
+		{"custom service URL of the production environment", `"serviceUrl": "https://appapi2.bankid.com/rp/v6.0"`, true},
+		{"test service URL in production", `"environment": "production", "serviceUrl": "https://appapi2.test.bankid.com/rp/v6.0"`, true},
+		{"other host", `"serviceUrl": "https://bankid.example.com/rp/v6.0"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.FromReader(strings.NewReader(`{`+tt.config+`, "pollDelay": 2000, "enableLogging": false}`), "config.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca, err := serverRootCA(cfg)
+			switch {
+			case tt.wantErr && err == nil:
+				t.Error("a bundled CA certificate was returned")
+			case !tt.wantErr && err != nil:
+				t.Error(err)
+			case !tt.wantErr && !bytes.Equal(ca, testRootCA):
+				t.Error("the bundled test CA certificate was not returned")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Package certstore finds the RP client certificate, and its private key, in the certificate store of
+// the operating system by the thumbprint of the certificate: the personal certificate store of the
+// current user, or of the local machine, on Windows, and the keychains of the user on macOS. The
+// private key stays in the store and is used through a crypto.Signer. On other platforms, and on
+// macOS without cgo, Find returns ErrNotSupported.
+package certstore
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Errors returned by Find
+var (
+	ErrNotSupported = errors.New("no certificate store on this platform")
+	ErrNotFound     = errors.New("no certificate with the thumbprint and a private key in the certificate store")
+)
+
+// Find returns the private key and the certificate with thumbprint, the hex encoded SHA-1 hash of the
+// certificate as shown by the certificate manager of Windows and the keychain access of macOS. Spaces
+// and colons in the thumbprint are ignored. Only the certificate itself is returned, not its issuers
+func Find(thumbprint string) (crypto.Signer, *x509.Certificate, error) {
+	tp, err := ParseThumbprint(thumbprint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return find(tp)
+}
+
+// ParseThumbprint decodes a thumbprint, see Find
+func ParseThumbprint(thumbprint string) ([]byte, error) {
+	tp, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(thumbprint))
+	if err != nil || len(tp) != sha1.Size {
+		return nil, errors.New("thumbprint must be 40 hexadecimal digits")
+	}
+	return tp, nil
+}
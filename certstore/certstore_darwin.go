@@ -0,0 +1,220 @@
+//go:build darwin && cgo
+
+package certstore
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <CommonCrypto/CommonDigest.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+#include <string.h>
+
+// findIdentity returns the identity of the keychains with the certificate of the SHA-1 hash, retained,
+// or NULL if there is none
+static SecIdentityRef findIdentity(const UInt8 *thumbprint) {
+	const void *keys[] = {kSecClass, kSecMatchLimit, kSecReturnRef};
+	const void *values[] = {kSecClassIdentity, kSecMatchLimitAll, kCFBooleanTrue};
+	CFDictionaryRef query = CFDictionaryCreate(NULL, keys, values, 3, &kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFArrayRef identities = NULL;
+	OSStatus status = SecItemCopyMatching(query, (CFTypeRef *)&identities);
+	CFRelease(query);
+	if (status != errSecSuccess || identities == NULL) {
+		return NULL;
+	}
+	SecIdentityRef found = NULL;
+	for (CFIndex i = 0; i < CFArrayGetCount(identities) && found == NULL; i++) {
+		SecIdentityRef identity = (SecIdentityRef)CFArrayGetValueAtIndex(identities, i);
+		SecCertificateRef cert = NULL;
+		if (SecIdentityCopyCertificate(identity, &cert) != errSecSuccess) {
+			continue;
+		}
+		CFDataRef der = SecCertificateCopyData(cert);
+		CFRelease(cert);
+		unsigned char digest[CC_SHA1_DIGEST_LENGTH];
+		CC_SHA1(CFDataGetBytePtr(der), (CC_LONG)CFDataGetLength(der), digest);
+		CFRelease(der);
+		if (memcmp(digest, thumbprint, CC_SHA1_DIGEST_LENGTH) == 0) {
+			found = (SecIdentityRef)CFRetain(identity);
+		}
+	}
+	CFRelease(identities);
+	return found;
+}
+
+// copyCertificate returns the DER encoded certificate of identity, or NULL
+static CFDataRef copyCertificate(SecIdentityRef identity) {
+	SecCertificateRef cert = NULL;
+	if (SecIdentityCopyCertificate(identity, &cert) != errSecSuccess) {
+		return NULL;
+	}
+	CFDataRef der = SecCertificateCopyData(cert);
+	CFRelease(cert);
+	return der;
+}
+
+// copyKey returns the private key of identity, or NULL
+static SecKeyRef copyKey(SecIdentityRef identity) {
+	SecKeyRef key = NULL;
+	if (SecIdentityCopyPrivateKey(identity, &key) != errSecSuccess) {
+		return NULL;
+	}
+	return key;
+}
+
+// The signature algorithms of sign
+enum {
+	algRSAPKCS1v15SHA1, algRSAPKCS1v15SHA256, algRSAPKCS1v15SHA384, algRSAPKCS1v15SHA512,
+	algRSAPSSSHA256, algRSAPSSSHA384, algRSAPSSSHA512,
+	algECDSASHA1, algECDSASHA256, algECDSASHA384, algECDSASHA512,
+};
+
+// sign signs the digest with key, returning the signature or NULL with the error code in code
+static CFDataRef sign(SecKeyRef key, int alg, const UInt8 *digest, CFIndex size, CFIndex *code) {
+	SecKeyAlgorithm algorithm;
+	switch (alg) {
+	case algRSAPKCS1v15SHA1:   algorithm = kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA1; break;
+	case algRSAPKCS1v15SHA256: algorithm = kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA256; break;
+	case algRSAPKCS1v15SHA384: algorithm = kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA384; break;
+	case algRSAPKCS1v15SHA512: algorithm = kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA512; break;
+	case algRSAPSSSHA256:      algorithm = kSecKeyAlgorithmRSASignatureDigestPSSSHA256; break;
+	case algRSAPSSSHA384:      algorithm = kSecKeyAlgorithmRSASignatureDigestPSSSHA384; break;
+	case algRSAPSSSHA512:      algorithm = kSecKeyAlgorithmRSASignatureDigestPSSSHA512; break;
+	case algECDSASHA1:         algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA1; break;
+	case algECDSASHA256:       algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA256; break;
+	case algECDSASHA384:       algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA384; break;
+	default:                   algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA512; break;
+	}
+	CFDataRef data = CFDataCreate(NULL, digest, size);
+	CFErrorRef err = NULL;
+	CFDataRef sig = SecKeyCreateSignature(key, algorithm, data, &err);
+	CFRelease(data);
+	if (sig == NULL) {
+		*code = err != NULL ? CFErrorGetCode(err) : 0;
+		if (err != NULL) {
+			CFRelease(err);
+		}
+	}
+	return sig;
+}
+*/
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+func find(thumbprint []byte) (crypto.Signer, *x509.Certificate, error) {
+	identity := C.findIdentity((*C.UInt8)(unsafe.Pointer(&thumbprint[0])))
+	if identity == 0 {
+		return nil, nil, ErrNotFound
+	}
+	defer C.CFRelease(C.CFTypeRef(identity))
+	der := C.copyCertificate(identity)
+	if der == 0 {
+		return nil, nil, errors.New("could not read the certificate from the keychain")
+	}
+	cert, err := x509.ParseCertificate(cfBytes(der))
+	C.CFRelease(C.CFTypeRef(der))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the certificate: %v", err)
+	}
+	ref := C.copyKey(identity)
+	if ref == 0 {
+		return nil, nil, errors.New("could not get the private key of the certificate from the keychain")
+	}
+	key := &secKey{ref: ref, pub: cert.PublicKey}
+	runtime.SetFinalizer(key, (*secKey).free)
+	return key, cert, nil
+}
+
+// cfBytes returns a copy of the bytes of data
+func cfBytes(data C.CFDataRef) []byte {
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+}
+
+// secKey is a private key in a keychain
+type secKey struct {
+	ref C.SecKeyRef
+	pub crypto.PublicKey
+}
+
+func (k *secKey) free() {
+	C.CFRelease(C.CFTypeRef(k.ref))
+}
+
+// Public implements crypto.Signer
+func (k *secKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign implements crypto.Signer, signing digest with RSA PKCS #1 v1.5 or PSS, or with ECDSA
+func (k *secKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := k.algorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+	var code C.CFIndex
+	sig := C.sign(k.ref, alg, (*C.UInt8)(unsafe.Pointer(&digest[0])), C.CFIndex(len(digest)), &code)
+	runtime.KeepAlive(k)
+	if sig == 0 {
+		return nil, fmt.Errorf("SecKeyCreateSignature failed with code %d", int(code))
+	}
+	defer C.CFRelease(C.CFTypeRef(sig))
+	// The ECDSA signatures are ASN.1 encoded, as expected of a crypto.Signer
+	return cfBytes(sig), nil
+}
+
+// algorithm returns the signature algorithm of sign for the key and opts
+func (k *secKey) algorithm(opts crypto.SignerOpts) (C.int, error) {
+	h := opts.HashFunc()
+	switch k.pub.(type) {
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			// The keychain always uses a salt of the size of the hash
+			if pss.SaltLength != rsa.PSSSaltLengthEqualsHash && pss.SaltLength != h.Size() {
+				return 0, errors.New("unsupported PSS salt length")
+			}
+			switch h {
+			case crypto.SHA256:
+				return C.algRSAPSSSHA256, nil
+			case crypto.SHA384:
+				return C.algRSAPSSSHA384, nil
+			case crypto.SHA512:
+				return C.algRSAPSSSHA512, nil
+			}
+			break
+		}
+		switch h {
+		case crypto.SHA1:
+			return C.algRSAPKCS1v15SHA1, nil
+		case crypto.SHA256:
+			return C.algRSAPKCS1v15SHA256, nil
+		case crypto.SHA384:
+			return C.algRSAPKCS1v15SHA384, nil
+		case crypto.SHA512:
+			return C.algRSAPKCS1v15SHA512, nil
+		}
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA1:
+			return C.algECDSASHA1, nil
+		case crypto.SHA256:
+			return C.algECDSASHA256, nil
+		case crypto.SHA384:
+			return C.algECDSASHA384, nil
+		case crypto.SHA512:
+			return C.algECDSASHA512, nil
+		}
+	default:
+		return 0, fmt.Errorf("unsupported key type %T", k.pub)
+	}
+	return 0, fmt.Errorf("unsupported hash function %v", h)
+}
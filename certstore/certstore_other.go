@@ -0,0 +1,12 @@
+//go:build !windows && !(darwin && cgo)
+
+package certstore
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+func find(thumbprint []byte) (crypto.Signer, *x509.Certificate, error) {
+	return nil, nil, ErrNotSupported
+}
@@ -0,0 +1,188 @@
+//go:build windows
+
+package certstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Signing with CNG keys, not covered by golang.org/x/sys/windows
+var (
+	ncrypt               = windows.NewLazySystemDLL("ncrypt.dll")
+	procNCryptSignHash   = ncrypt.NewProc("NCryptSignHash")
+	procNCryptFreeObject = ncrypt.NewProc("NCryptFreeObject")
+)
+
+// Padding flags of NCryptSignHash
+const (
+	bcryptPadPKCS1 = 0x2
+	bcryptPadPSS   = 0x8
+)
+
+// bcryptPKCS1PaddingInfo is BCRYPT_PKCS1_PADDING_INFO
+type bcryptPKCS1PaddingInfo struct {
+	algID *uint16
+}
+
+// bcryptPSSPaddingInfo is BCRYPT_PSS_PADDING_INFO
+type bcryptPSSPaddingInfo struct {
+	algID *uint16
+	salt  uint32
+}
+
+func find(thumbprint []byte) (crypto.Signer, *x509.Certificate, error) {
+	myStore, err := windows.UTF16PtrFromString("MY")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, location := range []uint32{windows.CERT_SYSTEM_STORE_CURRENT_USER, windows.CERT_SYSTEM_STORE_LOCAL_MACHINE} {
+		store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0,
+			location|windows.CERT_STORE_OPEN_EXISTING_FLAG|windows.CERT_STORE_READONLY_FLAG, uintptr(unsafe.Pointer(myStore)))
+		if err != nil {
+			continue
+		}
+		key, cert, err := findInStore(store, thumbprint)
+		windows.CertCloseStore(store, 0)
+		if err != ErrNotFound {
+			return key, cert, err
+		}
+	}
+	return nil, nil, ErrNotFound
+}
+
+// findInStore returns the certificate with thumbprint in store, and its private key
+func findInStore(store windows.Handle, thumbprint []byte) (crypto.Signer, *x509.Certificate, error) {
+	hash := windows.CryptHashBlob{Size: uint32(len(thumbprint)), Data: &thumbprint[0]}
+	ctx, err := windows.CertFindCertificateInStore(store, windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, 0,
+		windows.CERT_FIND_HASH, unsafe.Pointer(&hash), nil)
+	if err != nil || ctx == nil {
+		return nil, nil, ErrNotFound
+	}
+	defer windows.CertFreeCertificateContext(ctx)
+	der := append([]byte(nil), unsafe.Slice(ctx.EncodedCert, ctx.Length)...)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the certificate: %v", err)
+	}
+	var (
+		handle   windows.Handle
+		keySpec  uint32
+		mustFree bool
+	)
+	// Only CNG keys are supported; keys of legacy CryptoAPI providers are not
+	err = windows.CryptAcquireCertificatePrivateKey(ctx, windows.CRYPT_ACQUIRE_ONLY_NCRYPT_KEY_FLAG|windows.CRYPT_ACQUIRE_SILENT_FLAG,
+		nil, &handle, &keySpec, &mustFree)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get the private key of the certificate: %v", err)
+	}
+	if keySpec != windows.CERT_NCRYPT_KEY_SPEC {
+		return nil, nil, errors.New("the private key of the certificate is not a CNG key")
+	}
+	key := &ncryptKey{handle: handle, pub: cert.PublicKey}
+	if mustFree {
+		runtime.SetFinalizer(key, (*ncryptKey).free)
+	}
+	return key, cert, nil
+}
+
+// ncryptKey is a private key in the certificate store, used through CNG
+type ncryptKey struct {
+	handle windows.Handle
+	pub    crypto.PublicKey
+}
+
+func (k *ncryptKey) free() {
+	procNCryptFreeObject.Call(uintptr(k.handle))
+}
+
+// Public implements crypto.Signer
+func (k *ncryptKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign implements crypto.Signer, signing digest with RSA PKCS #1 v1.5 or PSS, or with ECDSA
+func (k *ncryptKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var (
+		padding unsafe.Pointer
+		flags   uintptr
+	)
+	switch k.pub.(type) {
+	case *rsa.PublicKey:
+		algID, err := hashAlgorithm(opts.HashFunc())
+		if err != nil {
+			return nil, err
+		}
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			salt := pss.SaltLength
+			if salt <= 0 {
+				salt = opts.HashFunc().Size()
+			}
+			padding, flags = unsafe.Pointer(&bcryptPSSPaddingInfo{algID: algID, salt: uint32(salt)}), bcryptPadPSS
+		} else {
+			padding, flags = unsafe.Pointer(&bcryptPKCS1PaddingInfo{algID: algID}), bcryptPadPKCS1
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k.pub)
+	}
+	var size uint32
+	if err := k.signHash(padding, digest, nil, &size, flags); err != nil {
+		return nil, err
+	}
+	sig := make([]byte, size)
+	if err := k.signHash(padding, digest, sig, &size, flags); err != nil {
+		return nil, err
+	}
+	sig = sig[:size]
+	runtime.KeepAlive(k)
+	if _, ok := k.pub.(*ecdsa.PublicKey); ok {
+		// CNG returns r and s concatenated, crypto.Signer the ASN.1 encoding
+		r, s := new(big.Int).SetBytes(sig[:size/2]), new(big.Int).SetBytes(sig[size/2:])
+		return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	}
+	return sig, nil
+}
+
+// signHash calls NCryptSignHash, returning the size of the signature only if sig is nil
+func (k *ncryptKey) signHash(padding unsafe.Pointer, digest, sig []byte, size *uint32, flags uintptr) error {
+	var sigPtr uintptr
+	if len(sig) > 0 {
+		sigPtr = uintptr(unsafe.Pointer(&sig[0]))
+	}
+	r, _, _ := procNCryptSignHash.Call(uintptr(k.handle), uintptr(padding), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+		sigPtr, uintptr(len(sig)), uintptr(unsafe.Pointer(size)), flags)
+	if r != 0 {
+		return fmt.Errorf("NCryptSignHash failed with status 0x%x", r)
+	}
+	return nil
+}
+
+// hashAlgorithm returns the CNG name of h
+func hashAlgorithm(h crypto.Hash) (*uint16, error) {
+	var name string
+	switch h {
+	case crypto.SHA1:
+		name = "SHA1"
+	case crypto.SHA256:
+		name = "SHA256"
+	case crypto.SHA384:
+		name = "SHA384"
+	case crypto.SHA512:
+		name = "SHA512"
+	default:
+		return nil, fmt.Errorf("unsupported hash function %v", h)
+	}
+	return windows.UTF16PtrFromString(name)
+}
@@ -0,0 +1,50 @@
+package bankid
+
+import "time"
+
+// Clock is the source of time of a Connection, for the animated QR codes, the delays between collects
+// and the timestamps of events and sessions. It may be replaced with SetClock, e.g. by a clock that is
+// advanced by hand in tests instead of waiting for wall-clock seconds
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker delivers ticks at intervals on C, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer delivers a single tick on C, like time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the Clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// SetClock sets the Clock of the connection. Should be called before any request is sent. A nil clock
+// restores the default, the time package
+func (sc *Connection) SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	sc.clock = c
+}
@@ -0,0 +1,156 @@
+// Command bankid-cli starts an authentication or sign order from the command line, shows the
+// animated QR code in the terminal, or writes it to a PNG file, polls the order to completion and
+// prints the completion data as JSON. Useful for testing RP certificates and environments.
+//
+// Usage:
+//
+//	bankid-cli -config config.json [-sign "text to sign"] [-pnr 198112289874] [-qr ascii|png|none] [-qrfile qr.png]
+//	bankid-cli -config config.json -verify
+//	bankid-cli -genkey
+//	bankid-cli -encrypt < password.txt
+//
+// Status updates are written to stderr, the completion data to stdout. The exit code is 0 only if
+// the order completed. With -verify no order is started; the setup is checked, see bankid.VerifySetup,
+// and the exit code is 0 only if all checks passed.
+//
+// -genkey prints a new key for encrypted config file values, to be set in BANKID_CONFIG_KEY, and
+// -encrypt prints the value read from stdin encrypted with that key, to be used in the config file
+// in place of e.g. userPrivateKeyPassword, see config.EncryptValue.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/hossner/bankid"
+	"github.com/hossner/bankid/config"
+)
+
+func main() {
+	cfgFileName := flag.String("config", "", "config file, defaults to config.json next to the binary")
+	endUserIP := flag.String("ip", "127.0.0.1", "IP address of the end user")
+	signText := flag.String("sign", "", "text to sign; an authentication is made if left out")
+	pnr := flag.String("pnr", "", "personal number of the user, to start the order without a QR code")
+	qrMode := flag.String("qr", "ascii", "how to show the animated QR code: ascii, png or none")
+	qrFile := flag.String("qrfile", "qr.png", "file the QR code is written to, with -qr png")
+	timeout := flag.Duration("timeout", 5*time.Minute, "time to wait for the order to end")
+	verify := flag.Bool("verify", false, "check the config, certificates and service URL, without starting an order")
+	genKey := flag.Bool("genkey", false, "print a new key for encrypted config file values")
+	encrypt := flag.Bool("encrypt", false, "print the value read from stdin encrypted with the key in "+config.EnvConfigKey)
+	flag.Parse()
+
+	if *genKey {
+		key, err := config.GenerateKey()
+		if err != nil {
+			fail("could not generate key: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+	if *encrypt {
+		key, ok := os.LookupEnv(config.EnvConfigKey)
+		if !ok {
+			fail("%s is not set", config.EnvConfigKey)
+		}
+		value, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fail("could not read value: %v", err)
+		}
+		enc, err := config.EncryptValue(strings.TrimRight(string(value), "\r\n"), key)
+		if err != nil {
+			fail("could not encrypt value: %v", err)
+		}
+		fmt.Println(enc)
+		return
+	}
+
+	if *verify {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		report := bankid.VerifySetup(ctx, *cfgFileName, nil)
+		cancel()
+		fmt.Print(report)
+		if !report.OK {
+			os.Exit(1)
+		}
+		return
+	}
+
+	done := make(chan bankid.Event, 1)
+	conn, err := bankid.New(*cfgFileName, func(requestID, status, message string) {})
+	if err != nil {
+		fail("could not create connection: %v", err)
+	}
+	defer conn.Close()
+	conn.SetEventHandler(func(ev bankid.Event) {
+		switch ev.Status {
+		case bankid.StatusSent:
+			fmt.Fprintln(os.Stderr, "order started, autostart URL: bankid:///?autostarttoken="+ev.Message)
+		case bankid.StatusPending:
+			fmt.Fprintln(os.Stderr, "pending:", ev.HintCode)
+		case bankid.StatusComplete, bankid.StatusFailed, bankid.StatusCancelled, bankid.StatusError:
+			done <- ev
+		}
+	})
+
+	var opts []bankid.RequestOption
+	if *pnr != "" {
+		opts = append(opts, bankid.WithRequirement(&bankid.Requirements{PersonalNumber: *pnr}))
+	}
+	switch *qrMode {
+	case "ascii":
+		conn.SetQRRenderer(bankid.ASCIIRenderer{})
+		opts = append(opts, bankid.WithQRCallback(func(qr []byte, requestID string) {
+			fmt.Fprint(os.Stderr, "\033[H\033[2J", string(qr), "\nScan the QR code with the BankID app\n")
+		}))
+	case "png":
+		opts = append(opts, bankid.WithQRCallback(func(qr []byte, requestID string) {
+			if err := os.WriteFile(*qrFile, qr, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, "could not write QR code:", err)
+			}
+		}))
+	case "none":
+	default:
+		fail("unknown -qr mode %q", *qrMode)
+	}
+
+	var requestID string
+	if *signText != "" {
+		requestID = conn.Sign(*endUserIP, base64.StdEncoding.EncodeToString([]byte(*signText)), opts...)
+	} else {
+		requestID = conn.Authenticate(*endUserIP, opts...)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	var ev bankid.Event
+	select {
+	case ev = <-done:
+	case <-interrupt:
+		conn.CancelRequest(requestID)
+		ev = <-done
+	case <-time.After(*timeout):
+		conn.CancelRequest(requestID)
+		ev = <-done
+	}
+	if ev.Status != bankid.StatusComplete {
+		fail("order ended with status %s: %s%s", ev.Status, ev.HintCode, ev.Message)
+	}
+	out, err := json.MarshalIndent(ev.Completion, "", "  ")
+	if err != nil {
+		fail("could not encode completion data: %v", err)
+	}
+	fmt.Println(string(out))
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "bankid-cli: "+format+"\n", a...)
+	os.Exit(1)
+}
@@ -0,0 +1,56 @@
+// Command bankid-gateway runs the REST API of the gateway package as a stand-alone BankID proxy
+// service.
+//
+// Usage:
+//
+//	BANKID_GATEWAY_API_KEYS=key1,key2 bankid-gateway -config config.json [-addr :8080]
+//
+// The API keys are read from the environment, to keep them out of the process list.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hossner/bankid/gateway"
+)
+
+// envAPIKeys holds the comma separated API keys accepted by the gateway
+const envAPIKeys = "BANKID_GATEWAY_API_KEYS"
+
+func main() {
+	cfgFileName := flag.String("config", "", "config file, defaults to config.json next to the binary")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	tlsCert := flag.String("tlscert", "", "certificate file, to serve HTTPS")
+	tlsKey := flag.String("tlskey", "", "private key file of -tlscert")
+	flag.Parse()
+
+	var keys []string
+	for _, k := range strings.Split(os.Getenv(envAPIKeys), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		fail("no API keys set in %s", envAPIKeys)
+	}
+	g, err := gateway.New(*cfgFileName, keys...)
+	if err != nil {
+		fail("could not create gateway: %v", err)
+	}
+	defer g.Close()
+	if *tlsCert != "" {
+		err = http.ListenAndServeTLS(*addr, *tlsCert, *tlsKey, g)
+	} else {
+		err = http.ListenAndServe(*addr, g)
+	}
+	fail("%v", err)
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "bankid-gateway: "+format+"\n", a...)
+	os.Exit(1)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fakeServer answers auth, sign, collect and cancel calls as the BankID server would, completing every
+// order after a number of pending collects
+type fakeServer struct {
+	pending  int // Collects answered with status pending before an order completes
+	nextRef  int64
+	collects int64
+
+	mu     sync.Mutex
+	orders map[string]int       // Collects made, by order reference
+	ended  map[string]time.Time // When the final collect response of an order was answered
+}
+
+func newFakeServer(pending int) *fakeServer {
+	return &fakeServer{pending: pending, orders: make(map[string]int), ended: make(map[string]time.Time)}
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderRef string `json:"orderRef"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/auth", "/sign":
+		ref := "order-" + strconv.FormatInt(atomic.AddInt64(&f.nextRef, 1), 10)
+		f.mu.Lock()
+		f.orders[ref] = 0
+		f.mu.Unlock()
+		writeJSON(w, map[string]string{"orderRef": ref, "autoStartToken": ref, "qrStartToken": ref, "qrStartSecret": ref})
+	case "/collect":
+		atomic.AddInt64(&f.collects, 1)
+		f.mu.Lock()
+		n, ok := f.orders[req.OrderRef]
+		f.orders[req.OrderRef] = n + 1
+		f.mu.Unlock()
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"errorCode": "invalidParameters", "details": "No such order"})
+		case n < f.pending/2:
+			writeJSON(w, map[string]string{"orderRef": req.OrderRef, "status": "pending", "hintCode": "outstandingTransaction"})
+		case n < f.pending:
+			writeJSON(w, map[string]string{"orderRef": req.OrderRef, "status": "pending", "hintCode": "userSign"})
+		default:
+			f.mu.Lock()
+			delete(f.orders, req.OrderRef)
+			f.ended[req.OrderRef] = time.Now()
+			f.mu.Unlock()
+			writeJSON(w, map[string]interface{}{"orderRef": req.OrderRef, "status": "complete", "completionData": map[string]interface{}{
+				"user":   map[string]string{"personalNumber": "198112289874", "name": "Karl Karlsson", "givenName": "Karl", "surname": "Karlsson"},
+				"device": map[string]string{"ipAddress": "127.0.0.1"},
+				"cert":   map[string]string{"notBefore": "1502983274000", "notAfter": "1563549674000"},
+			}})
+		}
+	case "/cancel":
+		f.mu.Lock()
+		delete(f.orders, req.OrderRef)
+		f.mu.Unlock()
+		writeJSON(w, struct{}{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// endedAt returns when the final collect of the order was answered
+func (f *fakeServer) endedAt(orderRef string) (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.ended[orderRef]
+	return t, ok
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,173 @@
+// Command bankid-loadtest drives thousands of concurrent orders through a Connection against an
+// in-process fake BankID server, and reports the go routines used, the allocations per collect and
+// the latency of the call backs. Used to validate changes to the concurrency of the package.
+//
+// Usage:
+//
+//	bankid-loadtest [-sessions 1000] [-pending 3] [-polldelay 2000] [-qr] [-p12 example/certstore/client.pfx]
+//
+// The fake server does not check the RP certificate, but one is needed to create the connection; the
+// test certificate of the example is used by default. Allocations are counted for the whole process,
+// the fake server included.
+package main
+
+import (
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hossner/bankid"
+	"github.com/hossner/bankid/config"
+)
+
+func main() {
+	sessions := flag.Int("sessions", 1000, "concurrent orders to start")
+	pending := flag.Int("pending", 3, "pending collects before an order completes")
+	pollDelay := flag.Int("polldelay", 2000, "milliseconds between collects")
+	qr := flag.Bool("qr", false, "generate animated QR codes for every order")
+	p12 := flag.String("p12", "example/certstore/client.pfx", "P12 file of the RP certificate")
+	password := flag.String("password", "qwerty123", "password of the P12 file")
+	timeout := flag.Duration("timeout", 10*time.Minute, "time to wait for all orders to end")
+	flag.Parse()
+
+	fake := newFakeServer(*pending)
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+	dir, err := os.MkdirTemp("", "bankid-loadtest")
+	if err != nil {
+		fail("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		fail("could not write CA certificate: %v", err)
+	}
+	p12Path, err := filepath.Abs(*p12)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	cfg := &config.Config{AppDir: dir, ServiceURL: srv.URL, PollDelay: *pollDelay}
+	cfg.CertStore.UserP12FileName = p12Path
+	cfg.CertStore.UserPrivateKeyPassword = *password
+	cfg.CertStore.CACertFileName = caFile
+	if err := cfg.Prepare(); err != nil {
+		fail("%v", err)
+	}
+	conn, err := bankid.NewFromConfig(cfg, func(requestID, status, message string) {})
+	if err != nil {
+		fail("could not create connection: %v", err)
+	}
+	defer conn.Close()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		statuses  = make(map[bankid.Status]int)
+		latencies []time.Duration
+	)
+	conn.SetEventHandler(func(ev bankid.Event) {
+		switch ev.Status {
+		case bankid.StatusComplete, bankid.StatusFailed, bankid.StatusCancelled, bankid.StatusError:
+		default:
+			return
+		}
+		var lat time.Duration
+		var measured bool
+		if ev.Completion != nil {
+			if t, ok := fake.endedAt(ev.Completion.OrderRef); ok {
+				lat, measured = time.Since(t), true
+			}
+		}
+		mu.Lock()
+		statuses[ev.Status]++
+		if measured {
+			latencies = append(latencies, lat)
+		}
+		mu.Unlock()
+		wg.Done()
+	})
+
+	baseline := runtime.NumGoroutine()
+	var peak, pkgPeak int64
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&peak) {
+					atomic.StoreInt64(&peak, n)
+				}
+				if n := conn.Stats().Goroutines; n > atomic.LoadInt64(&pkgPeak) {
+					atomic.StoreInt64(&pkgPeak, n)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var opts []bankid.RequestOption
+	if *qr {
+		opts = append(opts, bankid.WithQRCallback(func(qrCode []byte, requestID string) {}))
+	}
+	wg.Add(*sessions)
+	for i := 0; i < *sessions; i++ {
+		conn.Authenticate("127.0.0.1", opts...)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(*timeout):
+		fail("timed out with %d orders ongoing", len(conn.ActiveSessions()))
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	close(stop)
+
+	// Let the go routines of the requests return before checking for leaks
+	time.Sleep(500 * time.Millisecond)
+	st := conn.Stats()
+	collects := atomic.LoadInt64(&fake.collects)
+
+	fmt.Printf("orders:               %d in %v\n", *sessions, elapsed.Round(time.Millisecond))
+	for s, n := range statuses {
+		fmt.Printf("  %-19s %d\n", string(s)+":", n)
+	}
+	fmt.Printf("collects:             %d (%.0f/s)\n", collects, float64(collects)/elapsed.Seconds())
+	fmt.Printf("go routines:          %d before, %d peak, %d after\n", baseline, peak, runtime.NumGoroutine())
+	fmt.Printf("package go routines:  %d peak, %d left, %d tickers and %d channels left\n", pkgPeak, st.Goroutines, st.ActiveTickers, st.OpenChannels)
+	if collects > 0 {
+		fmt.Printf("allocations/collect:  %d (%d bytes)\n", (after.Mallocs-before.Mallocs)/uint64(collects), (after.TotalAlloc-before.TotalAlloc)/uint64(collects))
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		fmt.Printf("call back latency:    p50 %v, p99 %v, max %v\n", latencies[n/2], latencies[n*99/100], latencies[n-1])
+	}
+	if st.Goroutines != 0 || st.ActiveTickers != 0 || st.OpenChannels != 0 {
+		fail("resources left after all orders ended")
+	}
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "bankid-loadtest: "+format+"\n", a...)
+	os.Exit(1)
+}
@@ -0,0 +1,40 @@
+package bankid
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Codec encodes the requests to the BankID server, and decodes its responses, in JSON. The default
+// uses encoding/json; a faster one, e.g. json-iterator, may be set with SetCodec
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Decoder decodes a JSON value from the reader of a Codec, as it is read
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// stdCodec is the Codec of encoding/json
+type stdCodec struct{}
+
+func (stdCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (stdCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (stdCodec) NewDecoder(r io.Reader) Decoder             { return json.NewDecoder(r) }
+
+// bufPool holds the buffers the collect responses are read into, reused between polls
+var bufPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// SetCodec sets the Codec of the calls to the BankID server. Should be called before any request is
+// sent. A nil codec restores the default, encoding/json
+func (sc *Connection) SetCodec(c Codec) {
+	if c == nil {
+		c = stdCodec{}
+	}
+	sc.codec = c
+}
@@ -0,0 +1,76 @@
+package bankid
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// collectComplete is a collect response of a completed order, the largest one decoded when polling
+var collectComplete = []byte(`{"orderRef":"131daac9-16c6-4618-beb0-365768f37288","status":"complete","completionData":{` +
+	`"user":{"personalNumber":"198112289874","name":"Karl Karlsson","givenName":"Karl","surname":"Karlsson"},` +
+	`"device":{"ipAddress":"192.0.2.57","uhi":"OZrNnZJ4o5Lw3VwWS9X9sVYF2lB2"},` +
+	`"bankIdIssueDate":"2020-02-01","stepUp":{"mrtd":false},` +
+	`"signature":"PHNpZ25hdHVyZT5zaWduZWQ8L3NpZ25hdHVyZT4=","ocspResponse":"TUlJSG9BWUpLb1pJaHZjTkFRY0NvSUlIa1RDQ0I=","risk":"low"}}`)
+
+// collectPending is a collect response of a pending order, the one decoded most often
+var collectPending = []byte(`{"orderRef":"131daac9-16c6-4618-beb0-365768f37288","status":"pending","hintCode":"outstandingTransaction"}`)
+
+// BenchmarkCollectDecode decodes collect responses as transmit does, as they are read, into a pooled
+// buffer
+func BenchmarkCollectDecode(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		body []byte
+	}{{"pending", collectPending}, {"complete", collectComplete}} {
+		b.Run(bm.name, func(b *testing.B) {
+			var c Codec = stdCodec{}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bm.body)))
+			for i := 0; i < b.N; i++ {
+				buf := bufPool.Get().(*bytes.Buffer)
+				buf.Reset()
+				var sr serverResponse
+				if err := c.NewDecoder(io.TeeReader(bytes.NewReader(bm.body), buf)).Decode(&sr); err != nil {
+					b.Fatal(err)
+				}
+				bufPool.Put(buf)
+			}
+		})
+	}
+}
+
+// BenchmarkCollectUnmarshal decodes collect responses read as a whole first, as done before the
+// responses were decoded as read, for comparison with BenchmarkCollectDecode
+func BenchmarkCollectUnmarshal(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		body []byte
+	}{{"pending", collectPending}, {"complete", collectComplete}} {
+		b.Run(bm.name, func(b *testing.B) {
+			var c Codec = stdCodec{}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bm.body)))
+			for i := 0; i < b.N; i++ {
+				body, err := io.ReadAll(bytes.NewReader(bm.body))
+				if err != nil {
+					b.Fatal(err)
+				}
+				var sr serverResponse
+				if err := c.Unmarshal(body, &sr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRequestToJSON(b *testing.B) {
+	r := newRequest("192.0.2.57", true, "Transfer 100 SEK to account 1234", []RequestOption{WithRequirement(&Requirements{PersonalNumber: "198112289874"})})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := requestToJSON(r, stdCodec{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
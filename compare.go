@@ -0,0 +1,43 @@
+package bankid
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CompletionDiff is the result of comparing two completions with CompareCompletions
+type CompletionDiff struct {
+	SamePerson bool // Same personal number
+	SameBankID bool // Same user certificate, i.e. the same BankID on the same device
+	SameBank   bool // User certificates issued by the same bank
+	SameIP     bool // Same IP address of the device
+}
+
+// Identical reports whether the completions were made by the same person, with the same BankID
+func (d CompletionDiff) Identical() bool {
+	return d.SamePerson && d.SameBankID && d.SameBank
+}
+
+// CompareCompletions compares two completions, e.g. of the login of a session and of a later step-up
+// or re-authentication, to detect the user of the session switching to another person, device or
+// bank. An error is returned if either completion is nil or lacks a user certificate in its signature
+func CompareCompletions(a, b *CompletionData) (CompletionDiff, error) {
+	if a == nil || b == nil {
+		return CompletionDiff{}, errors.New("no completion data provided")
+	}
+	d := CompletionDiff{
+		SamePerson: a.User.PersonalNumber != "" && a.User.PersonalNumber == b.User.PersonalNumber,
+		SameIP:     a.Device.IPAddress != "" && a.Device.IPAddress == b.Device.IPAddress,
+	}
+	ca, err := userCertificate(a.Signature)
+	if err != nil {
+		return d, fmt.Errorf("could not read the user certificate of the first completion: %v", err)
+	}
+	cb, err := userCertificate(b.Signature)
+	if err != nil {
+		return d, fmt.Errorf("could not read the user certificate of the second completion: %v", err)
+	}
+	d.SameBank = ca.Issuer.String() == cb.Issuer.String()
+	d.SameBankID = d.SameBank && ca.SerialNumber.Cmp(cb.SerialNumber) == 0
+	return d, nil
+}
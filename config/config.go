@@ -0,0 +1,401 @@
+// Package config reads and validates the configuration of a bankid.Connection, from a config file,
+// from BANKID_* environment variables, or built in code and passed to bankid.NewFromConfig.
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	defaultConfigFileName  = "config.json"
+	minPollDelay           = 2000
+	maxPollDelay           = 10000
+	defaultAPIVersion      = "5.1"
+	defaultContentType     = "application/json"
+	defaultIdleConnTimeout = 90000
+	defaultStaleQRAfter    = 60000
+	defaultLogPrefix       = LogLevelPlaceholder
+	defaultWebhookRetries  = 5
+	defaultSimStartDelay   = 5000
+	defaultSimSignDelay    = 3000
+	simulationHost         = "simulation.invalid" // Never resolved, the calls are answered by the simulator
+	// LogLevelPlaceholder is replaced by the name of the log level in the logPrefix template
+	LogLevelPlaceholder = "{level}"
+)
+
+// Environment selects one of the BankID environments, with built-in endpoints
+type Environment string
+
+// The available environments. With EnvironmentCustom the serviceUrl has to be set explicitly. With
+// EnvironmentSimulation no calls are made; the orders are simulated locally, see the simulation section
+const (
+	EnvironmentCustom     Environment = ""
+	EnvironmentProduction Environment = "production"
+	EnvironmentTest       Environment = "test"
+	EnvironmentSimulation Environment = "simulation"
+)
+
+// hosts holds the host of the BankID service in each environment
+var hosts = map[Environment]string{
+	EnvironmentProduction: "appapi2.bankid.com",
+	EnvironmentTest:       "appapi2.test.bankid.com",
+}
+
+// BankIDEnvironment returns the BankID environment of the service URL: the one selected, or for a
+// custom service URL the one whose host it points at, if any
+func (c *Config) BankIDEnvironment() Environment {
+	if c.Environment != EnvironmentCustom {
+		return c.Environment
+	}
+	u, err := url.Parse(c.ServiceURL)
+	if err != nil {
+		return EnvironmentCustom
+	}
+	for env, host := range hosts {
+		if u.Hostname() == host {
+			return env
+		}
+	}
+	return EnvironmentCustom
+}
+
+// RPCertificate is an additional RP certificate of the certStore section, in a P12 file of
+// certStorePath or in the certificate store of the operating system
+type RPCertificate struct {
+	UserP12FileName        string `json:"userP12FileName"`
+	UserPrivateKeyPassword string `json:"userPrivateKeyPassword"`
+	UserCertThumbprint     string `json:"userCertThumbprint"`
+}
+
+// Config holds all config parameters from the config file
+type Config struct {
+	AppDir    string
+	CertStore struct {
+		CertStorePath          string `json:"certStorePath"`
+		UserPrivateKeyPassword string `json:"userPrivateKeyPassword"`
+		CACertFileName         string `json:"caCertFileName"`
+		UserCertFileName       string `json:"userCertFileName"`
+		UserPrivateKeyFileName string `json:"userPrivateKeyFileName"`
+		UserP12FileName        string `json:"userP12FileName"`
+		UserCertThumbprint     string `json:"userCertThumbprint"` // Certificate in the store of Windows or macOS, instead of userP12FileName
+		// Additional RP certificates by name, selected per request
+		Certificates map[string]RPCertificate `json:"certificates"`
+	} `json:"certStore"`
+	HTTPClientConfig struct {
+		// Overrides for exotic setups only; by default the Host header is the host of serviceUrl and
+		// Content-Type is application/json
+		RequestHeader struct {
+			Host        string `json:"Host"`
+			ContentType string `json:"Content-type"`
+		} `json:"requestHeader"`
+		UserAgent string            `json:"userAgent"` // Identifies the RP application, sent before the User-Agent of the package
+		Headers   map[string]string `json:"headers"`   // Extra headers sent with every call to the BankID server
+		TLS       struct {
+			MinVersion   string   `json:"minVersion"`   // "1.2" (default) or "1.3"; TLS 1.3 is preferred either way
+			CipherSuites []string `json:"cipherSuites"` // TLS 1.2 cipher suites by crypto/tls name, defaults to ECDHE with AEAD ciphers
+		} `json:"tls"`
+	} `json:"httpClientConfig"`
+	Webhook struct {
+		URL        string `json:"url"`        // Receives a POST for every ended request, empty disables
+		Secret     string `json:"secret"`     // Key of the HMAC signature of the payload
+		MaxRetries int    `json:"maxRetries"` // Deliveries retried after a failure, defaults to 5
+	} `json:"webhook"`
+	Simulation struct {
+		Outcome    string `json:"outcome"`    // "complete" (default), or the hint code of a failed order, e.g. "userCancel"
+		StartDelay int    `json:"startDelay"` // Milliseconds before the simulated user starts the BankID app, defaults to 5000
+		SignDelay  int    `json:"signDelay"`  // Milliseconds the simulated user takes to sign once started, defaults to 3000
+		User       struct {
+			PersonalNumber string `json:"personalNumber"`
+			Name           string `json:"name"`
+			GivenName      string `json:"givenName"`
+			Surname        string `json:"surname"`
+		} `json:"user"` // Of completed orders, defaults to a test user; the personal number of the order takes precedence
+		Scenarios    map[string]Scenario `json:"scenarios"`    // By personal number, replacing outcome and delays for the orders of that person
+		ScenarioFile string              `json:"scenarioFile"` // JSON, YAML or TOML file with more scenarios, see SimulationScenarios
+	} `json:"simulation"` // Used with environment "simulation"
+	Recording struct {
+		Mode string `json:"mode"` // "record" saves the calls to the BankID server to file, "replay" answers them from it; empty does neither
+		File string `json:"file"` // Golden file of the calls, relative to the directory of the binary
+	} `json:"recording"`
+	Proxy struct {
+		URL      string `json:"url"` // http, https or socks5 URL of the proxy; empty uses HTTPS_PROXY and NO_PROXY
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"proxy"`
+	Environment          Environment    `json:"environment"` // "production", "test" or empty for a custom serviceUrl
+	APIVersion           string         `json:"apiVersion"`  // Used with environment, defaults to "5.1"
+	ServiceURL           string         `json:"serviceUrl"`
+	PollDelay            int            `json:"pollDelay"`
+	PollDelays           map[string]int `json:"pollDelays"`           // Poll delay per hint code, overriding pollDelay
+	PersonalNumberPolicy string         `json:"personalNumberPolicy"` // "allow" (default), "require" or "forbid"
+	DuplicateOrderPolicy string         `json:"duplicateOrderPolicy"` // "allow" (default), "cancel", "reject" or "queue"
+	IdleConnTimeout      int            `json:"idleConnTimeout"`      // Milliseconds before an idle connection is closed
+	ConnReapInterval     int            `json:"connReapInterval"`     // Milliseconds between closing all idle connections, 0 disables
+	SessionReapInterval  int            `json:"sessionReapInterval"`  // Milliseconds between cleaning up sessions outliving their orders, 0 disables
+	ReconcileInterval    int            `json:"reconcileInterval"`    // Milliseconds between reconciliations of the audit log, 0 disables
+	CertExpiryMinDays    int            `json:"certExpiryMinDays"`    // Refuse new orders this close to expiry of the RP certificate, 0 disables
+	IgnoreCertExpiry     bool           `json:"ignoreCertExpiry"`     // Override of certExpiryMinDays
+	VerifyOCSP           bool           `json:"verifyOcsp"`           // Verify the OCSP response of completed requests
+	MaxRisk              string         `json:"maxRisk"`              // Reject completions with a risk indicator above "low" or "moderate", empty disables
+	StaleQRAfter         int            `json:"staleQrAfter"`         // Milliseconds of QR codes after which an order is reported as stale
+	StaleQREvent         bool           `json:"staleQrEvent"`         // Send an Event with status "qrStale" to FOnEvent for stale orders
+	RateLimit            int            `json:"rateLimit"`            // Max calls per second to the BankID server, 0 disables
+	RateLimitBurst       int            `json:"rateLimitBurst"`       // Calls allowed at once above rateLimit, defaults to rateLimit
+	MaxSessions          int            `json:"maxSessions"`          // Max requests ongoing at once, 0 disables
+	EventQueueSize       int            `json:"eventQueueSize"`       // Events queued per request for the call back functions, 0 calls them from the poll loop
+	EventQueueOverflow   string         `json:"eventQueueOverflow"`   // "block" (default), "dropOldest" or "dropNewest", when the event queue is full
+	Language             string         `json:"language"`             // Language of the user messages of events, e.g. "sv", defaults to "en"
+	LogFileName          string         `json:"logFile"`
+	LogLevel             int            `json:"logLevel"`
+	LogMaxSize           int            `json:"logMaxSize"`      // Megabytes before the log file is rotated, 0 disables
+	LogMaxAge            int            `json:"logMaxAge"`       // Days before the log file is rotated, 0 disables
+	LogMaxBackups        int            `json:"logMaxBackups"`   // Rotated log files kept, 0 keeps all
+	LogFormat            string         `json:"logFormat"`       // "text" (default) or "json", for JSON lines
+	LogPrefix            string         `json:"logPrefix"`       // Template for the prefix of log lines, e.g. "bankid {level}"
+	WireDebug            bool           `json:"wireDebug"`       // Log all requests and responses, with personal data and secrets masked
+	LogPersonalData      bool           `json:"logPersonalData"` // Log personal numbers, names and IP addresses in clear text, for development only
+	StrictSecrets        bool           `json:"strictSecrets"`   // Refuse a userPrivateKeyPassword in plain text in the config file
+
+	passwordInFile bool   // userPrivateKeyPassword was set in the config file, not only in the environment
+	fsys           fs.FS  // File system of FromFS, holding the certificate files
+	fsDir          string // Directory of the config file in fsys
+}
+
+// New returns a pointer to a new instance of a Config struct, holding values from the config file cfgFileName,
+// which may be in JSON, YAML (.yaml, .yml) or TOML (.toml) format.
+// Values set in BANKID_* environment variables, see FromEnv, override those of the file
+func New(cfgFileName string) (*Config, error) {
+	myDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve working directory: %v", err)
+	}
+	if cfgFileName == "" {
+		cfgFileName = path.Join(myDir, defaultConfigFileName)
+	}
+	raw, err := os.ReadFile(cfgFileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %s: %v", cfgFileName, err)
+	}
+	return parse(raw, cfgFileName, myDir, nil)
+}
+
+// FromReader returns a pointer to a new instance of a Config struct, holding values read from r, in the
+// format given by the extension of name as for New, e.g. "config.yaml". Relative paths of the certStore
+// section are resolved against the directory of the binary. Values set in BANKID_* environment variables
+// override those read
+func FromReader(r io.Reader, name string) (*Config, error) {
+	myDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve working directory: %v", err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", name, err)
+	}
+	return parse(raw, name, myDir, nil)
+}
+
+// FromFS returns a pointer to a new instance of a Config struct, holding values from the config file
+// name in fsys, e.g. an embed.FS built into the binary. The certificate files of the certStore section
+// are read from fsys too, relative to the directory of the config file. Values set in BANKID_*
+// environment variables override those of the file
+func FromFS(fsys fs.FS, name string) (*Config, error) {
+	myDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve working directory: %v", err)
+	}
+	raw, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %s: %v", name, err)
+	}
+	return parse(raw, name, myDir, fsys)
+}
+
+// parse returns the Config of the config file raw, named name, with the environment variables applied
+func parse(raw []byte, name, appDir string, fsys fs.FS) (*Config, error) {
+	var s Config
+	if err := unmarshal(raw, name, &s); err != nil {
+		return nil, fmt.Errorf("could not unmarshal config file %s: %v", name, err)
+	}
+	s.AppDir = appDir
+	if fsys != nil {
+		s.fsys, s.fsDir = fsys, path.Dir(name)
+	}
+	// An encrypted password is not in plain text, so it is allowed with strictSecrets
+	s.passwordInFile = s.CertStore.UserPrivateKeyPassword != "" && !IsEncrypted(s.CertStore.UserPrivateKeyPassword)
+	for _, rc := range s.CertStore.Certificates {
+		if rc.UserPrivateKeyPassword != "" && !IsEncrypted(rc.UserPrivateKeyPassword) {
+			s.passwordInFile = true
+		}
+	}
+	if err := s.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := s.decryptSecrets(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", name, err)
+	}
+	s.applyEnvironment()
+	s.applyDefaults()
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", name, err)
+	}
+	return &s, nil
+}
+
+// CertPaths resolves the files of the certStore section to absolute paths
+type CertPaths struct {
+	c *Config
+}
+
+// CertPaths returns the paths of the certificate files
+func (c *Config) CertPaths() CertPaths {
+	return CertPaths{c: c}
+}
+
+// CACert returns the absolute path to the CA certificate file
+func (p CertPaths) CACert() string {
+	return fixPath(p.c.AppDir, p.c.CertStore.CertStorePath, p.c.CertStore.CACertFileName)
+}
+
+// UserCert returns the absolute path to the user certificate file
+func (p CertPaths) UserCert() string {
+	return fixPath(p.c.AppDir, p.c.CertStore.CertStorePath, p.c.CertStore.UserCertFileName)
+}
+
+// UserPrivateKey returns the absolute path to the user private key file
+func (p CertPaths) UserPrivateKey() string {
+	return fixPath(p.c.AppDir, p.c.CertStore.CertStorePath, p.c.CertStore.UserPrivateKeyFileName)
+}
+
+// UserP12 returns the absolute path to the P12 file holding the user certificate and private key
+func (p CertPaths) UserP12() string {
+	return fixPath(p.c.AppDir, p.c.CertStore.CertStorePath, p.c.CertStore.UserP12FileName)
+}
+
+// ReadCertFile reads fileName of the certStore section, e.g. c.CertStore.UserP12FileName, from the
+// file system of FromFS if the config was read with it, or else from the path resolved as by CertPaths
+func (c *Config) ReadCertFile(fileName string) ([]byte, error) {
+	if c.fsys != nil {
+		return fs.ReadFile(c.fsys, path.Join(c.fsDir, c.CertStore.CertStorePath, fileName))
+	}
+	return os.ReadFile(fixPath(c.AppDir, c.CertStore.CertStorePath, fileName))
+}
+
+// LogFilePath returns the absolute path to the log file
+func (c *Config) LogFilePath() string {
+	return fixPath(c.AppDir, "", c.LogFileName)
+}
+
+// RecordingFilePath returns the absolute path to the golden file of the recording section
+func (c *Config) RecordingFilePath() string {
+	return fixPath(c.AppDir, "", c.Recording.File)
+}
+
+// Prepare readies a Config built in code, rather than read with New or FromEnv: the service URL and
+// request headers of the environment, and default values, are filled in and all settings validated.
+// Relative paths are resolved against AppDir, which defaults to the directory of the binary
+func (c *Config) Prepare() error {
+	if c.AppDir == "" {
+		myDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+		if err != nil {
+			return fmt.Errorf("failed to retrieve working directory: %v", err)
+		}
+		c.AppDir = myDir
+	}
+	if err := c.decryptSecrets(); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	c.applyEnvironment()
+	c.applyDefaults()
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
+// applyEnvironment fills in the service URL of the selected environment, unless explicitly set in the
+// config file
+func (c *Config) applyEnvironment() {
+	if c.APIVersion == "" {
+		c.APIVersion = defaultAPIVersion
+	}
+	if c.Environment == EnvironmentSimulation {
+		if c.ServiceURL == "" {
+			c.ServiceURL = "https://" + simulationHost + "/rp/v" + c.APIVersion
+		}
+		return
+	}
+	host, ok := hosts[c.Environment]
+	if !ok {
+		return
+	}
+	if c.ServiceURL == "" {
+		c.ServiceURL = "https://" + host + "/rp/v" + c.APIVersion
+	}
+}
+
+// applyDefaults sets the default value of settings left out of the config file
+func (c *Config) applyDefaults() {
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if c.HTTPClientConfig.RequestHeader.ContentType == "" {
+		c.HTTPClientConfig.RequestHeader.ContentType = defaultContentType
+	}
+	if c.HTTPClientConfig.TLS.MinVersion == "" {
+		c.HTTPClientConfig.TLS.MinVersion = "1.2"
+	}
+	if c.StaleQRAfter == 0 {
+		c.StaleQRAfter = defaultStaleQRAfter
+	}
+	if c.Webhook.MaxRetries == 0 {
+		c.Webhook.MaxRetries = defaultWebhookRetries
+	}
+	if c.LogPrefix == "" {
+		c.LogPrefix = defaultLogPrefix
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = "text"
+	}
+	if c.PersonalNumberPolicy == "" {
+		c.PersonalNumberPolicy = "allow"
+	}
+	if c.DuplicateOrderPolicy == "" {
+		c.DuplicateOrderPolicy = "allow"
+	}
+	if c.EventQueueOverflow == "" {
+		c.EventQueueOverflow = "block"
+	}
+	if c.Language == "" {
+		c.Language = "en"
+	}
+	if c.Simulation.Outcome == "" {
+		c.Simulation.Outcome = "complete"
+	}
+	if c.Simulation.StartDelay == 0 {
+		c.Simulation.StartDelay = defaultSimStartDelay
+	}
+	if c.Simulation.SignDelay == 0 {
+		c.Simulation.SignDelay = defaultSimSignDelay
+	}
+	if c.Simulation.User.PersonalNumber == "" {
+		c.Simulation.User.PersonalNumber = "198112289874"
+		c.Simulation.User.Name, c.Simulation.User.GivenName, c.Simulation.User.Surname = "Karl Karlsson", "Karl", "Karlsson"
+	}
+}
+
+func fixPath(rd, d, f string) string {
+	if path.IsAbs(f) {
+		return f
+	}
+	if path.IsAbs(d) {
+		return path.Join(d, f)
+	}
+	return path.Join(rd, d, f)
+}
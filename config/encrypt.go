@@ -0,0 +1,116 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks an encrypted value in the config file, see EncryptValue
+const encryptedPrefix = "enc:"
+
+// GenerateKey returns a new random key for EncryptValue, base64 encoded as expected in BANKID_CONFIG_KEY
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// EncryptValue encrypts value with AES-256-GCM, using the base64 encoded key, e.g. one returned by
+// GenerateKey. The result, "enc:" followed by the base64 encoded nonce and ciphertext, is used in the
+// config file in place of the value of userPrivateKeyPassword, webhook.secret or proxy.password; the
+// value is decrypted as the config is read, with the key in BANKID_CONFIG_KEY
+func EncryptValue(value, key string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// DecryptValue returns the value encrypted with EncryptValue, using the same key. Values without the
+// "enc:" prefix are returned as they are
+func DecryptValue(value, key string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("could not decrypt value, wrong key?")
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted reports whether value is encrypted with EncryptValue
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+func newAEAD(key string) (cipher.AEAD, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, errors.New("key must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptSecrets decrypts the encrypted values of the settings holding secrets, with the key in
+// BANKID_CONFIG_KEY
+func (c *Config) decryptSecrets() error {
+	if err := decryptSetting("certStore.userPrivateKeyPassword", &c.CertStore.UserPrivateKeyPassword); err != nil {
+		return err
+	}
+	if err := decryptSetting("webhook.secret", &c.Webhook.Secret); err != nil {
+		return err
+	}
+	if err := decryptSetting("proxy.password", &c.Proxy.Password); err != nil {
+		return err
+	}
+	for name, rc := range c.CertStore.Certificates {
+		if err := decryptSetting("certStore.certificates."+name+".userPrivateKeyPassword", &rc.UserPrivateKeyPassword); err != nil {
+			return err
+		}
+		c.CertStore.Certificates[name] = rc
+	}
+	return nil
+}
+
+// decryptSetting decrypts the value of the setting field, if encrypted
+func decryptSetting(field string, value *string) error {
+	if !IsEncrypted(*value) {
+		return nil
+	}
+	key, ok := os.LookupEnv(EnvConfigKey)
+	if !ok {
+		return fmt.Errorf("%s is encrypted, but %s is not set", field, EnvConfigKey)
+	}
+	v, err := DecryptValue(*value, key)
+	if err != nil {
+		return fmt.Errorf("%s: %v", field, err)
+	}
+	*value = v
+	return nil
+}
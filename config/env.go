@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The environment variables read by FromEnv, and applied on top of the config file by New
+const (
+	EnvEnvironment          = "BANKID_ENVIRONMENT"
+	EnvAPIVersion           = "BANKID_API_VERSION"
+	EnvServiceURL           = "BANKID_SERVICE_URL"
+	EnvCertStorePath        = "BANKID_CERT_STORE_PATH"
+	EnvP12Path              = "BANKID_P12_PATH"
+	EnvP12Password          = "BANKID_P12_PASSWORD"
+	EnvCACertPath           = "BANKID_CA_CERT_PATH"
+	EnvPollDelay            = "BANKID_POLL_DELAY"
+	EnvPersonalNumberPolicy = "BANKID_PERSONAL_NUMBER_POLICY"
+	EnvLogFile              = "BANKID_LOG_FILE"
+	EnvLogLevel             = "BANKID_LOG_LEVEL"
+	EnvStrictSecrets        = "BANKID_STRICT_SECRETS"
+	EnvWebhookURL           = "BANKID_WEBHOOK_URL"
+	EnvWebhookSecret        = "BANKID_WEBHOOK_SECRET"
+	EnvProxyURL             = "BANKID_PROXY_URL"
+	EnvProxyPassword        = "BANKID_PROXY_PASSWORD"
+	EnvSimulationScenarios  = "BANKID_SIMULATION_SCENARIOS"
+	EnvRecordingMode        = "BANKID_RECORDING_MODE"
+	EnvConfigKey            = "BANKID_CONFIG_KEY" // Key of the encrypted values, see EncryptValue
+)
+
+// FromEnv returns a pointer to a new instance of a Config struct, holding values from the BANKID_*
+// environment variables only, for deployments without a config file
+func FromEnv() (*Config, error) {
+	myDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve working directory: %v", err)
+	}
+	s := Config{AppDir: myDir, PollDelay: minPollDelay}
+	if err := s.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := s.decryptSecrets(); err != nil {
+		return nil, fmt.Errorf("invalid configuration in environment: %v", err)
+	}
+	s.applyEnvironment()
+	s.applyDefaults()
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration in environment: %w", err)
+	}
+	return &s, nil
+}
+
+// applyEnv overrides the values of c with those of the environment variables that are set
+func (c *Config) applyEnv() error {
+	if v, ok := os.LookupEnv(EnvEnvironment); ok {
+		c.Environment = Environment(v)
+	}
+	setString(&c.APIVersion, EnvAPIVersion)
+	setString(&c.ServiceURL, EnvServiceURL)
+	setString(&c.CertStore.CertStorePath, EnvCertStorePath)
+	setString(&c.CertStore.UserP12FileName, EnvP12Path)
+	setString(&c.CertStore.UserPrivateKeyPassword, EnvP12Password)
+	setString(&c.CertStore.CACertFileName, EnvCACertPath)
+	setString(&c.PersonalNumberPolicy, EnvPersonalNumberPolicy)
+	setString(&c.LogFileName, EnvLogFile)
+	setString(&c.Webhook.URL, EnvWebhookURL)
+	setString(&c.Webhook.Secret, EnvWebhookSecret)
+	setString(&c.Proxy.URL, EnvProxyURL)
+	setString(&c.Proxy.Password, EnvProxyPassword)
+	setString(&c.Simulation.ScenarioFile, EnvSimulationScenarios)
+	setString(&c.Recording.Mode, EnvRecordingMode)
+	if err := setBool(&c.StrictSecrets, EnvStrictSecrets); err != nil {
+		return err
+	}
+	if err := setInt(&c.PollDelay, EnvPollDelay); err != nil {
+		return err
+	}
+	return setInt(&c.LogLevel, EnvLogLevel)
+}
+
+func setString(dst *string, name string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = v
+	}
+}
+
+func setBool(dst *bool, name string) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("environment variable %s must be a boolean: %v", name, err)
+	}
+	*dst = b
+	return nil
+}
+
+func setInt(dst *int, name string) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("environment variable %s must be an integer: %v", name, err)
+	}
+	*dst = i
+	return nil
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// unmarshal decodes raw into v, in the format given by the extension of fileName: YAML for .yaml and
+// .yml, TOML for .toml and JSON for anything else. The keys are the same in all formats
+func unmarshal(raw []byte, fileName string, v interface{}) error {
+	var m map[string]interface{}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(raw, &m); err != nil {
+			return err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(raw, &m); err != nil {
+			return err
+		}
+	default:
+		return json.Unmarshal(raw, v)
+	}
+	// Go through JSON, so that the json tags of Config are used for every format
+	js, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
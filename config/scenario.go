@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// Scenario is the scripted outcome of the simulated orders of one personal number, see the simulation
+// section. Delays left out, or zero, are those of the simulation section
+type Scenario struct {
+	Outcome    string `json:"outcome"`    // "complete", or the hint code of a failed order, e.g. "userCancel"
+	StartDelay int    `json:"startDelay"` // Milliseconds before the simulated user starts the BankID app
+	SignDelay  int    `json:"signDelay"`  // Milliseconds the simulated user takes to sign once started
+}
+
+// SimulationScenarios returns the scenarios of the simulation section by personal number, those of
+// scenarioFile merged with those of the config file, which take precedence. The file maps personal
+// numbers to scenarios, in JSON, or YAML or TOML as given by its extension, e.g.
+//
+//	{"191212121212": {"outcome": "userCancel", "startDelay": 2000, "signDelay": 3000}}
+//
+// It is read relative to the directory of the binary, or from the file system of FromFS. The delays
+// left out of a scenario are filled in
+func (c *Config) SimulationScenarios() (map[string]Scenario, error) {
+	scenarios := make(map[string]Scenario)
+	if f := c.Simulation.ScenarioFile; f != "" {
+		var (
+			raw []byte
+			err error
+		)
+		if c.fsys != nil {
+			raw, err = fs.ReadFile(c.fsys, path.Join(c.fsDir, f))
+		} else {
+			raw, err = os.ReadFile(fixPath(c.AppDir, "", f))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read scenario file %s: %v", f, err)
+		}
+		if err := unmarshal(raw, f, &scenarios); err != nil {
+			return nil, fmt.Errorf("could not unmarshal scenario file %s: %v", f, err)
+		}
+	}
+	for pnr, s := range c.Simulation.Scenarios {
+		scenarios[pnr] = s
+	}
+	for pnr, s := range scenarios {
+		if !validPersonalNumber(pnr) {
+			return nil, fmt.Errorf("scenario %s: personal number must be 12 digits", pnr)
+		}
+		if !validOutcome(s.Outcome) {
+			return nil, fmt.Errorf("scenario %s: outcome %s", pnr, outcomeRule)
+		}
+		if s.StartDelay < 0 || s.SignDelay < 0 {
+			return nil, fmt.Errorf("scenario %s: delays cannot be negative", pnr)
+		}
+		if s.StartDelay == 0 {
+			s.StartDelay = c.Simulation.StartDelay
+		}
+		if s.SignDelay == 0 {
+			s.SignDelay = c.Simulation.SignDelay
+		}
+		scenarios[pnr] = s
+	}
+	return scenarios, nil
+}
+
+// outcomeRule is the validation message of an invalid simulation outcome
+const outcomeRule = "must be one of complete, userCancel, expiredTransaction, certificateErr, startFailed or cancelled"
+
+// validOutcome reports whether outcome is a valid outcome of a simulated order
+func validOutcome(outcome string) bool {
+	switch outcome {
+	case "complete", "userCancel", "expiredTransaction", "certificateErr", "startFailed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// validPersonalNumber reports whether pnr is a personal number of 12 digits
+func validPersonalNumber(pnr string) bool {
+	if len(pnr) != 12 {
+		return false
+	}
+	for _, r := range pnr {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package config
+
+import "crypto/tls"
+
+// defaultCipherSuites are the TLS 1.2 cipher suites offered to the BankID server by default: ECDHE key
+// exchange and AEAD ciphers only. The TLS 1.3 suites are always those of crypto/tls
+var defaultCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// tlsVersions are the values of httpClientConfig.tls.minVersion
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSMinVersion returns the minimum TLS version of the calls to the BankID server, as a crypto/tls
+// constant
+func (c *Config) TLSMinVersion() uint16 {
+	if v, ok := tlsVersions[c.HTTPClientConfig.TLS.MinVersion]; ok {
+		return v
+	}
+	return tls.VersionTLS12
+}
+
+// TLSCipherSuites returns the TLS 1.2 cipher suites of the calls to the BankID server, as crypto/tls
+// IDs
+func (c *Config) TLSCipherSuites() []uint16 {
+	names := c.HTTPClientConfig.TLS.CipherSuites
+	if len(names) == 0 {
+		names = defaultCipherSuites
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		if id, ok := cipherSuiteID(name); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// cipherSuiteID returns the ID of the cipher suite name, if it is one of the secure TLS 1.2 cipher
+// suites of crypto/tls
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name != name {
+			continue
+		}
+		for _, v := range cs.SupportedVersions {
+			if v == tls.VersionTLS12 {
+				return cs.ID, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hossner/bankid/certstore"
+)
+
+// ValidationError describes a single problem with a setting in the configuration
+type ValidationError struct {
+	Field   string // The setting as named in the config file, e.g. "pollDelay"
+	Problem string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Problem
+}
+
+// ValidationErrors holds every problem found when validating a configuration. It is returned, wrapped,
+// by New and FromEnv; use errors.As to get hold of the individual problems
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, ve := range e {
+		msgs[i] = ve.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validate checks all settings, returning ValidationErrors listing every problem found, or nil
+func (c *Config) validate() error {
+	var errs ValidationErrors
+	add := func(field, problem string) {
+		errs = append(errs, ValidationError{Field: field, Problem: problem})
+	}
+
+	if _, ok := hosts[c.Environment]; !ok && c.Environment != EnvironmentCustom && c.Environment != EnvironmentSimulation {
+		add("environment", "must be one of production, test or simulation")
+	}
+	if c.ServiceURL == "" {
+		add("serviceUrl", "cannot be empty unless an environment is set")
+	} else if u, err := url.Parse(c.ServiceURL); err != nil {
+		add("serviceUrl", "is not a valid URL: "+err.Error())
+	} else if u.Scheme != "https" || u.Host == "" {
+		add("serviceUrl", "must be an absolute https URL")
+	}
+	if c.PollDelay < minPollDelay || c.PollDelay > maxPollDelay {
+		add("pollDelay", "must be between "+strconv.Itoa(minPollDelay)+" and "+strconv.Itoa(maxPollDelay)+" milliseconds")
+	}
+	for hint, d := range c.PollDelays {
+		if d < minPollDelay || d > maxPollDelay {
+			add("pollDelays."+hint, "must be between "+strconv.Itoa(minPollDelay)+" and "+strconv.Itoa(maxPollDelay)+" milliseconds")
+		}
+	}
+	switch c.PersonalNumberPolicy {
+	case "allow", "require", "forbid":
+	default:
+		add("personalNumberPolicy", "must be one of allow, require or forbid")
+	}
+	switch c.DuplicateOrderPolicy {
+	case "allow", "cancel", "reject", "queue":
+	default:
+		add("duplicateOrderPolicy", "must be one of allow, cancel, reject or queue")
+	}
+	if c.IdleConnTimeout < 0 {
+		add("idleConnTimeout", "cannot be negative")
+	}
+	if c.ConnReapInterval < 0 {
+		add("connReapInterval", "cannot be negative")
+	}
+	if c.SessionReapInterval < 0 {
+		add("sessionReapInterval", "cannot be negative")
+	}
+	if c.ReconcileInterval < 0 {
+		add("reconcileInterval", "cannot be negative")
+	}
+	if c.RateLimit < 0 {
+		add("rateLimit", "cannot be negative")
+	}
+	if c.RateLimitBurst < 0 {
+		add("rateLimitBurst", "cannot be negative")
+	}
+	switch c.MaxRisk {
+	case "", "low", "moderate", "high":
+	default:
+		add("maxRisk", "must be one of low, moderate or high")
+	}
+	if c.MaxRisk != "" && strings.Contains(c.ServiceURL, "/rp/v5") {
+		add("maxRisk", "requires version 6.0 or later of the RP API, older versions return no risk indicator")
+	}
+	if c.MaxSessions < 0 {
+		add("maxSessions", "cannot be negative")
+	}
+	if c.EventQueueSize < 0 {
+		add("eventQueueSize", "cannot be negative")
+	}
+	switch c.EventQueueOverflow {
+	case "block", "dropOldest", "dropNewest":
+	default:
+		add("eventQueueOverflow", "must be one of block, dropOldest or dropNewest")
+	}
+	if c.StaleQRAfter < 1000 {
+		add("staleQrAfter", "must be at least 1000")
+	}
+	if c.CertExpiryMinDays < 0 {
+		add("certExpiryMinDays", "cannot be negative")
+	}
+
+	if h := c.HTTPClientConfig.RequestHeader.Host; strings.ContainsAny(h, "/ \t\r\n") {
+		add("httpClientConfig.requestHeader.Host", "must be a host name, optionally with a port")
+	}
+	if strings.ContainsAny(c.HTTPClientConfig.RequestHeader.ContentType, "\r\n") {
+		add("httpClientConfig.requestHeader.Content-type", "cannot contain line breaks")
+	}
+	if strings.ContainsAny(c.HTTPClientConfig.UserAgent, "\r\n") {
+		add("httpClientConfig.userAgent", "cannot contain line breaks")
+	}
+	for name, value := range c.HTTPClientConfig.Headers {
+		switch {
+		case !validHeaderName(name):
+			add("httpClientConfig.headers."+name, "is not a valid header name")
+		case reservedHeaders[strings.ToLower(name)]:
+			add("httpClientConfig.headers."+name, "cannot be set here, it is set by the package")
+		case strings.ContainsAny(value, "\r\n"):
+			add("httpClientConfig.headers."+name, "cannot contain line breaks")
+		}
+	}
+	if _, ok := tlsVersions[c.HTTPClientConfig.TLS.MinVersion]; !ok {
+		add("httpClientConfig.tls.minVersion", "must be 1.2 or 1.3")
+	}
+	for _, name := range c.HTTPClientConfig.TLS.CipherSuites {
+		if _, ok := cipherSuiteID(name); !ok {
+			add("httpClientConfig.tls.cipherSuites", name+" is not a secure TLS 1.2 cipher suite")
+		}
+	}
+
+	if c.Webhook.URL != "" {
+		if u, err := url.Parse(c.Webhook.URL); err != nil {
+			add("webhook.url", "is not a valid URL: "+err.Error())
+		} else if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			add("webhook.url", "must be an absolute http or https URL")
+		}
+		if c.Webhook.Secret == "" {
+			add("webhook.secret", "cannot be empty if webhook.url is set")
+		}
+	}
+	if !validOutcome(c.Simulation.Outcome) {
+		add("simulation.outcome", outcomeRule)
+	}
+	if c.Simulation.StartDelay < 0 {
+		add("simulation.startDelay", "cannot be negative")
+	}
+	if c.Simulation.SignDelay < 0 {
+		add("simulation.signDelay", "cannot be negative")
+	}
+	if c.Environment == EnvironmentSimulation {
+		if _, err := c.SimulationScenarios(); err != nil {
+			add("simulation.scenarios", err.Error())
+		}
+	}
+	switch c.Recording.Mode {
+	case "":
+	case "record", "replay":
+		if c.Recording.File == "" {
+			add("recording.file", "cannot be empty if recording.mode is set")
+		}
+		if c.Environment == EnvironmentProduction {
+			add("recording.mode", "cannot be set in the production environment")
+		}
+	default:
+		add("recording.mode", "must be record or replay, or empty")
+	}
+	if c.Proxy.URL != "" {
+		if u, err := url.Parse(c.Proxy.URL); err != nil {
+			add("proxy.url", "is not a valid URL: "+err.Error())
+		} else if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			add("proxy.url", "must be an absolute http, https or socks5 URL")
+		}
+	}
+	if c.Proxy.Username != "" && c.Proxy.URL == "" {
+		add("proxy.username", "cannot be set unless proxy.url is set")
+	}
+	if c.Proxy.Password != "" && c.Proxy.Username == "" {
+		add("proxy.password", "cannot be set unless proxy.username is set")
+	}
+	if c.Webhook.MaxRetries < 0 {
+		add("webhook.maxRetries", "cannot be negative")
+	}
+	if c.StrictSecrets && c.passwordInFile {
+		add("certStore.userPrivateKeyPassword", "cannot be set in plain text in the config file with strictSecrets, encrypt it, or use "+EnvP12Password+" or a secret provider")
+	}
+	if c.CertStore.UserCertThumbprint != "" {
+		if _, err := certstore.ParseThumbprint(c.CertStore.UserCertThumbprint); err != nil {
+			add("certStore.userCertThumbprint", err.Error())
+		}
+	}
+	// The P12 file may be left out when it is supplied by a secret provider
+	if c.CertStore.UserP12FileName != "" {
+		if err := c.checkCertFile(c.CertStore.UserP12FileName); err != nil {
+			add("certStore.userP12FileName", err.Error())
+		}
+	}
+	for name, rc := range c.CertStore.Certificates {
+		field := "certStore.certificates." + name
+		switch {
+		case rc.UserCertThumbprint != "":
+			if _, err := certstore.ParseThumbprint(rc.UserCertThumbprint); err != nil {
+				add(field+".userCertThumbprint", err.Error())
+			}
+		case rc.UserP12FileName == "":
+			add(field, "must have a userP12FileName or a userCertThumbprint")
+		default:
+			if err := c.checkCertFile(rc.UserP12FileName); err != nil {
+				add(field+".userP12FileName", err.Error())
+			}
+		}
+	}
+	if c.CertStore.CACertFileName != "" {
+		if err := c.checkCertFile(c.CertStore.CACertFileName); err != nil {
+			add("certStore.caCertFileName", err.Error())
+		}
+	}
+
+	if c.LogLevel < 0 || c.LogLevel > 5 {
+		add("logLevel", "must be between 0 and 5")
+	}
+	if c.LogPrefix != "" && !strings.Contains(c.LogPrefix, LogLevelPlaceholder) {
+		add("logPrefix", "must contain "+LogLevelPlaceholder)
+	}
+	switch c.LogFormat {
+	case "text", "json":
+	default:
+		add("logFormat", "must be one of text or json")
+	}
+	if c.LogMaxSize < 0 {
+		add("logMaxSize", "cannot be negative")
+	}
+	if c.LogMaxAge < 0 {
+		add("logMaxAge", "cannot be negative")
+	}
+	if c.LogMaxBackups < 0 {
+		add("logMaxBackups", "cannot be negative")
+	}
+	if c.LogPersonalData && c.Environment == EnvironmentProduction {
+		add("logPersonalData", "cannot be set in the production environment")
+	}
+	if c.LogLevel > 0 && c.LogFileName == "" {
+		add("logFile", "cannot be empty if logLevel is set")
+	}
+	if c.LogFileName != "" {
+		if fi, err := os.Stat(filepath.Dir(c.LogFilePath())); err != nil || !fi.IsDir() {
+			add("logFile", "directory does not exist")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkCertFile checks that fileName of the certStore section can be opened, in the file system of
+// FromFS if the config was read with it
+func (c *Config) checkCertFile(fileName string) error {
+	if c.fsys != nil {
+		f, err := c.fsys.Open(path.Join(c.fsDir, c.CertStore.CertStorePath, fileName))
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return checkReadable(fixPath(c.AppDir, c.CertStore.CertStorePath, fileName))
+}
+
+// checkReadable returns an error if the file can not be opened for reading
+func checkReadable(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// reservedHeaders are the headers, in lower case, that cannot be set in httpClientConfig.headers
+var reservedHeaders = map[string]bool{"host": true, "content-type": true, "content-length": true, "user-agent": true}
+
+// validHeaderName reports whether name is a valid HTTP header field name, a token of RFC 7230
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+	return true
+}
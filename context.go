@@ -0,0 +1,74 @@
+package bankid
+
+import "context"
+
+// requestIDKey is the context key of the request ID, see RequestIDFromContext
+type requestIDKey struct{}
+
+// FOnEventContext is a call back function receiving an Event for every status update, with the
+// context of the request, see SetEventHandlerContext
+type FOnEventContext func(ctx context.Context, ev Event)
+
+// FOnNewQRCodeContext is a call back function receiving the animated QR codes of a request, with the
+// context of the request, see WithQRCallbackContext
+type FOnNewQRCodeContext func(ctx context.Context, qrCode []byte, requestID string)
+
+// RequestIDFromContext returns the request ID of the context passed to the call back functions, e.g.
+// to correlate logs and traces of the consumer with those of the request
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// WithContext sets the parent of the context of the request, e.g. the context of the HTTP request
+// starting it, carrying a trace. Only its values are used; the request is not ended when it is done
+func WithContext(ctx context.Context) RequestOption {
+	return func(r *request) {
+		r.ctx = ctx
+	}
+}
+
+// WithQRCallbackContext enables animated QR codes for the request like WithQRCallback, calling f
+// with the context of the request. Ignored if WithQRCallback is used too
+func WithQRCallbackContext(f FOnNewQRCodeContext) RequestOption {
+	return func(r *request) {
+		r.onQRCodeCtx = f
+	}
+}
+
+// SetEventHandlerContext sets a call back function receiving an Event for every status update, with
+// the context of the request, in addition to the FOnEvent call back function. The context carries
+// the request ID, see RequestIDFromContext, and the values of the context set with WithContext. It
+// is done once the request has been cancelled, when its last event has been delivered, or 10 minutes
+// after it was sent. Events not tied to a request get a background context. Should be called before
+// any request is sent
+func (sc *Connection) SetEventHandlerContext(f FOnEventContext) {
+	sc.funcOnEventCtx = f
+}
+
+// WithEventHandlerContext sets the FOnEventContext call back function, see SetEventHandlerContext
+func WithEventHandlerContext(f FOnEventContext) Option {
+	return func(sc *Connection) error {
+		sc.SetEventHandlerContext(f)
+		return nil
+	}
+}
+
+// requestContext returns the context of a request sent now, with the values of parent, if any. The
+// deadline is a timer of the runtime, so it is measured in wall clock time whatever the Clock of the
+// connection
+func requestContext(parent context.Context, requestID string) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx := context.WithValue(context.WithoutCancel(parent), requestIDKey{}, requestID)
+	return context.WithTimeout(ctx, maxOrderAge)
+}
+
+// eventContext returns the context of the events of requestID, of the session s if any
+func eventContext(s *session, requestID string) context.Context {
+	if s != nil {
+		return s.ctx
+	}
+	return context.WithValue(context.Background(), requestIDKey{}, requestID)
+}
@@ -0,0 +1,67 @@
+package bankid
+
+import "errors"
+
+// ErrDuplicateOrder is the error reported for requests refused because an order is already ongoing
+// for the personal number, with duplicateOrderPolicy set to "reject"
+var ErrDuplicateOrder = errors.New("an order is already ongoing for the personal number")
+
+// errCancelledWhileQueued is returned by claimPersonalNumber when the request is cancelled while
+// waiting for an ongoing order of the same personal number to end
+var errCancelledWhileQueued = errors.New("cancelled while queued")
+
+// DuplicateOrderPolicy decides what happens to a request with a personal number for which an order is
+// already ongoing. It is set with "duplicateOrderPolicy" in the config file
+type DuplicateOrderPolicy string
+
+// The available duplicate order policies. Requests without a personal number are not affected
+const (
+	DuplicateAllow  DuplicateOrderPolicy = "allow"  // Sent as is; the BankID server then refuses it with alreadyInProgress, and cancels the ongoing order
+	DuplicateCancel DuplicateOrderPolicy = "cancel" // The ongoing order is cancelled before the request is sent, which is retried once if refused with alreadyInProgress
+	DuplicateReject DuplicateOrderPolicy = "reject" // Refused with ErrDuplicateOrder
+	DuplicateQueue  DuplicateOrderPolicy = "queue"  // Sent once the ongoing order has ended
+)
+
+// claimPersonalNumber makes s the session of the personal number pnr, applying the duplicate order
+// policy to any ongoing session of pnr. Only called by the owner of s
+func (sc *Connection) claimPersonalNumber(s *session, pnr string) error {
+	policy := DuplicateOrderPolicy(sc.cfg.DuplicateOrderPolicy)
+	if pnr == "" || (policy != DuplicateCancel && policy != DuplicateReject && policy != DuplicateQueue) {
+		return nil
+	}
+	for {
+		sc.sessMu.Lock()
+		prev := sc.byPersonalNumber[pnr]
+		if prev == nil {
+			sc.byPersonalNumber[pnr] = s
+			s.personalNumber = pnr
+			sc.sessMu.Unlock()
+			return nil
+		}
+		sc.sessMu.Unlock()
+		switch policy {
+		case DuplicateReject:
+			return ErrDuplicateOrder
+		case DuplicateCancel:
+			sc.logprint(INFO, s.requestID, ": cancelling ongoing order", prev.requestID, "of the same personal number")
+			prev.requestCancel()
+		case DuplicateQueue:
+			sc.logprint(DEBUG, s.requestID, ": queued after ongoing order", prev.requestID, "of the same personal number")
+		}
+		select {
+		case <-prev.done:
+		case <-s.cancel:
+			return errCancelledWhileQueued
+		}
+	}
+}
+
+// retryDuplicate tells whether a request refused by the BankID server with the HTTP status code and
+// body is to be sent again, as the ongoing order of the user has been cancelled by the refusal
+func (sc *Connection) retryDuplicate(code int, resp []byte) bool {
+	if DuplicateOrderPolicy(sc.cfg.DuplicateOrderPolicy) != DuplicateCancel || code == 200 {
+		return false
+	}
+	er, _ := handleServerError(code, resp)
+	return er == "alreadyInProgress"
+}
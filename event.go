@@ -0,0 +1,299 @@
+package bankid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hossner/bankid/messages"
+)
+
+// Event describes a status update of a request. It carries the same information as the arguments
+// to the FOnResponse call back function, but with typed status and hint code, timestamps and, for
+// completed requests, the completion data
+type Event struct {
+	RequestID   string
+	Status      Status
+	HintCode    HintCode // Set when Status is StatusPending or StatusFailed
+	ErrorCode   string   // Set when Status is StatusError and the request was rejected by the BankID server
+	Message     string
+	Time        time.Time        // Local time of the event. Carries a monotonic clock reading, for ordering
+	Started     *StartedOrder    // Set when Status is "sent"
+	Completion  *CompletionData  // Set when Status is "complete"
+	Collect     *CollectResponse // The collect response behind the event, for pending, failed and completed requests
+	Err         error            // Set for errors with a sentinel value, e.g. ErrCertificateExpiring
+	Language    string           // Language of UserMessage, see WithLanguage
+	UserMessage string           // Text to show the end user, from the Localizer of the connection, empty if none
+}
+
+// CollectResponse is a response of the BankID server to a collect call, typed
+type CollectResponse struct {
+	OrderRef       string
+	Status         Status // "pending", "failed" or "complete"
+	HintCode       HintCode
+	CompletionData *CompletionData            // Set when Status is "complete"
+	Raw            []byte                     // The response body as received, if requested with WithRawResponse
+	Extra          map[string]json.RawMessage // Fields not known by this package, e.g. added by a later API version
+}
+
+// StartedOrder holds the order of a request accepted by the BankID server. Callers generating the QR
+// codes in another process, e.g. a mobile backend, need the QR start token and secret and the time
+// of the order, see QRCode
+type StartedOrder struct {
+	RequestID      string
+	OrderRef       string
+	EndUserIP      string
+	AutoStartToken string
+	QRStartToken   string
+	QRStartSecret  string
+	OrderTime      time.Time // When the order was accepted, the start of the animated QR code
+}
+
+// FOnStarted is a call back function receiving the StartedOrder of a request, see WithStartedCallback
+type FOnStarted func(so StartedOrder)
+
+// FOnEvent is a call back function receiving an Event for every status update, see SetEventHandler
+type FOnEvent func(ev Event)
+
+// CompletionData holds the result of a completed request, as returned by the BankID server, with
+// timestamps converted to time.Time
+type CompletionData struct {
+	OrderRef string
+	User     User
+	Device   struct {
+		IPAddress string
+	}
+	Cert struct {
+		NotBefore time.Time
+		NotAfter  time.Time
+	}
+	Signature    string                     // Base64 encoded XML signature
+	OCSPResponse string                     // Base64 encoded OCSP response
+	OCSP         *OCSPStatus                // Result of VerifyOCSP, nil unless verified
+	Risk         string                     // Risk indicator, "low", "moderate" or "high", if returned by the BankID server
+	Extra        map[string]json.RawMessage // Fields of the completion data not known by this package, e.g. added by a later API version
+}
+
+// User identifies the user of a completed request. Accounts should be keyed on the personal number;
+// the names are for display only
+type User struct {
+	PersonalNumber string // 12 digits, YYYYMMDDNNNN
+	Name           string
+	GivenName      string
+	Surname        string
+}
+
+// SetEventHandler sets a call back function receiving an Event for every status update, in addition
+// to the FOnResponse call back function passed to New. Should be called before any request is sent
+func (sc *Connection) SetEventHandler(f FOnEvent) {
+	sc.warnMu.Lock()
+	sc.funcOnEvent = f
+	warnings := sc.warnings
+	sc.warnings = nil
+	sc.warnMu.Unlock()
+	if f != nil {
+		for _, ev := range warnings {
+			sc.callback("", "FOnEvent", func() { f(ev) })
+		}
+	}
+}
+
+// respond reports a status update to the caller
+func (sc *Connection) respond(requestID string, status Status, message string) {
+	sc.emit(Event{RequestID: requestID, Status: status, Message: message})
+}
+
+// respondServerError reports a request rejected by the BankID server to the caller
+func (sc *Connection) respondServerError(requestID, errorCode, details string) {
+	sc.emit(Event{RequestID: requestID, Status: StatusError, ErrorCode: errorCode, Message: details})
+}
+
+// emit timestamps the event, records it in the session of the request and has it delivered
+func (sc *Connection) emit(ev Event) {
+	ev.Time = sc.clock.Now()
+	s := sc.session(ev.RequestID)
+	if s != nil {
+		s.setStatus(ev.Status, ev.HintCode)
+	}
+	sc.observeEvent(ev)
+	ev.Language = sc.cfg.Language
+	if s != nil && s.req.language != "" {
+		ev.Language = s.req.language
+	}
+	ev.UserMessage = sc.localize(ev)
+	ctx := eventContext(s, ev.RequestID)
+	sc.dispatch(s, ev.RequestID, ev.Status == StatusPending, func() { sc.deliver(ctx, ev) })
+}
+
+// dispatch calls deliver through the event queue of the session s, if it has one, or else at once.
+// Events of pending orders are droppable, see EventQueueOverflow
+func (sc *Connection) dispatch(s *session, requestID string, droppable bool, deliver func()) {
+	if s == nil || s.events == nil {
+		deliver()
+		return
+	}
+	if !s.events.push(deliver, droppable) {
+		sc.logprint(WARN, requestID, ": event queue full, event dropped")
+		sc.metrics.IncCounter(MetricDroppedEvents, nil)
+	}
+}
+
+// deliver passes the event on to the call back functions, observers, audit log, publisher and webhook
+func (sc *Connection) deliver(ctx context.Context, ev Event) {
+	sc.notify(ctx, ev)
+	sc.obsMu.Lock()
+	f := sc.observers[ev.RequestID]
+	sc.obsMu.Unlock()
+	if f != nil {
+		f(ev)
+	}
+	sc.audit(ev)
+	sc.publish(ev)
+	if ev.Status.final() {
+		sc.log.setOrderRef(ev.RequestID, "")
+	}
+	if sc.webhook != nil && ev.Status.final() {
+		sc.deliverWebhook(ev)
+	}
+}
+
+// notify passes the event on to the call back functions
+func (sc *Connection) notify(ctx context.Context, ev Event) {
+	status, message := ev.responseArgs()
+	sc.callback(ev.RequestID, "FOnResponse", func() { sc.funcOnResponse(ev.RequestID, status, message) })
+	if f := sc.funcOnEvent; f != nil {
+		sc.callback(ev.RequestID, "FOnEvent", func() { f(ev) })
+	}
+	if f := sc.funcOnEventCtx; f != nil {
+		sc.callback(ev.RequestID, "FOnEventContext", func() { f(ctx, ev) })
+	}
+}
+
+// observe registers f to be called with every event of requestID, after the call back functions.
+// Used by helpers built on top of the requests, e.g. SignBatch
+func (sc *Connection) observe(requestID string, f func(Event)) {
+	sc.obsMu.Lock()
+	defer sc.obsMu.Unlock()
+	sc.observers[requestID] = f
+}
+
+func (sc *Connection) unobserve(requestID string) {
+	sc.obsMu.Lock()
+	defer sc.obsMu.Unlock()
+	delete(sc.observers, requestID)
+}
+
+// qrStale reports an order still showing QR codes long past typical scan times, e.g. because the QR
+// code is hidden behind a modal. The order itself goes on
+func (sc *Connection) qrStale(requestID string, codes int) {
+	sc.logprint(INFO, requestID, ": QR code still shown after", strconv.Itoa(codes), "renewals")
+	sc.metrics.IncCounter(MetricStaleQR, nil)
+	if f := sc.funcOnEvent; sc.cfg.StaleQREvent && f != nil {
+		ev := Event{RequestID: requestID, Status: StatusQRStale, Time: sc.clock.Now()}
+		sc.dispatch(sc.session(requestID), requestID, true, func() {
+			sc.callback(requestID, "FOnEvent", func() { f(ev) })
+		})
+	}
+}
+
+// MessageKey returns the recommended user message for the event, see the messages package, e.g. to
+// look up its text in the language of the user with messages.Text. Empty for events without one, such
+// as those of completed requests
+func (ev Event) MessageKey() messages.Key {
+	switch ev.Status {
+	case StatusSent:
+		return messages.RFA21
+	case StatusPending:
+		return messages.ForHint(string(ev.HintCode), true)
+	case StatusFailed:
+		return messages.ForHint(string(ev.HintCode), false)
+	case StatusCancelled:
+		return messages.RFA3
+	case StatusError:
+		if errors.Is(ev.Err, ErrDuplicateOrder) {
+			return messages.RFA4
+		}
+		return messages.ForError(ev.ErrorCode)
+	}
+	return ""
+}
+
+// responseArgs returns the status and message arguments of the FOnResponse call back function
+func (ev Event) responseArgs() (status, message string) {
+	switch {
+	case ev.Status == StatusPending:
+		return string(ev.HintCode), string(ev.Status)
+	case ev.Status == StatusFailed:
+		return string(ev.Status), string(ev.HintCode)
+	case ev.ErrorCode != "":
+		return ev.ErrorCode, ev.Message
+	}
+	return string(ev.Status), ev.Message
+}
+
+// collectResponse converts a collect response, keeping the body raw if asked to
+func (sr *serverResponse) collectResponse(raw []byte, keepRaw bool) *CollectResponse {
+	cr := &CollectResponse{OrderRef: sr.OrderRef, Status: Status(sr.Status), HintCode: sr.HintCode}
+	if keepRaw {
+		cr.Raw = append([]byte(nil), raw...) // raw is reused for later responses
+	}
+	cr.Extra = unknownFields(raw, "orderRef", "status", "hintCode", "completionData")
+	return cr
+}
+
+// completionExtra returns the fields of the completion data in the collect response raw not known by
+// this package, or nil if none
+func completionExtra(raw []byte) map[string]json.RawMessage {
+	var body struct {
+		CompletionData json.RawMessage `json:"completionData"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil
+	}
+	return unknownFields(body.CompletionData, "user", "device", "cert", "signature", "ocspResponse", "risk")
+}
+
+// unknownFields returns the fields of the JSON object raw not among the known ones, or nil if none
+func unknownFields(raw []byte, known ...string) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	for _, k := range known {
+		delete(fields, k)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// completionData converts the completion data of a collect response
+func (sr *serverResponse) completionData() *CompletionData {
+	var cd CompletionData
+	src := &sr.CompletionData
+	cd.OrderRef = sr.OrderRef
+	cd.User.PersonalNumber = src.User.PersonalNumber
+	cd.User.Name = src.User.Name
+	cd.User.GivenName = src.User.GivenName
+	cd.User.Surname = src.User.Surname
+	cd.Device.IPAddress = src.Device.IPAddress
+	cd.Cert.NotBefore = parseUnixMillis(src.Cert.NotBefore)
+	cd.Cert.NotAfter = parseUnixMillis(src.Cert.NotAfter)
+	cd.Signature = src.Signature
+	cd.OCSPResponse = src.OCSPResponse
+	cd.Risk = src.Risk
+	return &cd
+}
+
+// parseUnixMillis parses the Unix time in milliseconds, as used by the BankID server. Returns the
+// zero time if s can not be parsed
+func parseUnixMillis(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
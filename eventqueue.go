@@ -0,0 +1,105 @@
+package bankid
+
+import "sync"
+
+// EventQueueOverflow decides what happens to an event of a request whose event queue is full, i.e.
+// when the call back functions are slower than the status updates. It is set with
+// "eventQueueOverflow" in the config file, and only applies when "eventQueueSize" is set
+type EventQueueOverflow string
+
+// The available overflow policies. Only events of pending orders, with status "pending" or "qrStale",
+// are ever dropped; the other events wait for room in the queue
+const (
+	OverflowBlock      EventQueueOverflow = "block"      // The poll loop waits for room in the queue
+	OverflowDropOldest EventQueueOverflow = "dropOldest" // The oldest queued event of the pending order is dropped
+	OverflowDropNewest EventQueueOverflow = "dropNewest" // The new event is dropped
+)
+
+// eventQueue delivers the events of a session, in order, from a go routine of its own, so that slow
+// call back functions do not hold up the polling of the order
+type eventQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond // Signalled when an event is queued or taken, and when the queue is closed
+	events   []queuedEvent
+	size     int
+	overflow EventQueueOverflow
+	closed   bool
+}
+
+type queuedEvent struct {
+	deliver   func()
+	droppable bool
+}
+
+func newEventQueue(size int, overflow EventQueueOverflow) *eventQueue {
+	q := &eventQueue{size: size, overflow: overflow}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues deliver, waiting for room if the queue is full and the event may not be dropped.
+// Returns false if an event was dropped to make room, or instead of queuing this one. Events pushed
+// after the queue is closed, i.e. after the order has ended, are dropped silently
+func (q *eventQueue) push(deliver func(), droppable bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := false
+	for !q.closed && len(q.events) >= q.size {
+		if droppable && q.overflow == OverflowDropNewest {
+			return false
+		}
+		if q.overflow == OverflowDropOldest && q.dropOldest() {
+			dropped = true
+			continue
+		}
+		q.cond.Wait()
+	}
+	if q.closed {
+		return !dropped
+	}
+	q.events = append(q.events, queuedEvent{deliver: deliver, droppable: droppable})
+	q.cond.Broadcast()
+	return !dropped
+}
+
+// dropOldest removes the oldest droppable event, if any
+func (q *eventQueue) dropOldest() bool {
+	for i, ev := range q.events {
+		if ev.droppable {
+			q.events = append(q.events[:i], q.events[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// close has run return once the events queued have been delivered. Safe to call on a nil queue
+func (q *eventQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// run delivers the queued events, in order, until the queue is closed and empty
+func (q *eventQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		ev := q.events[0]
+		q.events[0] = queuedEvent{}
+		q.events = q.events[1:]
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		ev.deliver()
+	}
+}
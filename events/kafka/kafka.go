@@ -0,0 +1,32 @@
+// Package kafka publishes the lifecycle events of a bankid.Connection to Kafka
+//
+//	conn.SetPublisher(kafka.New(&kafkago.Writer{Addr: kafkago.TCP("localhost:9092"), Topic: "bankid"}))
+package kafka
+
+import (
+	"context"
+
+	"github.com/hossner/bankid"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// Publisher implements the bankid.Publisher interface. Events are keyed by request ID, so that the
+// events of an order end up in the same partition, in order, with the type in a "type" header
+type Publisher struct {
+	w *kafkago.Writer
+}
+
+// New returns a Publisher writing with w, which decides the topic, batching and acknowledgements
+func New(w *kafkago.Writer) *Publisher {
+	return &Publisher{w: w}
+}
+
+// Publish implements the bankid.Publisher interface
+func (p *Publisher) Publish(ctx context.Context, ev bankid.LifecycleEvent, payload []byte) error {
+	return p.w.WriteMessages(ctx, kafkago.Message{
+		Key:     []byte(ev.RequestID),
+		Value:   payload,
+		Time:    ev.Time,
+		Headers: []kafkago.Header{{Key: "type", Value: []byte(ev.Type)}},
+	})
+}
@@ -0,0 +1,32 @@
+// Package nats publishes the lifecycle events of a bankid.Connection to NATS
+//
+//	conn.SetPublisher(nats.New(nc, "bankid"))
+package nats
+
+import (
+	"context"
+
+	"github.com/hossner/bankid"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// Publisher implements the bankid.Publisher interface. Events are published on the subject
+// {prefix}.{type}, e.g. bankid.completed, with a Nats-Msg-Id header for deduplication by JetStream
+type Publisher struct {
+	nc     *natsgo.Conn
+	prefix string
+}
+
+// New returns a Publisher publishing on nc, with subjects starting with prefix
+func New(nc *natsgo.Conn, prefix string) *Publisher {
+	return &Publisher{nc: nc, prefix: prefix}
+}
+
+// Publish implements the bankid.Publisher interface
+func (p *Publisher) Publish(ctx context.Context, ev bankid.LifecycleEvent, payload []byte) error {
+	msg := natsgo.NewMsg(p.prefix + "." + string(ev.Type))
+	msg.Data = payload
+	msg.Header.Set("Content-Type", "application/json")
+	msg.Header.Set(natsgo.MsgIdHdr, ev.RequestID+"."+string(ev.Type)+"."+string(ev.HintCode))
+	return p.nc.PublishMsg(msg)
+}
@@ -0,0 +1,35 @@
+// Package rabbitmq publishes the lifecycle events of a bankid.Connection to RabbitMQ
+//
+//	conn.SetPublisher(rabbitmq.New(ch, "bankid"))
+package rabbitmq
+
+import (
+	"context"
+
+	"github.com/hossner/bankid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher implements the bankid.Publisher interface. Events are published as persistent messages
+// to the exchange, with the routing key bankid.{type}, e.g. bankid.completed
+type Publisher struct {
+	ch       *amqp.Channel
+	exchange string
+}
+
+// New returns a Publisher publishing on ch to exchange, which has to be declared by the caller
+func New(ch *amqp.Channel, exchange string) *Publisher {
+	return &Publisher{ch: ch, exchange: exchange}
+}
+
+// Publish implements the bankid.Publisher interface
+func (p *Publisher) Publish(ctx context.Context, ev bankid.LifecycleEvent, payload []byte) error {
+	return p.ch.PublishWithContext(ctx, p.exchange, "bankid."+string(ev.Type), false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    ev.RequestID + "." + string(ev.Type) + "." + string(ev.HintCode),
+		Timestamp:    ev.Time,
+		Type:         string(ev.Type),
+		Body:         payload,
+	})
+}
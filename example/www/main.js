@@ -2,35 +2,75 @@ var bidSocket
 var sessID = ""
 
 function loaded(){
-    bidSocket = new WebSocket("ws://127.0.0.1:8080/ws")
+    // Returning from the BankID app, the session to resume is in the fragment of the return URL
+    var m = window.location.hash.match(/^#session=(.+)$/)
+    if (m){
+        sessID = m[1]
+        history.replaceState(null, "", window.location.pathname)
+    }
+    connect()
+}
+
+// connect opens the websocket, resuming the session after a reconnect
+function connect(){
+    var url = "ws://127.0.0.1:8080/ws"
+    if (sessID != ""){
+        url += "?session=" + sessID
+    }
+    bidSocket = new WebSocket(url)
     var stat = document.getElementById("status-div");
     var longstat = document.getElementById("long-status-div");
 
-    var update = function(){
-        bidSocket.onmessage = function (event) {
-            msg = JSON.parse(event.data)
-            stat.textContent = msg.action
-            if (msg.action == "error"){
-                longstat.textContent = msg.value
-            } else if (msg.action == "qrcode") {
-                qrImg.src = "data:image/png;base64," + msg.value;
-            } else {
-                longstat.textContent = ""
-            }
-            console.log(msg.action)
-            console.log(msg.value)
+    bidSocket.onmessage = function (event) {
+        msg = JSON.parse(event.data)
+        if (msg.action == "session"){
+            sessID = msg.value
+            return
         }
-      };
-      window.setTimeout(update);
-
+        if (msg.action == "migrated"){
+            // The session has been taken over by another window
+            sessID = ""
+            return
+        }
+        if (msg.action == "error" && msg.value == "unknown session"){
+            // The session has expired; start a new one
+            sessID = ""
+            window.setTimeout(connect, 0)
+            return
+        }
+        stat.textContent = msg.action
+        if (msg.action == "error"){
+            longstat.textContent = msg.value
+        } else if (msg.action == "qrcode") {
+            qrImg.src = "data:image/png;base64," + msg.value;
+        } else if (msg.action == "launch") {
+            // Start the BankID app on this device; it returns the user to the return URL when done
+            window.location.href = msg.value
+        } else {
+            longstat.textContent = ""
+        }
+        console.log(msg.action)
+        console.log(msg.value)
+    }
+    bidSocket.onclose = function (event) {
+        if (sessID != ""){
+            window.setTimeout(connect, 1000)
+        }
+    }
 }
 
 function sendPnr(nr){
     console.log(nr)
-    bidSocket.send(JSON.stringify({action:"pnrAuth", value:nr, id:sessID}))
+    bidSocket.send(JSON.stringify({action:"pnrAuth", value:nr}))
 }
 
 function getqrcode(){
     console.log("Using QR code")
-    bidSocket.send(JSON.stringify({action:"qrCode", value:"", id:sessID}))
-}
\ No newline at end of file
+    bidSocket.send(JSON.stringify({action:"qrCode"}))
+}
+
+function autostart(){
+    console.log("Using BankID on this device")
+    var returnUrl = window.location.origin + window.location.pathname + "#session=" + sessID
+    bidSocket.send(JSON.stringify({action:"autoStart", value:returnUrl}))
+}
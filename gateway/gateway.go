@@ -0,0 +1,351 @@
+// Package gateway is a self-contained HTTP JSON API on top of a bankid.Connection, so that the
+// package can run as a BankID proxy next to applications not written in Go. The API is described by
+// the OpenAPI document served at /openapi.json, and consists of the following routes:
+//
+//	POST   /v1/orders             starts an authentication or sign order, returns the order
+//	GET    /v1/orders/{id}        returns the current status of the order, and its completion data
+//	DELETE /v1/orders/{id}        cancels an ongoing order
+//	GET    /v1/orders/{id}/qr.png returns the latest animated QR code of the order as a PNG image
+//
+// Every route but /openapi.json requires one of the API keys of the gateway, passed in an X-API-Key
+// header or as a bearer token in the Authorization header.
+package gateway
+
+import (
+	"crypto/subtle"
+	_ "embed" // For the OpenAPI document
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid"
+	"github.com/hossner/bankid/internal/web"
+)
+
+const defaultRetention = 5 * time.Minute
+
+//go:embed openapi.json
+var openAPI []byte
+
+// Gateway is an http.Handler serving the BankID REST API
+type Gateway struct {
+	// Retention is how long an order is kept after it has ended
+	Retention time.Duration
+
+	conn    *bankid.Connection
+	apiKeys [][]byte
+	mux     *http.ServeMux
+	mu      sync.Mutex
+	orders  map[string]*order
+}
+
+// order is the last known state of an order
+type order struct {
+	typ            string
+	status         bankid.Status
+	hintCode       bankid.HintCode
+	errorCode      string
+	message        string
+	autoStartToken string
+	userMessage    string // In the language of the order
+	completion     *bankid.CompletionData
+	qrCode         []byte
+}
+
+// orderRequest is the JSON body of a POST to /v1/orders
+type orderRequest struct {
+	Type               string `json:"type"` // "auth" or "sign"
+	EndUserIP          string `json:"endUserIp"`
+	PersonalNumber     string `json:"personalNumber,omitempty"`
+	UserVisibleData    string `json:"userVisibleData,omitempty"`
+	UserNonVisibleData string `json:"userNonVisibleData,omitempty"`
+	Language           string `json:"language,omitempty"` // Of userMessage, e.g. "sv"
+}
+
+// orderResponse is the JSON body describing an order
+type orderResponse struct {
+	ID             string              `json:"id"`
+	Type           string              `json:"type"`
+	Status         string              `json:"status"`
+	HintCode       string              `json:"hintCode,omitempty"`
+	ErrorCode      string              `json:"errorCode,omitempty"`
+	Message        string              `json:"message,omitempty"`
+	RFA            string              `json:"rfa,omitempty"`
+	UserMessage    string              `json:"userMessage,omitempty"`
+	AutoStartToken string              `json:"autoStartToken,omitempty"`
+	Completion     *completionResponse `json:"completion,omitempty"`
+}
+
+type completionResponse struct {
+	OrderRef string `json:"orderRef"`
+	User     struct {
+		PersonalNumber string `json:"personalNumber"`
+		Name           string `json:"name"`
+		GivenName      string `json:"givenName"`
+		Surname        string `json:"surname"`
+	} `json:"user"`
+	Device struct {
+		IPAddress string `json:"ipAddress"`
+	} `json:"device"`
+	Cert struct {
+		NotBefore time.Time `json:"notBefore"`
+		NotAfter  time.Time `json:"notAfter"`
+	} `json:"cert"`
+	Signature    string `json:"signature"`
+	OCSPResponse string `json:"ocspResponse"`
+	Risk         string `json:"risk,omitempty"`
+}
+
+// New returns a Gateway with a new connection to the BankID server, configured from configFileName,
+// accepting requests with any of the API keys, of which there has to be at least one
+func New(configFileName string, apiKeys ...string) (*Gateway, error) {
+	if len(apiKeys) == 0 {
+		return nil, errors.New("no API keys provided")
+	}
+	g := &Gateway{
+		Retention: defaultRetention,
+		mux:       http.NewServeMux(),
+		orders:    make(map[string]*order),
+	}
+	for _, k := range apiKeys {
+		if k == "" {
+			return nil, errors.New("API keys cannot be empty")
+		}
+		g.apiKeys = append(g.apiKeys, []byte(k))
+	}
+	conn, err := bankid.New(configFileName, func(requestID, status, message string) {})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetEventHandler(g.onEvent)
+	g.conn = conn
+	g.mux.HandleFunc("/openapi.json", g.handleOpenAPI)
+	g.mux.HandleFunc("/v1/orders", g.authorized(g.handleOrders))
+	g.mux.HandleFunc("/v1/orders/", g.authorized(g.handleOrder))
+	return g, nil
+}
+
+// Connection returns the underlying BankID connection
+func (g *Gateway) Connection() *bankid.Connection {
+	return g.conn
+}
+
+// Close closes the underlying BankID connection
+func (g *Gateway) Close() {
+	g.conn.Close()
+}
+
+// ServeHTTP implements the http.Handler interface
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// authorized wraps f, refusing requests without a valid API key
+func (g *Gateway) authorized(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				key = strings.TrimPrefix(auth, "Bearer ")
+			}
+		}
+		for _, k := range g.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), k) == 1 {
+				f(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		web.WriteError(w, http.StatusUnauthorized, errors.New("missing or invalid API key"))
+	}
+}
+
+// onEvent is the event handler registered with the BankID connection
+func (g *Gateway) onEvent(ev bankid.Event) {
+	switch ev.Status {
+	case bankid.StatusSent, bankid.StatusPending, bankid.StatusComplete, bankid.StatusFailed, bankid.StatusCancelled, bankid.StatusError:
+	default:
+		return // Not a status of the order
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o, ok := g.orders[ev.RequestID]
+	if !ok {
+		return
+	}
+	o.status, o.hintCode, o.errorCode, o.completion = ev.Status, ev.HintCode, ev.ErrorCode, ev.Completion
+	o.message, o.userMessage = "", ev.UserMessage
+	switch ev.Status {
+	case bankid.StatusSent:
+		if ev.Started != nil {
+			o.autoStartToken = ev.Started.AutoStartToken
+		}
+	case bankid.StatusError:
+		o.message = ev.Message
+	}
+	if web.IsFinalStatus(ev.Status) {
+		o.qrCode = nil
+		requestID := ev.RequestID
+		time.AfterFunc(g.Retention, func() { g.removeOrder(requestID) })
+	}
+}
+
+// onQRCode is the QR code call back function used for every order started by the gateway
+func (g *Gateway) onQRCode(qrCode []byte, requestID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o, ok := g.orders[requestID]; ok && !web.IsFinalStatus(o.status) {
+		o.qrCode = qrCode
+	}
+}
+
+func (g *Gateway) removeOrder(requestID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.orders, requestID)
+}
+
+func (g *Gateway) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
+func (g *Gateway) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var or orderRequest
+	if err := json.NewDecoder(r.Body).Decode(&or); err != nil {
+		web.WriteError(w, http.StatusBadRequest, errors.New("could not decode request body"))
+		return
+	}
+	if net.ParseIP(or.EndUserIP) == nil {
+		web.WriteError(w, http.StatusBadRequest, errors.New("endUserIp must be an IP address"))
+		return
+	}
+	switch or.Type {
+	case "auth":
+		if or.UserVisibleData != "" {
+			web.WriteError(w, http.StatusBadRequest, errors.New("userVisibleData is only allowed for sign orders"))
+			return
+		}
+	case "sign":
+		if or.UserVisibleData == "" {
+			web.WriteError(w, http.StatusBadRequest, errors.New("userVisibleData is required for sign orders"))
+			return
+		}
+	default:
+		web.WriteError(w, http.StatusBadRequest, errors.New("type must be auth or sign"))
+		return
+	}
+	requestID, err := web.NewID()
+	if err != nil {
+		web.WriteError(w, http.StatusInternalServerError, errors.New("could not create order ID"))
+		return
+	}
+	o := &order{typ: or.Type}
+	g.mu.Lock()
+	g.orders[requestID] = o
+	resp := o.response(requestID)
+	g.mu.Unlock()
+	opts := []bankid.RequestOption{
+		bankid.WithRequestID(requestID),
+		bankid.WithQRCallback(g.onQRCode),
+		bankid.WithRequirement(&bankid.Requirements{PersonalNumber: or.PersonalNumber}),
+	}
+	if or.UserNonVisibleData != "" {
+		opts = append(opts, bankid.WithNonVisibleData(or.UserNonVisibleData))
+	}
+	if or.Language != "" {
+		opts = append(opts, bankid.WithLanguage(or.Language))
+	}
+	if or.Type == "sign" {
+		g.conn.Sign(or.EndUserIP, or.UserVisibleData, opts...)
+	} else {
+		g.conn.Authenticate(or.EndUserIP, opts...)
+	}
+	w.Header().Set("Location", "/v1/orders/"+requestID)
+	web.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// handleOrder serves the routes of a single order
+func (g *Gateway) handleOrder(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v1/orders/")
+	requestID, qr := strings.TrimSuffix(rest, "/qr.png"), strings.HasSuffix(rest, "/qr.png")
+	g.mu.Lock()
+	o, ok := g.orders[requestID]
+	var resp orderResponse
+	var png []byte
+	if ok {
+		resp, png = o.response(requestID), o.qrCode
+	}
+	g.mu.Unlock()
+	if !ok {
+		web.WriteError(w, http.StatusNotFound, errors.New("no order with provided ID"))
+		return
+	}
+	switch {
+	case qr && r.Method == http.MethodGet:
+		if len(png) == 0 {
+			web.WriteError(w, http.StatusNotFound, errors.New("no QR code available"))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(png)
+	case !qr && r.Method == http.MethodGet:
+		w.Header().Set("Cache-Control", "no-store")
+		web.WriteJSON(w, http.StatusOK, resp)
+	case !qr && r.Method == http.MethodDelete:
+		if web.IsFinalStatus(bankid.Status(resp.Status)) {
+			web.WriteError(w, http.StatusConflict, errors.New("the order has already ended"))
+			return
+		}
+		g.conn.CancelRequest(requestID)
+		web.WriteJSON(w, http.StatusAccepted, resp)
+	default:
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// response returns the JSON description of the order. Must be called with the lock held
+func (o *order) response(requestID string) orderResponse {
+	resp := orderResponse{
+		ID:             requestID,
+		Type:           o.typ,
+		Status:         string(o.status),
+		HintCode:       string(o.hintCode),
+		ErrorCode:      o.errorCode,
+		Message:        o.message,
+		AutoStartToken: o.autoStartToken,
+	}
+	if resp.Status == "" {
+		resp.Status = "starting"
+	}
+	switch {
+	case o.errorCode != "":
+		resp.RFA, resp.UserMessage = bankid.RFAMessage(o.errorCode)
+	case o.hintCode != "" && o.status != bankid.StatusComplete:
+		resp.RFA, resp.UserMessage = bankid.RFAMessage(string(o.hintCode))
+	}
+	if resp.UserMessage != "" && o.userMessage != "" {
+		resp.UserMessage = o.userMessage
+	}
+	if cd := o.completion; cd != nil {
+		c := &completionResponse{OrderRef: cd.OrderRef, Signature: cd.Signature, OCSPResponse: cd.OCSPResponse, Risk: cd.Risk}
+		c.User.PersonalNumber, c.User.Name, c.User.GivenName, c.User.Surname = cd.User.PersonalNumber, cd.User.Name, cd.User.GivenName, cd.User.Surname
+		c.Device.IPAddress = cd.Device.IPAddress
+		c.Cert.NotBefore, c.Cert.NotAfter = cd.Cert.NotBefore, cd.Cert.NotAfter
+		resp.Completion = c
+	}
+	return resp
+}
@@ -0,0 +1,107 @@
+package bankid
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+// pingOrderRef is the order reference collected by Ping. No order has it, so the BankID server
+// answers with an error without any order being affected
+const pingOrderRef = "00000000-0000-0000-0000-000000000000"
+
+// Health is the result of Ping, telling how far the connection to the BankID server got
+type Health struct {
+	Healthy    bool          // The BankID server answered the request, with the RP certificate accepted
+	DNS        bool          // The host name of the service URL was resolved
+	TLS        bool          // The TLS handshake, verifying the server against the CA certificate, succeeded
+	StatusCode int           // HTTP status code of the answer, 0 if none was received
+	Latency    time.Duration // Time until the answer was received
+	CertExpiry time.Time     // When the RP client certificate expires
+	Err        error         // Why the connection is not healthy
+}
+
+// Ping checks that the BankID server can be reached, by collecting an order that does not exist. The
+// answer, expected to be the error invalidParameters, shows that DNS, the TLS handshake and the RP
+// certificate all work. No order is created. Meant for the readiness probes of services using the
+// connection, which is why the result also holds the expiry of the RP certificate
+func (sc *Connection) Ping(ctx context.Context) Health {
+	h := ping(ctx, sc.cfg, sc.httpClient)
+	h.CertExpiry = sc.CertificateExpiry()
+	if h.Err != nil {
+		sc.logprint(WARN, "ping failed:", h.Err.Error())
+	}
+	return h
+}
+
+// ping collects a non-existent order at the service URL of cfg with client
+func ping(ctx context.Context, cfg *config.Config, client *http.Client) (h Health) {
+	u, err := url.Parse(cfg.ServiceURL)
+	if err != nil {
+		h.Err = err
+		return h
+	}
+	// The trace functions may be called by the transport after Do has returned on a cancelled context
+	var mu sync.Mutex
+	dnsOK, tlsOK := net.ParseIP(u.Hostname()) != nil, false
+	defer func() {
+		mu.Lock()
+		h.DNS, h.TLS = dnsOK, tlsOK
+		mu.Unlock()
+	}()
+	trace := &httptrace.ClientTrace{
+		DNSDone: func(di httptrace.DNSDoneInfo) {
+			mu.Lock()
+			dnsOK = di.Err == nil
+			mu.Unlock()
+		},
+		GotConn: func(ci httptrace.GotConnInfo) {
+			// A reused connection has been resolved and handshaked before
+			if ci.Reused {
+				mu.Lock()
+				dnsOK, tlsOK = true, true
+				mu.Unlock()
+			}
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mu.Lock()
+			tlsOK = err == nil
+			mu.Unlock()
+		},
+	}
+	body := []byte(`{"orderRef":"` + pingOrderRef + `"}`)
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "POST", cfg.ServiceURL+"/collect", bytes.NewReader(body))
+	if err != nil {
+		h.Err = err
+		return h
+	}
+	setRequestHeaders(req, cfg)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		h.Err = err
+		return h
+	}
+	resp.Body.Close()
+	h.Latency = time.Since(start)
+	h.StatusCode = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusBadRequest:
+		h.Healthy = true
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		h.Err = errors.New("RP certificate not accepted by the BankID server")
+	default:
+		h.Err = fmt.Errorf("BankID server answered %d", resp.StatusCode)
+	}
+	return h
+}
@@ -0,0 +1,104 @@
+package bankid
+
+import (
+	"strings"
+
+	"github.com/hossner/bankid/messages"
+)
+
+// Status is the status of a request, as carried by Event
+type Status string
+
+// The statuses of a request. A request is first sent, then pending until it ends with one of the
+// other statuses. Status codes from the BankID service ("pending", "failed" and "complete") keep
+// their names
+const (
+	StatusSent      Status = "sent"      // Accepted by the BankID server, Message holds the autoStartToken
+	StatusPending   Status = "pending"   // Waiting for the user, HintCode tells why
+	StatusComplete  Status = "complete"  // Completed, Completion holds the result
+	StatusFailed    Status = "failed"    // Failed, HintCode tells why
+	StatusCancelled Status = "cancelled" // Cancelled with CancelRequest
+	StatusError     Status = "error"     // Rejected by this package or the BankID server, see Message and ErrorCode
+	StatusQRStale   Status = "qrStale"   // Still showing QR codes after staleQrAfter. Only passed to FOnEvent, if staleQrEvent is set
+	StatusExpired   Status = "expired"   // Started but never ended, as found by Reconcile. Only found in the audit log
+	StatusWarning   Status = "warning"   // A problem of the connection, not tied to a request, see Err. Only passed to FOnEvent
+)
+
+// final reports whether the status ends a request
+func (s Status) final() bool {
+	switch s {
+	case StatusComplete, StatusFailed, StatusCancelled, StatusError:
+		return true
+	}
+	return false
+}
+
+// HintCode is used by the BankID service to describe the state of a pending request, or the reason
+// for a failed one
+type HintCode string
+
+// The hint codes of the BankID service. For backwards compatibility they are passed as the status
+// argument to the FOnResponse call back function while the request is pending, and as the message
+// argument when the status is "failed"
+const (
+	HintOutstandingTransaction HintCode = "outstandingTransaction"
+	HintNoClient               HintCode = "noClient"
+	HintStarted                HintCode = "started"
+	HintUserSign               HintCode = "userSign"
+	HintUserMrtd               HintCode = "userMrtd"
+	HintUserCallConfirm        HintCode = "userCallConfirm"
+	HintExpiredTransaction     HintCode = "expiredTransaction"
+	HintCertificateErr         HintCode = "certificateErr"
+	HintUserCancel             HintCode = "userCancel"
+	HintCancelled              HintCode = "cancelled"
+	HintStartFailed            HintCode = "startFailed"
+	HintUserDeclinedCall       HintCode = "userDeclinedCall"
+	HintNotSupportedByUserApp  HintCode = "notSupportedByUserApp"
+)
+
+// pendingHints holds the hint codes that may be returned while a request is still pending
+var pendingHints = map[HintCode]bool{
+	HintOutstandingTransaction: true,
+	HintNoClient:               true,
+	HintStarted:                true,
+	HintUserSign:               true,
+	HintUserMrtd:               true,
+	HintUserCallConfirm:        true,
+}
+
+// RFAMessage returns the identifier of the recommended user message (e.g. "RFA9") and its English
+// text for a hint code or error code, so that raw codes never have to be shown to the user. Codes
+// not covered by the guidelines get the generic RFA22 ("Unknown error") for failures, and RFA21
+// ("Identification or signing in progress") for pending requests. See the messages package for the
+// Swedish texts and custom translations
+func RFAMessage(code string) (id, text string) {
+	var k messages.Key
+	switch {
+	case messages.Known(code):
+		k = messages.ForHint(code, true)
+	case code == "sent" || code == "pending":
+		k = messages.RFA21
+	default:
+		k = messages.ForError(code)
+	}
+	return rfaID(k), messages.Text(messages.Default, k, messages.English)
+}
+
+// rfaID returns the identifier of the message of k, without the A or B of variants, and empty for
+// messages not covered by the guidelines
+func rfaID(k messages.Key) string {
+	if !strings.HasPrefix(string(k), "RFA") {
+		return ""
+	}
+	return strings.TrimRight(string(k), "AB")
+}
+
+// Pending reports whether the hint code is one returned while a request is still pending
+func (h HintCode) Pending() bool {
+	return pendingHints[h]
+}
+
+// isKnownHint reports whether the hint code is one recognized by this version of the package
+func isKnownHint(h HintCode) bool {
+	return messages.Known(string(h))
+}
@@ -0,0 +1,373 @@
+// Package httphandler provides ready-made net/http handlers on top of a bankid.Connection, so that
+// web applications can start, follow and cancel BankID requests without hand-rolling the plumbing.
+//
+// The handler serves the following routes, relative to where it is mounted:
+//
+//	POST /auth         starts an authentication request, returns {"id": "..."}
+//	GET  /status/{id}  returns the current status; long-polls if ?last= equals the current status, and
+//	                   streams status changes and QR codes as Server-Sent Events if the client accepts
+//	                   text/event-stream
+//	GET  /qr/{id}.png  returns the latest animated QR code for the request, as a PNG image unless
+//	                   another QRRenderer is set on the connection
+//	POST /cancel/{id}  cancels an ongoing request
+//
+// The request IDs are 128 bit random values, so that they can not be guessed: anyone holding one may
+// follow and cancel the request. The status of a completed request does not carry the personal number
+// of the user; it is passed to OnComplete, on the server side.
+//
+// An ongoing request is cancelled automatically when no client has been following its status, through
+// GET /status/{id}, for longer than DisconnectGrace. This keeps orders abandoned by closed browser tabs
+// from accumulating.
+package httphandler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid"
+	"github.com/hossner/bankid/internal/web"
+)
+
+const (
+	defaultPollTimeout = 25 * time.Second
+	defaultRetention   = 5 * time.Minute
+	defaultGrace       = 30 * time.Second
+	sseKeepAlive       = 15 * time.Second
+)
+
+// Handler is an http.Handler serving the BankID routes
+type Handler struct {
+	// PollTimeout is the longest time a long-polling status request is held open
+	PollTimeout time.Duration
+	// Retention is how long the final status of a request is kept after it has ended
+	Retention time.Duration
+	// DisconnectGrace is how long a request may go without anyone following its status before it is
+	// cancelled. Zero disables automatic cancellation
+	DisconnectGrace time.Duration
+	// OnComplete, if set, is called with the personal number of the user when a request has completed.
+	// Should be set before the handler serves any request
+	OnComplete func(requestID, personalNumber string)
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse proxies in front of the
+	// handler. The end user IP is taken from X-Forwarded-For or X-Real-Ip only for requests from them;
+	// empty trusts no proxy
+	TrustedProxies []string
+
+	conn     *bankid.Connection
+	mux      *http.ServeMux
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session holds the last known state of a request
+type session struct {
+	status   string
+	message  string
+	qrCode   []byte
+	qrType   string        // MIME type of qrCode
+	qrSeq    int           // Incremented for every new QR code
+	changed  chan struct{} // Closed, and replaced, every time the status or QR code changes
+	watchers int           // Status requests currently being served
+	grace    *time.Timer   // Running while nobody follows the status
+}
+
+// notify wakes up everyone waiting for a change of the session. Must be called with the lock held
+func (s *session) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// statusResponse is the JSON body returned by the status and auth routes
+type statusResponse struct {
+	ID          string `json:"id"`
+	Status      string `json:"status,omitempty"`
+	Message     string `json:"message,omitempty"`
+	RFA         string `json:"rfa,omitempty"`
+	UserMessage string `json:"userMessage,omitempty"`
+}
+
+// newStatusResponse fills in the recommended user message for the status. The message of a completed
+// request, the personal number of the user, is left out
+func newStatusResponse(requestID, status, message string) statusResponse {
+	sr := statusResponse{ID: requestID, Status: status, Message: message}
+	if status == string(bankid.StatusComplete) {
+		sr.Message = ""
+	}
+	if status == "" || status == string(bankid.StatusComplete) {
+		return sr
+	}
+	code := status
+	if status == string(bankid.StatusFailed) {
+		code = message // The hint code
+	}
+	sr.RFA, sr.UserMessage = bankid.RFAMessage(code)
+	return sr
+}
+
+// authRequest is the optional JSON body of a POST to /auth
+type authRequest struct {
+	PersonalNumber string `json:"personalNumber,omitempty"`
+}
+
+// New returns a Handler with a new connection to the BankID server, configured from configFileName
+func New(configFileName string) (*Handler, error) {
+	h := &Handler{
+		PollTimeout:     defaultPollTimeout,
+		Retention:       defaultRetention,
+		DisconnectGrace: defaultGrace,
+		mux:             http.NewServeMux(),
+		sessions:        make(map[string]*session),
+	}
+	conn, err := bankid.New(configFileName, h.onResponse)
+	if err != nil {
+		return nil, err
+	}
+	h.conn = conn
+	h.mux.HandleFunc("/auth", h.handleAuth)
+	h.mux.HandleFunc("/status/", h.handleStatus)
+	h.mux.HandleFunc("/qr/", h.handleQR)
+	h.mux.HandleFunc("/cancel/", h.handleCancel)
+	return h, nil
+}
+
+// Connection returns the underlying BankID connection
+func (h *Handler) Connection() *bankid.Connection {
+	return h.conn
+}
+
+// Close closes the underlying BankID connection
+func (h *Handler) Close() {
+	h.conn.Close()
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// onResponse is the call back function registered with the BankID connection
+func (h *Handler) onResponse(requestID, status, message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[requestID]
+	if !ok {
+		return
+	}
+	s.status = status
+	s.message = message
+	s.notify()
+	if web.IsFinal(status, message) {
+		time.AfterFunc(h.Retention, func() { h.removeSession(requestID) })
+	}
+	if f := h.OnComplete; f != nil && status == string(bankid.StatusComplete) {
+		go f(requestID, message)
+	}
+}
+
+// onQRCode is the QR code call back function used for every request started by the handler
+func (h *Handler) onQRCode(qrCode []byte, requestID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.sessions[requestID]; ok {
+		s.qrCode, s.qrType = qrCode, h.conn.QRMimeType()
+		s.qrSeq++
+		s.notify()
+	}
+}
+
+func (h *Handler) removeSession(requestID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, requestID)
+}
+
+func (h *Handler) handleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var ar authRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
+			web.WriteError(w, http.StatusBadRequest, errors.New("could not decode request body"))
+			return
+		}
+	}
+	requestID, err := web.NewID()
+	if err != nil {
+		web.WriteError(w, http.StatusInternalServerError, errors.New("could not create request ID"))
+		return
+	}
+	h.mu.Lock()
+	h.sessions[requestID] = &session{changed: make(chan struct{})}
+	h.mu.Unlock()
+	reqs := bankid.Requirements{PersonalNumber: ar.PersonalNumber}
+	h.conn.SendRequest(web.ClientIP(r, h.TrustedProxies), requestID, "", &reqs, h.onQRCode)
+	web.WriteJSON(w, http.StatusAccepted, statusResponse{ID: requestID})
+}
+
+func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	requestID := strings.TrimPrefix(r.URL.Path, "/status/")
+	h.mu.Lock()
+	s, ok := h.sessions[requestID]
+	h.mu.Unlock()
+	if !ok {
+		web.WriteError(w, http.StatusNotFound, errors.New("no session with provided ID"))
+		return
+	}
+	h.watch(requestID, s)
+	defer h.unwatch(requestID, s)
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamStatus(w, r, requestID, s)
+		return
+	}
+	h.mu.Lock()
+	status, message, changed := s.status, s.message, s.changed
+	h.mu.Unlock()
+
+	// Long-poll as long as the caller already knows the current status
+	if last, ok := r.URL.Query()["last"]; ok && last[0] == status && !web.IsFinal(status, message) {
+		timer := time.NewTimer(h.PollTimeout)
+		defer timer.Stop()
+		for status == last[0] {
+			select {
+			case <-changed:
+				h.mu.Lock()
+				status, message, changed = s.status, s.message, s.changed
+				h.mu.Unlock()
+			case <-timer.C:
+				web.WriteJSON(w, http.StatusOK, newStatusResponse(requestID, status, message))
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	web.WriteJSON(w, http.StatusOK, newStatusResponse(requestID, status, message))
+}
+
+// streamStatus sends status changes as 'status' events, and new QR codes as base64 encoded 'qrcode'
+// events, until the request has ended or the client goes away
+func (h *Handler) streamStatus(w http.ResponseWriter, r *http.Request, requestID string, s *session) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		web.WriteError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	sentStatus, sentQR, first := "", 0, true
+	for {
+		h.mu.Lock()
+		status, message, qrCode, qrSeq, changed := s.status, s.message, s.qrCode, s.qrSeq, s.changed
+		h.mu.Unlock()
+		if first || status != sentStatus {
+			data, _ := json.Marshal(newStatusResponse(requestID, status, message))
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			sentStatus, first = status, false
+		}
+		if qrSeq != sentQR && !web.IsFinal(status, message) {
+			fmt.Fprintf(w, "event: qrcode\ndata: %s\n\n", base64.StdEncoding.EncodeToString(qrCode))
+			sentQR = qrSeq
+		}
+		flusher.Flush()
+		if web.IsFinal(status, message) {
+			return
+		}
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// watch registers a client following the status of the request
+func (h *Handler) watch(requestID string, s *session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.watchers++
+	if s.grace != nil {
+		s.grace.Stop()
+		s.grace = nil
+	}
+}
+
+// unwatch unregisters a client following the status of the request. When the last one is gone the
+// request is cancelled, unless someone starts following it again within the grace period
+func (h *Handler) unwatch(requestID string, s *session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.watchers--
+	if s.watchers > 0 || web.IsFinal(s.status, s.message) || h.DisconnectGrace <= 0 {
+		return
+	}
+	s.grace = time.AfterFunc(h.DisconnectGrace, func() {
+		h.mu.Lock()
+		abandoned := s.watchers == 0 && !web.IsFinal(s.status, s.message)
+		h.mu.Unlock()
+		if abandoned {
+			h.conn.CancelRequest(requestID)
+		}
+	})
+}
+
+func (h *Handler) handleQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	requestID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/qr/"), ".png")
+	h.mu.Lock()
+	var qrCode []byte
+	var qrType string
+	s, ok := h.sessions[requestID]
+	if ok {
+		qrCode, qrType = s.qrCode, s.qrType
+	}
+	h.mu.Unlock()
+	if !ok {
+		web.WriteError(w, http.StatusNotFound, errors.New("no session with provided ID"))
+		return
+	}
+	if len(qrCode) == 0 {
+		web.WriteError(w, http.StatusNotFound, errors.New("no QR code available yet"))
+		return
+	}
+	w.Header().Set("Content-Type", qrType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(qrCode)
+}
+
+func (h *Handler) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		web.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	requestID := strings.TrimPrefix(r.URL.Path, "/cancel/")
+	h.mu.Lock()
+	_, ok := h.sessions[requestID]
+	h.mu.Unlock()
+	if !ok {
+		web.WriteError(w, http.StatusNotFound, errors.New("no session with provided ID"))
+		return
+	}
+	h.conn.CancelRequest(requestID)
+	web.WriteJSON(w, http.StatusAccepted, statusResponse{ID: requestID})
+}
@@ -0,0 +1,71 @@
+package bankid
+
+import "time"
+
+// HTTPCall is a call to the BankID server, passed to the FOnHTTPRequest and FOnHTTPResponse call back
+// functions. The bodies have personal data and secrets masked as in the log, unless logPersonalData
+// is set
+type HTTPCall struct {
+	Method   string
+	URL      string
+	Endpoint string        // The call, e.g. "auth" or "collect"
+	Request  string        // Body of the request
+	Status   int           // HTTP status of the response; 0 before it, or if none was received
+	Response string        // Body of the response
+	Latency  time.Duration // Time until the response or the error
+	Err      error         // Error of a call without a response
+}
+
+// FOnHTTPRequest is a call back function receiving every call to the BankID server before it is made,
+// see SetHTTPRequestHandler
+type FOnHTTPRequest func(call HTTPCall)
+
+// FOnHTTPResponse is a call back function receiving every call to the BankID server with its response,
+// or its error, see SetHTTPResponseHandler
+type FOnHTTPResponse func(call HTTPCall)
+
+// SetHTTPRequestHandler sets a call back function receiving every call to the BankID server before it
+// is made, e.g. for custom logging, debugging or compliance capture. It is called in the go routine
+// making the call, which waits for it, so it may run concurrently for several requests and may call
+// the connection. Should be called before any request is sent
+func (sc *Connection) SetHTTPRequestHandler(f FOnHTTPRequest) {
+	sc.funcOnHTTPRequest = f
+}
+
+// SetHTTPResponseHandler sets a call back function receiving every call to the BankID server once it
+// is answered, or has failed, with the status, the body of the response and the latency. It is
+// called in the go routine making the call, which waits for it, so it may run concurrently for
+// several requests and may call the connection. Should be called before any request is sent
+func (sc *Connection) SetHTTPResponseHandler(f FOnHTTPResponse) {
+	sc.funcOnHTTPResponse = f
+}
+
+// WithHTTPRequestHandler sets the FOnHTTPRequest call back function, see SetHTTPRequestHandler
+func WithHTTPRequestHandler(f FOnHTTPRequest) Option {
+	return func(sc *Connection) error {
+		sc.SetHTTPRequestHandler(f)
+		return nil
+	}
+}
+
+// WithHTTPResponseHandler sets the FOnHTTPResponse call back function, see SetHTTPResponseHandler
+func WithHTTPResponseHandler(f FOnHTTPResponse) Option {
+	return func(sc *Connection) error {
+		sc.SetHTTPResponseHandler(f)
+		return nil
+	}
+}
+
+// onHTTPRequest passes call to the FOnHTTPRequest call back function, if set
+func (sc *Connection) onHTTPRequest(call HTTPCall) {
+	if f := sc.funcOnHTTPRequest; f != nil {
+		sc.callback("", "FOnHTTPRequest", func() { f(call) })
+	}
+}
+
+// onHTTPResponse passes call to the FOnHTTPResponse call back function, if set
+func (sc *Connection) onHTTPResponse(call HTTPCall) {
+	if f := sc.funcOnHTTPResponse; f != nil {
+		sc.callback("", "FOnHTTPResponse", func() { f(call) })
+	}
+}
@@ -0,0 +1,96 @@
+// Package web holds the helpers shared by the HTTP packages built on top of a bankid.Connection,
+// httphandler, wsbridge and gateway.
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hossner/bankid"
+)
+
+// NewID returns a random ID of 128 bits, hex encoded, for IDs that must not be guessed, e.g. those of
+// requests and sessions handed out to browsers
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// IsFinal reports whether status and message, as passed to the bankid.FOnResponse call back function,
+// end a request. The status of a pending request is its hint code, which may be one unknown to the
+// package, so it is told by its message, "pending"
+func IsFinal(status, message string) bool {
+	return status != "" && status != string(bankid.StatusSent) && message != string(bankid.StatusPending)
+}
+
+// IsFinalStatus reports whether the status of a bankid.Event ends a request
+func IsFinalStatus(s bankid.Status) bool {
+	switch s {
+	case bankid.StatusComplete, bankid.StatusFailed, bankid.StatusCancelled, bankid.StatusError:
+		return true
+	}
+	return false
+}
+
+// WriteJSON writes v as the JSON body of a response with the status code
+func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError writes err as the JSON body {"error": "..."} of a response with the status code
+func WriteError(w http.ResponseWriter, code int, err error) {
+	WriteJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// ClientIP returns the IP address of the end user of r. For requests from trustedProxies, IP
+// addresses or CIDR ranges, it is the last address of X-Forwarded-For that is not one of them, or
+// else X-Real-Ip. The headers of requests from other addresses are ignored, as anyone may set them
+func ClientIP(r *http.Request, trustedProxies []string) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(net.ParseIP(host), trustedProxies) {
+		return host
+	}
+	addrs := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			break
+		}
+		if !trusted(ip, trustedProxies) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// trusted reports whether ip is one of the proxies
+func trusted(ip net.IP, proxies []string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, p := range proxies {
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package bankid
+
+import "github.com/hossner/bankid/messages"
+
+// Localizer turns the events of requests into text to show the end user, in the language of the
+// request, see WithLanguage. The text is passed in Event.UserMessage. Implementations must be safe
+// for concurrent use
+type Localizer interface {
+	Localize(ev Event, lang string) string
+}
+
+// CatalogLocalizer is the default Localizer, showing the recommended user message of events, see
+// Event.MessageKey, with the texts of a catalog of the messages package
+type CatalogLocalizer struct {
+	Catalog messages.Catalog // The texts of the guidelines, messages.Default, if nil
+}
+
+// Localize implements the Localizer interface. Events without a recommended message, e.g. of
+// completed requests, get no text
+func (l CatalogLocalizer) Localize(ev Event, lang string) string {
+	k := ev.MessageKey()
+	if k == "" {
+		return ""
+	}
+	return messages.Text(l.Catalog, k, lang)
+}
+
+// SetLocalizer sets the Localizer of the user messages of events. Setting nil disables them. Should
+// be called before any request is sent
+func (sc *Connection) SetLocalizer(l Localizer) {
+	sc.localizer = l
+}
+
+// localize returns the user message of ev, in ev.Language
+func (sc *Connection) localize(ev Event) (text string) {
+	l := sc.localizer
+	if l == nil {
+		return ""
+	}
+	sc.callback(ev.RequestID, "Localizer", func() { text = l.Localize(ev, ev.Language) })
+	return text
+}
@@ -0,0 +1,322 @@
+package bankid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+// ErrLogUnwritable is the error of the warning Event sent when the log file can not be opened or
+// written, e.g. because the disk is full. Logging then goes on to stderr
+var ErrLogUnwritable = errors.New("log file unwritable, logging to stderr")
+
+// The log levels, in increasing severity. They map to the levels of log/slog, with FATAL and PANIC
+// above slog.LevelError
+const (
+	DEBUG = iota
+	INFO
+	WARN
+	ERROR
+	FATAL
+	PANIC
+)
+
+// slogLevels holds the slog level of each log level
+var slogLevels = [...]slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError, slog.LevelError + 4, slog.LevelError + 8}
+
+// levelOff is above every level, disabling the log
+const levelOff = slog.Level(1 << 10)
+
+// slogLevel returns the minimum slog level of the logLevel setting: 0 disables logging, 1 logs
+// everything from DEBUG, 2 from INFO, 3 from WARN, 4 from ERROR and 5 FATAL and PANIC only
+func slogLevel(logLevel int) slog.Level {
+	if logLevel < 1 || logLevel > len(slogLevels) {
+		return levelOff
+	}
+	return slogLevels[logLevel-1]
+}
+
+// logger is the log of a connection
+type logger struct {
+	personalData bool   // Log personal data in clear text
+	format       string // "text" or "json"
+	prefix       string
+	level        slog.LevelVar
+	out          *fallbackWriter
+	mu           sync.Mutex
+	l            *slog.Logger
+	file         io.Closer         // The log file opened from the config file, if any
+	orderRefs    map[string]string // Order references of ongoing requests, logged along with the request ID
+}
+
+// newLogger returns the logger configured in cfg. If the log file can not be opened, logging is done
+// to stderr and an error wrapping ErrLogUnwritable is returned along with the logger
+func newLogger(cfg *config.Config) (*logger, error) {
+	lg := &logger{personalData: cfg.LogPersonalData, format: cfg.LogFormat, prefix: cfg.LogPrefix, out: &fallbackWriter{w: os.Stderr}, orderRefs: make(map[string]string)}
+	lg.level.Set(slogLevel(cfg.LogLevel))
+	lg.l = slog.New(lg.handler())
+	if cfg.LogLevel < 1 || cfg.LogFileName == "" {
+		return lg, nil
+	}
+	f, err := openLogFile(cfg.LogFilePath(), int64(cfg.LogMaxSize)*1024*1024, time.Duration(cfg.LogMaxAge)*24*time.Hour, cfg.LogMaxBackups)
+	if err != nil {
+		lg.print(ERROR, "could not open log file", cfg.LogFilePath(), ":", err.Error())
+		return lg, fmt.Errorf("%w: %v", ErrLogUnwritable, err)
+	}
+	lg.file = f
+	lg.out.set(f)
+	lg.print(DEBUG, "log started")
+	return lg, nil
+}
+
+// handler returns the slog.Handler writing the log in the format of the config file
+func (lg *logger) handler() slog.Handler {
+	if lg.format == "json" {
+		return slog.NewJSONHandler(lg.out, &slog.HandlerOptions{Level: &lg.level, ReplaceAttr: jsonAttr})
+	}
+	return &prefixHandler{w: lg.out, prefix: lg.prefix, level: &lg.level}
+}
+
+// jsonAttr names the built-in attributes of JSON log lines timestamp, level and message, with the
+// names of the log levels of the package
+func jsonAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "timestamp"
+	case slog.MessageKey:
+		a.Key = "message"
+	case slog.LevelKey:
+		if l, ok := a.Value.Any().(slog.Level); ok {
+			a.Value = slog.StringValue(levelName(l))
+		}
+	}
+	return a
+}
+
+// setOrderRef sets the order reference logged with the request ID, until the request has ended
+func (lg *logger) setOrderRef(requestID, orderRef string) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if orderRef == "" {
+		delete(lg.orderRefs, requestID)
+		return
+	}
+	lg.orderRefs[requestID] = orderRef
+}
+
+// print writes a log line, unless filtered out by the log level. By the convention of the package
+// the first part is the request ID when the second starts with a colon; it is then passed to the
+// handler as the requestId attribute, along with the orderRef attribute of an ongoing order
+func (lg *logger) print(lvl int, a ...string) {
+	if lvl < 0 {
+		lvl = 0
+	}
+	if lvl >= len(slogLevels) {
+		lvl = len(slogLevels) - 1
+	}
+	lg.mu.Lock()
+	l := lg.l
+	var orderRef string
+	if len(a) > 1 {
+		orderRef = lg.orderRefs[a[0]]
+	}
+	lg.mu.Unlock()
+	ctx := context.Background()
+	if !l.Enabled(ctx, slogLevels[lvl]) {
+		return
+	}
+	if !lg.personalData {
+		r := make([]string, len(a))
+		for i, s := range a {
+			r[i] = redactLine(s)
+		}
+		a = r
+	}
+	var attrs []slog.Attr
+	if len(a) > 1 && strings.HasPrefix(a[1], ":") {
+		if a[0] != "" {
+			attrs = append(attrs, slog.String("requestId", a[0]))
+		}
+		if orderRef != "" {
+			attrs = append(attrs, slog.String("orderRef", orderRef))
+		}
+		a = append([]string{strings.TrimSpace(strings.TrimPrefix(a[1], ":"))}, a[2:]...)
+	}
+	l.LogAttrs(ctx, slogLevels[lvl], strings.TrimSpace(strings.Join(a, " ")), attrs...)
+}
+
+// body returns a request or response body for the wire debug log, with personal data redacted
+func (lg *logger) body(b []byte, p Pseudonymizer) string {
+	if lg.personalData {
+		return string(b)
+	}
+	return redactJSON(b, p)
+}
+
+// setOutput switches the sink of the log, closing the log file, if any
+func (lg *logger) setOutput(w io.Writer) {
+	lg.out.set(w)
+	lg.mu.Lock()
+	f := lg.file
+	lg.file = nil
+	lg.mu.Unlock()
+	if f != nil {
+		f.Close()
+	}
+}
+
+// close closes the log file, if any
+func (lg *logger) close() {
+	lg.setOutput(os.Stderr)
+}
+
+// logprint writes a log line to the log of the connection
+func (sc *Connection) logprint(lvl int, a ...string) {
+	sc.log.print(lvl, a...)
+}
+
+// SetLogLevel changes the log level of the connection at runtime, with the values of the logLevel
+// setting. Raising it from 0 logs to stderr, unless a log file was opened or an output set
+func (sc *Connection) SetLogLevel(logLevel int) {
+	sc.log.level.Set(slogLevel(logLevel))
+}
+
+// SetLogHandler sends the log of the connection to h, e.g. the handler of the slog.Logger of the
+// application, instead of the log file. The handler decides the level. Personal data is still
+// redacted, unless logPersonalData is set. Setting nil restores the log of the config file
+func (sc *Connection) SetLogHandler(h slog.Handler) {
+	if h == nil {
+		h = sc.log.handler()
+	}
+	sc.log.mu.Lock()
+	defer sc.log.mu.Unlock()
+	sc.log.l = slog.New(h)
+}
+
+// prefixHandler is a slog.Handler writing lines of the time, the log prefix with the level name, the
+// message and the attributes
+type prefixHandler struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	level  slog.Leveler
+	attrs  string // Formatted attributes added with WithAttrs
+	group  string
+}
+
+func (h *prefixHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *prefixHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	b.WriteString(strings.Replace(h.prefix, config.LogLevelPlaceholder, levelName(r.Level), 1))
+	b.WriteString(" ")
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteString("\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *prefixHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		writeAttr(&b, h.group, a)
+	}
+	return &prefixHandler{w: h.w, prefix: h.prefix, level: h.level, attrs: b.String(), group: h.group}
+}
+
+func (h *prefixHandler) WithGroup(name string) slog.Handler {
+	return &prefixHandler{w: h.w, prefix: h.prefix, level: h.level, attrs: h.attrs, group: h.group + name + "."}
+}
+
+func writeAttr(b *strings.Builder, group string, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	fmt.Fprintf(b, " %s%s=%q", group, a.Key, a.Value.String())
+}
+
+// levelName returns the name of the log level of the package closest to l
+func levelName(l slog.Level) string {
+	names := [...]string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL", "PANIC"}
+	for i := len(slogLevels) - 1; i > 0; i-- {
+		if l >= slogLevels[i] {
+			return names[i]
+		}
+	}
+	return names[0]
+}
+
+// fallbackWriter writes to w, switching to stderr for good once a write fails, so that log records
+// are not silently dropped. onFail is then called with an error wrapping ErrLogUnwritable
+type fallbackWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	onFail func(err error)
+}
+
+func (f *fallbackWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.w.Write(p)
+	if err == nil || f.w == os.Stderr {
+		return n, err
+	}
+	f.w = os.Stderr
+	if f.onFail != nil {
+		go f.onFail(fmt.Errorf("%w: %v", ErrLogUnwritable, err))
+	}
+	return os.Stderr.Write(p)
+}
+
+func (f *fallbackWriter) set(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.w = w
+}
+
+// SetLogOutput switches the log of the connection to w at runtime, e.g. a new file after the old one
+// has become unwritable, or an io.Writer rotating the log such as lumberjack. The log file opened
+// from the config file, if any, is closed. Setting nil logs to stderr
+func (sc *Connection) SetLogOutput(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+	sc.log.setOutput(w)
+}
+
+// warn sends a warning Event, not tied to a request, to the FOnEvent call back function. Warnings
+// raised before one is set are held back until then
+func (sc *Connection) warn(err error) {
+	ev := Event{Status: StatusWarning, Message: err.Error(), Err: err, Time: time.Now()}
+	sc.warnMu.Lock()
+	f := sc.funcOnEvent
+	if f == nil {
+		sc.warnings = append(sc.warnings, ev)
+	}
+	sc.warnMu.Unlock()
+	if f != nil {
+		sc.callback("", "FOnEvent", func() { f(ev) })
+	}
+}
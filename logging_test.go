@@ -0,0 +1,134 @@
+package bankid
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hossner/bankid/config"
+)
+
+func TestSlogLevel(t *testing.T) {
+	tests := []struct {
+		logLevel int
+		want     slog.Level
+	}{
+		{-1, levelOff},
+		{0, levelOff},
+		{1, slog.LevelDebug},
+		{2, slog.LevelInfo},
+		{3, slog.LevelWarn},
+		{4, slog.LevelError},
+		{5, slog.LevelError + 4},
+		{6, slog.LevelError + 8},
+		{7, levelOff},
+	}
+	for _, tt := range tests {
+		if got := slogLevel(tt.logLevel); got != tt.want {
+			t.Errorf("slogLevel(%d) = %v, want %v", tt.logLevel, got, tt.want)
+		}
+	}
+}
+
+// newTestLogger returns a logger of the format writing to buf, at the logLevel setting, with the level
+// names in the lines
+func newTestLogger(format string, logLevel int, buf *bytes.Buffer) *logger {
+	lg := &logger{format: format, prefix: "{level}", out: &fallbackWriter{w: buf}, orderRefs: make(map[string]string)}
+	lg.level.Set(slogLevel(logLevel))
+	lg.l = slog.New(lg.handler())
+	return lg
+}
+
+// TestLoggerPrintLevels checks every level against every logLevel setting: setting n logs the levels
+// from n-1 up, DEBUG being 0, so that the lowest level of a setting is logged and the one below it is
+// not. The comparison used to be off by one, dropping the lowest level of every setting
+func TestLoggerPrintLevels(t *testing.T) {
+	names := []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL", "PANIC"}
+	for _, format := range []string{"text", "json"} {
+		for setting := 0; setting <= 5; setting++ {
+			for lvl := DEBUG; lvl <= PANIC; lvl++ {
+				var buf bytes.Buffer
+				newTestLogger(format, setting, &buf).print(lvl, "req", ": message")
+				want := setting > 0 && lvl >= setting-1
+				if got := buf.Len() > 0; got != want {
+					t.Errorf("%s, logLevel %d: %s logged = %v, want %v", format, setting, names[lvl], got, want)
+					continue
+				}
+				if want && !strings.Contains(buf.String(), names[lvl]) {
+					t.Errorf("%s, logLevel %d: %s logged as %q", format, setting, names[lvl], buf.String())
+				}
+			}
+		}
+	}
+}
+
+// TestLoggerPrintOutOfRange checks that levels out of range are clamped instead of indexing past the
+// levels
+func TestLoggerPrintOutOfRange(t *testing.T) {
+	tests := []struct {
+		lvl      int
+		logLevel int
+		want     string
+	}{
+		{-1, 1, "DEBUG"},
+		{-1, 2, ""},
+		{PANIC + 1, 5, "PANIC"},
+		{100, 5, "PANIC"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		newTestLogger("text", tt.logLevel, &buf).print(tt.lvl, "message")
+		switch {
+		case tt.want == "" && buf.Len() > 0:
+			t.Errorf("level %d, logLevel %d: logged %q", tt.lvl, tt.logLevel, buf.String())
+		case tt.want != "" && !strings.Contains(buf.String(), tt.want):
+			t.Errorf("level %d, logLevel %d: logged %q, want %s", tt.lvl, tt.logLevel, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	sc := &Connection{log: newTestLogger("text", 3, &buf)}
+	sc.logprint(INFO, "hidden")
+	sc.SetLogLevel(2)
+	sc.logprint(INFO, "shown")
+	sc.SetLogLevel(0)
+	sc.logprint(PANIC, "off")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "shown") || strings.Contains(got, "off") {
+		t.Errorf("logged %q, want the shown line only", got)
+	}
+}
+
+// TestNewClosesLogFile checks that the log file is closed when a connection cannot be created
+func TestNewClosesLogFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open files are listed in /proc")
+	}
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "bankid.log")
+	cfg, err := config.FromReader(strings.NewReader(`{"environment": "simulation", "pollDelay": 2000, "logLevel": 2, "logFile": "`+logFile+`",
+		"recording": {"mode": "replay", "file": "`+filepath.Join(dir, "missing.json")+`"}}`), "config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFromConfig(cfg, func(string, string, string) {}); err == nil {
+		t.Fatal("connection created without the golden file")
+	}
+	if _, err := os.Stat(logFile); err != nil {
+		t.Fatalf("no log file: %v", err)
+	}
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, fd := range fds {
+		if target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); target == logFile {
+			t.Fatal("log file left open")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package bankid
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the time stamp appended to the name of rotated log files
+const backupTimeFormat = "20060102T150405.000"
+
+// rotatingFile is a log file that is rotated once it exceeds maxSize bytes, or is older than maxAge,
+// keeping at most maxBackups rotated files. Zero values disable each limit. The age of a file appended
+// to counts from when it was opened by the process, not from when it was created
+type rotatingFile struct {
+	mu         sync.Mutex
+	name       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	f          *os.File
+	size       int64
+	opened     time.Time // When f was opened, the start of its age
+}
+
+// openLogFile opens the log file for appending, rotating it according to the limits
+func openLogFile(name string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{name: name, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.size > 0 && ((rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize) || (rf.maxAge > 0 && time.Since(rf.opened) > rf.maxAge)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file, with a time stamp, opens a new one and removes the oldest backups
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	if err := os.Rename(rf.name, rf.name+"."+time.Now().Format(backupTimeFormat)); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if rf.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(rf.name + ".*")
+	if err != nil {
+		return nil
+	}
+	sort.Strings(backups) // The time stamps sort chronologically
+	for len(backups) > rf.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
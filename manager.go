@@ -0,0 +1,301 @@
+package bankid
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+// ErrTenantNotFound is the error returned by Manager for a tenant ID without a connection
+var ErrTenantNotFound = errors.New("no connection for the tenant")
+
+// FOnTenantResponse is the call back function of a Manager, receiving the same arguments as
+// FOnResponse, with the ID of the tenant the request was sent for
+type FOnTenantResponse func(tenant, requestID, status, message string)
+
+// Manager holds one Connection per tenant, e.g. for a platform serving several organizations, each
+// with a BankID agreement, RP certificate and config file of its own, and routes the requests to the
+// connection of the tenant. The tenants calling the same host share one transport, each presenting its
+// own RP certificate. A Manager is safe for concurrent use
+type Manager struct {
+	mu         sync.RWMutex
+	conns      map[string]*Connection
+	onResponse FOnTenantResponse
+	transports *transportPool
+}
+
+// NewManager returns a Manager without tenants. responseCallBack receives the status updates of the
+// requests of all tenants
+func NewManager(responseCallBack FOnTenantResponse) (*Manager, error) {
+	if responseCallBack == nil {
+		return nil, errors.New("no call back function provided")
+	}
+	return &Manager{conns: make(map[string]*Connection), onResponse: responseCallBack, transports: newTransportPool()}, nil
+}
+
+// AddTenant creates a connection for tenant from the config file cfgFileName, see New
+func (m *Manager) AddTenant(tenant, cfgFileName string, opts ...Option) error {
+	cfg, err := config.New(cfgFileName)
+	if err != nil {
+		return fmt.Errorf("could not create configuration of tenant %s: %v", tenant, err)
+	}
+	return m.AddTenantConfig(tenant, cfg, opts...)
+}
+
+// AddTenantConfig creates a connection for tenant from cfg, see NewFromConfig
+func (m *Manager) AddTenantConfig(tenant string, cfg *config.Config, opts ...Option) error {
+	if tenant == "" {
+		return errors.New("no tenant ID provided")
+	}
+	m.mu.RLock()
+	_, exists := m.conns[tenant]
+	m.mu.RUnlock()
+	if exists {
+		return fmt.Errorf("tenant %s already has a connection", tenant)
+	}
+	conn, err := NewFromConfig(cfg, func(requestID, status, message string) {
+		m.onResponse(tenant, requestID, status, message)
+	}, append(opts[:len(opts):len(opts)], withTransportPool(m.transports))...)
+	if err != nil {
+		return fmt.Errorf("could not create connection of tenant %s: %v", tenant, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.conns[tenant]; exists {
+		conn.Close()
+		return fmt.Errorf("tenant %s already has a connection", tenant)
+	}
+	m.conns[tenant] = conn
+	return nil
+}
+
+// RemoveTenant closes the connection of tenant and removes it from the Manager. Ongoing requests of
+// the tenant are not cancelled
+func (m *Manager) RemoveTenant(tenant string) error {
+	m.mu.Lock()
+	conn, ok := m.conns[tenant]
+	delete(m.conns, tenant)
+	m.mu.Unlock()
+	if !ok {
+		return ErrTenantNotFound
+	}
+	conn.Close()
+	return nil
+}
+
+// Connection returns the connection of tenant, e.g. to set call back functions or to check its status
+func (m *Manager) Connection(tenant string) (*Connection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conn, ok := m.conns[tenant]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	return conn, nil
+}
+
+// Tenants returns the IDs of the tenants, sorted
+func (m *Manager) Tenants() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tenants := make([]string, 0, len(m.conns))
+	for t := range m.conns {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// SendRequest sends an auth/sign request with the connection of tenant, see Connection.SendRequest
+func (m *Manager) SendRequest(tenant, endUserIP, requestID, textToBeSigned string, requirements *Requirements, onQRCodeFunc FOnNewQRCode) (string, error) {
+	conn, err := m.Connection(tenant)
+	if err != nil {
+		return "", err
+	}
+	return conn.SendRequest(endUserIP, requestID, textToBeSigned, requirements, onQRCodeFunc), nil
+}
+
+// Authenticate sends an authentication request with the connection of tenant, see Connection.Authenticate
+func (m *Manager) Authenticate(tenant, endUserIP string, opts ...RequestOption) (string, error) {
+	conn, err := m.Connection(tenant)
+	if err != nil {
+		return "", err
+	}
+	return conn.Authenticate(endUserIP, opts...), nil
+}
+
+// Sign sends a sign request with the connection of tenant, see Connection.Sign
+func (m *Manager) Sign(tenant, endUserIP, userVisibleData string, opts ...RequestOption) (string, error) {
+	conn, err := m.Connection(tenant)
+	if err != nil {
+		return "", err
+	}
+	return conn.Sign(endUserIP, userVisibleData, opts...), nil
+}
+
+// CancelRequest cancels an ongoing request of tenant, see Connection.CancelRequest
+func (m *Manager) CancelRequest(tenant, requestID string) error {
+	conn, err := m.Connection(tenant)
+	if err != nil {
+		return err
+	}
+	conn.CancelRequest(requestID)
+	return nil
+}
+
+// Close closes the connections of all tenants and removes them from the Manager
+func (m *Manager) Close() {
+	m.mu.Lock()
+	conns := m.conns
+	m.conns = make(map[string]*Connection)
+	m.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// withTransportPool shares the transport of the connection with the other connections of p calling the
+// same endpoint
+func withTransportPool(p *transportPool) Option {
+	return func(sc *Connection) error {
+		sc.pool = p
+		return nil
+	}
+}
+
+// transportPool holds the transports shared by the connections of the tenants of a Manager calling the
+// same host, with the same CA certificate, TLS and proxy settings
+type transportPool struct {
+	mu      sync.Mutex
+	entries map[string]*sharedTransport
+}
+
+// sharedTransport is a transport of a transportPool. A TLS connection is tied to the client certificate
+// presented in its handshake, so every certificate has connections of its own, established with the
+// TLS configuration, proxy and idle timeout shared by all
+type sharedTransport struct {
+	tlsCfg *tls.Config // Without a client certificate
+	proxy  func(*http.Request) (*url.URL, error)
+	idle   time.Duration
+	refs   int // The connections using the transport, guarded by the lock of the pool
+	mu     sync.Mutex
+	byCert map[*clientCert]*http.Transport
+}
+
+// certTransport is the transport of a connection sharing a sharedTransport, presenting the certificate
+// of the connection
+type certTransport struct {
+	shared *sharedTransport
+	cc     *clientCert
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *certTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.shared.conns(t.cc).RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections established with the certificate of the connection
+func (t *certTransport) CloseIdleConnections() {
+	t.shared.conns(t.cc).CloseIdleConnections()
+}
+
+func newTransportPool() *transportPool {
+	return &transportPool{entries: make(map[string]*sharedTransport)}
+}
+
+// acquire returns the transport of the calls made with cfg and the certificate cc, sharing the one of
+// the other connections calling the same host with the same settings, and its key. The connections
+// established with cc are counted in st
+func (p *transportPool) acquire(cfg *config.Config, st *stats, cc *clientCert) (*certTransport, string, error) {
+	key, err := transportKey(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[key]
+	if !ok {
+		tlsCfg, err := getTLSConfig(cfg, &clientCert{})
+		if err != nil {
+			return nil, "", err
+		}
+		e = &sharedTransport{tlsCfg: tlsCfg, proxy: proxyFunc(cfg), idle: time.Duration(cfg.IdleConnTimeout) * time.Millisecond, byCert: make(map[*clientCert]*http.Transport)}
+		p.entries[key] = e
+	}
+	e.refs++
+	e.add(cc, st)
+	return &certTransport{shared: e, cc: cc}, key, nil
+}
+
+// release drops the connection of the certificate cc using the transport of key, and the transport when
+// no longer used
+func (p *transportPool) release(key string, cc *clientCert) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	e.remove(cc)
+	if e.refs--; e.refs == 0 {
+		delete(p.entries, key)
+	}
+}
+
+// add sets up the connections established with the certificate cc
+func (t *sharedTransport) add(cc *clientCert, st *stats) {
+	tlsCfg := t.tlsCfg.Clone()
+	tlsCfg.GetClientCertificate = cc.get
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byCert[cc] = &http.Transport{Proxy: t.proxy, TLSClientConfig: tlsCfg, DialContext: countingDialer(st), IdleConnTimeout: t.idle, MaxConnsPerHost: maxConnsPerHost, MaxIdleConnsPerHost: maxConnsPerHost}
+}
+
+// remove closes the idle connections established with the certificate cc, and forgets them
+func (t *sharedTransport) remove(cc *clientCert) {
+	t.mu.Lock()
+	tr, ok := t.byCert[cc]
+	delete(t.byCert, cc)
+	t.mu.Unlock()
+	if ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+// conns returns the connections established with the certificate cc, or ones of their own if released
+func (t *sharedTransport) conns(cc *clientCert) *http.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr, ok := t.byCert[cc]; ok {
+		return tr
+	}
+	tlsCfg := t.tlsCfg.Clone()
+	tlsCfg.GetClientCertificate = cc.get
+	return &http.Transport{Proxy: t.proxy, TLSClientConfig: tlsCfg, IdleConnTimeout: t.idle, MaxConnsPerHost: maxConnsPerHost, MaxIdleConnsPerHost: maxConnsPerHost}
+}
+
+// transportKey returns the key of the transport of the calls made with cfg, a digest of the host, the
+// CA certificate and the TLS and proxy settings
+func transportKey(cfg *config.Config) (string, error) {
+	u, err := url.Parse(cfg.ServiceURL)
+	if err != nil {
+		return "", err
+	}
+	ca, err := serverRootCA(cfg)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%v\n%s\n%s\n%s\n%d\n", u.Host, cfg.TLSMinVersion(), cfg.TLSCipherSuites(), cfg.Proxy.URL, cfg.Proxy.Username, cfg.Proxy.Password, cfg.IdleConnTimeout)
+	h.Write(ca)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,90 @@
+package bankid
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hossner/bankid/config"
+)
+
+// TestManagerSharesTransports checks that the tenants calling the same host share one transport, each
+// presenting its own certificate, and that the tenants calling another host get one of their own
+func TestManagerSharesTransports(t *testing.T) {
+	m, err := NewManager(func(tenant, requestID, status, message string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	add := func(tenant, serviceURL string, ca *testCA) *Connection {
+		t.Helper()
+		cfg, err := config.FromReader(strings.NewReader(`{"serviceUrl": "`+serviceURL+`", "pollDelay": 2000, "enableLogging": false}`), "config.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AddTenantConfig(tenant, cfg, WithSigner(ca.key, ca.cert)); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := m.Connection(tenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	shared := func(conn *Connection) *sharedTransport {
+		return conn.httpClient.Transport.(*certTransport).shared
+	}
+	// presented returns the certificate presented in the TLS handshakes of conn
+	presented := func(conn *Connection) []byte {
+		cert, err := shared(conn).conns(&conn.clientCert).TLSClientConfig.GetClientCertificate(nil)
+		if err != nil || cert == nil {
+			t.Fatalf("no certificate presented: %v", err)
+		}
+		return cert.Certificate[0]
+	}
+	caA, caB, caC := newTestCA(t, "RP a"), newTestCA(t, "RP b"), newTestCA(t, "RP c")
+	a := add("a", "https://appapi2.test.bankid.com/rp/v6.0", caA)
+	b := add("b", "https://appapi2.test.bankid.com/rp/v6.0", caB)
+	c := add("c", "https://appapi2.test.bankid.com/rp/v5.1", caC)
+	d := add("d", "https://appapi2.test.bankid.com:8443/rp/v6.0", caA)
+
+	if shared(a) != shared(b) || shared(a) != shared(c) {
+		t.Error("tenants calling the same host do not share the transport")
+	}
+	if shared(a) == shared(d) {
+		t.Error("tenants calling different endpoints share the transport")
+	}
+	if n := len(m.transports.entries); n != 2 {
+		t.Errorf("%d transports, want 2", n)
+	}
+	if string(presented(a)) != string(caA.cert.Raw) || string(presented(b)) != string(caB.cert.Raw) {
+		t.Error("the tenants do not present their own certificates")
+	}
+
+	// Replacing the certificate of a tenant affects that tenant only
+	renewed, err := CertificateFromSigner(caC.key, caC.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetCertificate(renewed)
+	if string(presented(a)) != string(caC.cert.Raw) {
+		t.Error("the certificate was not replaced")
+	}
+	if string(presented(b)) != string(caB.cert.Raw) {
+		t.Error("the certificate was replaced for another tenant")
+	}
+
+	for _, tenant := range []string{"a", "b"} {
+		if err := m.RemoveTenant(tenant); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.transports.entries[c.poolKey]; !ok {
+			t.Fatal("transport released while still used")
+		}
+	}
+	if err := m.RemoveTenant("c"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.transports.entries); n != 1 {
+		t.Errorf("%d transports after removing the tenants sharing one, want 1", n)
+	}
+}
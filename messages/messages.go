@@ -0,0 +1,156 @@
+// Package messages holds the user messages recommended by the BankID relying party guidelines
+// (RFA1 to RFA23), in Swedish and English, and maps the hint codes and error codes of the BankID
+// service to them. Custom or additional translations are added by implementing Catalog.
+package messages
+
+// Key identifies a recommended user message, e.g. RFA9
+type Key string
+
+// The recommended user messages. The A and B variants differ in whether the BankID app is expected
+// on the same device (A) or on another device, e.g. with a QR code (B)
+const (
+	RFA1   Key = "RFA1"   // Start your BankID app
+	RFA2   Key = "RFA2"   // The BankID app is not installed
+	RFA3   Key = "RFA3"   // Action cancelled, try again
+	RFA4   Key = "RFA4"   // Already started for the personal number
+	RFA5   Key = "RFA5"   // Internal error, try again
+	RFA6   Key = "RFA6"   // Action cancelled
+	RFA8   Key = "RFA8"   // The BankID app is not responding
+	RFA9   Key = "RFA9"   // Enter your security code
+	RFA13  Key = "RFA13"  // Trying to start your BankID app
+	RFA14A Key = "RFA14A" // Searching for BankID, on this computer
+	RFA14B Key = "RFA14B" // Searching for BankID, on this device
+	RFA15A Key = "RFA15A" // Searching for BankID, with a card reader, on this computer
+	RFA15B Key = "RFA15B" // Searching for BankID, with a card reader, on this device
+	RFA16  Key = "RFA16"  // The BankID is blocked or too old
+	RFA17A Key = "RFA17A" // The BankID app could not be found
+	RFA17B Key = "RFA17B" // Failed to scan the QR code
+	RFA18  Key = "RFA18"  // Start the BankID app, as a link or button
+	RFA19  Key = "RFA19"  // This computer or a Mobile BankID
+	RFA20  Key = "RFA20"  // This device or another device
+	RFA21  Key = "RFA21"  // Identification or signing in progress
+	RFA22  Key = "RFA22"  // Unknown error, try again
+	RFA23  Key = "RFA23"  // Process your machine-readable travel document
+
+	CallConfirm Key = "callConfirm" // Confirm the call, for phone orders. Not covered by the guidelines
+)
+
+// The languages of the texts shipped with the package
+const (
+	Swedish = "sv"
+	English = "en"
+)
+
+// Catalog holds the texts of the messages, by language. Languages are BCP 47 tags, e.g. "sv" or
+// "en-GB"; implementations decide how to match them. Implementations must be safe for concurrent use
+type Catalog interface {
+	// Text returns the text of key in lang, and false if the catalog has none
+	Text(key Key, lang string) (string, bool)
+}
+
+// Default is the catalog of the texts of the guidelines, in Swedish and English
+var Default Catalog = Map(texts)
+
+// Map is a Catalog of texts by language and key, e.g. for custom translations. Languages not found
+// are looked up by their primary subtag, so that "sv-SE" finds the texts of "sv"
+type Map map[string]map[Key]string
+
+// Text implements the Catalog interface
+func (m Map) Text(key Key, lang string) (string, bool) {
+	if t, ok := m[lang][key]; ok {
+		return t, true
+	}
+	for i := 0; i < len(lang); i++ {
+		if lang[i] == '-' || lang[i] == '_' {
+			t, ok := m[lang[:i]][key]
+			return t, ok
+		}
+	}
+	return "", false
+}
+
+// Chain returns a Catalog looking up texts in the catalogs in order, e.g. custom translations first
+// and Default last
+func Chain(catalogs ...Catalog) Catalog {
+	return chain(catalogs)
+}
+
+type chain []Catalog
+
+func (c chain) Text(key Key, lang string) (string, bool) {
+	for _, cat := range c {
+		if t, ok := cat.Text(key, lang); ok {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// Text returns the text of key in lang from c, falling back to English, and to the texts of Default.
+// Returns the key itself if no text is found
+func Text(c Catalog, key Key, lang string) string {
+	if c == nil {
+		c = Default
+	}
+	for _, l := range []string{lang, English} {
+		if t, ok := c.Text(key, l); ok {
+			return t
+		}
+		if t, ok := Default.Text(key, l); ok {
+			return t
+		}
+	}
+	return string(key)
+}
+
+// byHint maps the hint codes of the BankID service to the message to show the user
+var byHint = map[string]Key{
+	"outstandingTransaction": RFA1,
+	"noClient":               RFA1,
+	"started":                RFA14B,
+	"userSign":               RFA9,
+	"userMrtd":               RFA23,
+	"userCallConfirm":        CallConfirm,
+	"expiredTransaction":     RFA8,
+	"certificateErr":         RFA16,
+	"userCancel":             RFA6,
+	"cancelled":              RFA3,
+	"startFailed":            RFA17A,
+	"userDeclinedCall":       RFA6,
+	"notSupportedByUserApp":  RFA3,
+}
+
+// byErrorCode maps the error codes of the BankID service to the message to show the user
+var byErrorCode = map[string]Key{
+	"alreadyInProgress": RFA4,
+	"requestTimeout":    RFA5,
+	"maintenance":       RFA5,
+	"internalError":     RFA5,
+}
+
+// ForHint returns the message for a hint code of a pending order, or of a failed one if pending is
+// false. Hint codes not covered by the guidelines get RFA21 while pending, and RFA22 when failed
+func ForHint(hint string, pending bool) Key {
+	if k, ok := byHint[hint]; ok {
+		return k
+	}
+	if pending {
+		return RFA21
+	}
+	return RFA22
+}
+
+// ForError returns the message for an error code of the BankID service. Error codes not covered by
+// the guidelines, e.g. invalidParameters which is a fault of the relying party, get RFA22
+func ForError(code string) Key {
+	if k, ok := byErrorCode[code]; ok {
+		return k
+	}
+	return RFA22
+}
+
+// Known reports whether the hint code is covered by the guidelines
+func Known(hint string) bool {
+	_, ok := byHint[hint]
+	return ok
+}
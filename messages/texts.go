@@ -0,0 +1,57 @@
+package messages
+
+// texts holds the texts of the guidelines
+var texts = map[string]map[Key]string{
+	Swedish: {
+		RFA1:   "Starta BankID-appen.",
+		RFA2:   "Du har inte BankID-appen installerad. Kontakta din bank.",
+		RFA3:   "Åtgärden avbruten. Försök igen.",
+		RFA4:   "En identifiering eller underskrift för det här personnumret är redan påbörjad. Försök igen.",
+		RFA5:   "Internt tekniskt fel. Försök igen.",
+		RFA6:   "Åtgärden avbruten.",
+		RFA8:   "BankID-appen svarar inte. Kontrollera att den är startad och att du har internetanslutning. Om du inte har något giltigt BankID kan du hämta ett hos din bank. Försök sedan igen.",
+		RFA9:   "Skriv in din säkerhetskod i BankID-appen och välj Identifiera eller Skriv under.",
+		RFA13:  "Försöker starta BankID-appen.",
+		RFA14A: "Söker efter BankID, det kan ta en liten stund... Om det har gått några sekunder och inget BankID har hittats har du sannolikt inget BankID som går att använda för den aktuella identifieringen/underskriften i den här datorn. Om du har ett BankID-kort, sätt in det i kortläsaren. Om du inte har något BankID kan du hämta ett hos din bank. Om du har ett BankID på en annan enhet kan du starta din BankID-app där.",
+		RFA14B: "Söker efter BankID, det kan ta en liten stund... Om det har gått några sekunder och inget BankID har hittats har du sannolikt inget BankID som går att använda för den aktuella identifieringen/underskriften i den här enheten. Om du inte har något BankID kan du hämta ett hos din bank. Om du har ett BankID på en annan enhet kan du starta din BankID-app där.",
+		RFA15A: "Söker efter BankID:n, det kan ta en liten stund... Om det har gått några sekunder och inget BankID har hittats har du sannolikt inget BankID som går att använda för den aktuella identifieringen/underskriften i den här datorn. Om du har ett BankID-kort, sätt in det i kortläsaren. Om du inte har något BankID kan du hämta ett hos din bank.",
+		RFA15B: "Söker efter BankID, det kan ta en liten stund... Om det har gått några sekunder och inget BankID har hittats har du sannolikt inget BankID som går att använda för den aktuella identifieringen/underskriften i den här enheten. Om du inte har något BankID kan du hämta ett hos din bank.",
+		RFA16:  "Det BankID du försöker använda är för gammalt eller spärrat. Använd ett annat BankID eller hämta ett nytt hos din bank.",
+		RFA17A: "BankID-appen verkar inte finnas i din dator eller telefon. Installera den och hämta ett BankID hos din bank. Installera appen från din appbutik eller https://install.bankid.com.",
+		RFA17B: "Misslyckades att läsa av QR-koden. Starta BankID-appen och läs av QR-koden. Kontrollera att BankID-appen är uppdaterad. Om du inte har BankID-appen måste du installera den och hämta ett BankID hos din bank. Installera appen från din appbutik eller https://install.bankid.com.",
+		RFA18:  "Starta BankID-appen",
+		RFA19:  "Vill du identifiera dig eller skriva under med BankID på den här datorn eller med ett Mobilt BankID?",
+		RFA20:  "Vill du identifiera dig eller skriva under med ett BankID på den här enheten eller med ett BankID på en annan enhet?",
+		RFA21:  "Identifiering eller underskrift pågår.",
+		RFA22:  "Okänt fel. Försök igen.",
+		RFA23:  "Fotografera och läs av din ID-handling med BankID-appen.",
+
+		CallConfirm: "Bekräfta samtalet i BankID-appen.",
+	},
+	English: {
+		RFA1:   "Start your BankID app.",
+		RFA2:   "The BankID app is not installed. Please contact your bank.",
+		RFA3:   "Action cancelled. Please try again.",
+		RFA4:   "An identification or signing for this personal number is already started. Please try again.",
+		RFA5:   "Internal error. Please try again.",
+		RFA6:   "Action cancelled.",
+		RFA8:   "The BankID app is not responding. Please check that it's started and that you have internet access. If you don't have a valid BankID you can get one from your bank. Try again.",
+		RFA9:   "Enter your security code in the BankID app and select Identify or Sign.",
+		RFA13:  "Trying to start your BankID app.",
+		RFA14A: "Searching for BankID, it may take a little while... If a few seconds have passed and still no BankID has been found, you probably don't have a BankID which can be used for this identification/signing on this computer. If you have a BankID card, please insert it into your card reader. If you don't have a BankID you can get one from your bank. If you have a BankID on another device you can start the BankID app on that device.",
+		RFA14B: "Searching for BankID, it may take a little while... If a few seconds have passed and still no BankID has been found, you probably don't have a BankID which can be used for this identification/signing on this device. If you don't have a BankID you can get one from your bank. If you have a BankID on another device you can start the BankID app on that device.",
+		RFA15A: "Searching for BankID's, it may take a little while... If a few seconds have passed and still no BankID has been found, you probably don't have a BankID which can be used for this identification/signing on this computer. If you have a BankID card, please insert it into your card reader. If you don't have a BankID you can get one from your bank.",
+		RFA15B: "Searching for BankID, it may take a little while... If a few seconds have passed and still no BankID has been found, you probably don't have a BankID which can be used for this identification/signing on this device. If you don't have a BankID you can get one from your bank.",
+		RFA16:  "The BankID you are trying to use is blocked or too old. Please use another BankID or get a new one from your bank.",
+		RFA17A: "The BankID app couldn't be found on your computer or mobile device. Please install it and get a BankID from your bank. Install the app from your app store or https://install.bankid.com.",
+		RFA17B: "Failed to scan the QR code. Start the BankID app and scan the QR code. Check that the BankID app is up to date. If you don't have the BankID app, you need to install it and get a BankID from your bank. Install the app from your app store or https://install.bankid.com.",
+		RFA18:  "Start the BankID app",
+		RFA19:  "Would you like to identify yourself or sign with a BankID on this computer, or with a Mobile BankID?",
+		RFA20:  "Would you like to identify yourself or sign with a BankID on this device or with a BankID on another device?",
+		RFA21:  "Identification or signing in progress.",
+		RFA22:  "Unknown error. Please try again.",
+		RFA23:  "Process your machine-readable travel document using the BankID app.",
+
+		CallConfirm: "Confirm the call in your BankID app.",
+	},
+}
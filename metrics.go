@@ -0,0 +1,60 @@
+package bankid
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the metrics reported by the package
+const (
+	MetricOrders          = "bankid_orders_total"                  // Counter: orders per status, label "status"
+	MetricHints           = "bankid_hints_total"                   // Counter: hint codes of pending orders, label "hint"
+	MetricRequestDuration = "bankid_http_request_duration_seconds" // Histogram: calls to the BankID server, labels "endpoint" and "code"
+	MetricOpenConnections = "bankid_open_connections"              // Gauge: network connections open to the BankID server
+	MetricStaleQR         = "bankid_stale_qr_total"                // Counter: orders still showing QR codes after staleQrAfter
+	MetricRateLimitQueue  = "bankid_rate_limit_queued"             // Gauge: calls waiting for the rate limit
+	MetricRateLimitWait   = "bankid_rate_limit_wait_seconds"       // Histogram: time calls waited for the rate limit
+	MetricCallbackPanics  = "bankid_callback_panics_total"         // Counter: panics recovered from call back functions, label "callback"
+	MetricDroppedEvents   = "bankid_events_dropped_total"          // Counter: events dropped from full event queues, see eventQueueOverflow
+)
+
+// Metrics receives the metrics of a Connection, see SetMetrics. Implementations must be safe for
+// concurrent use. Adapters for Prometheus and OpenTelemetry are found in the metrics/prometheus and
+// metrics/otel packages; for other backends, e.g. StatsD, implement the interface directly
+type Metrics interface {
+	IncCounter(name string, labels map[string]string)
+	ObserveHistogram(name string, value float64, labels map[string]string)
+	SetGauge(name string, value float64, labels map[string]string)
+}
+
+// noopMetrics is used when no Metrics has been set with SetMetrics
+type noopMetrics struct{}
+
+func (noopMetrics) IncCounter(string, map[string]string)                {}
+func (noopMetrics) ObserveHistogram(string, float64, map[string]string) {}
+func (noopMetrics) SetGauge(string, float64, map[string]string)         {}
+
+// SetMetrics sets the receiver of the metrics of the connection. By default metrics are discarded.
+// Should be called before any request is sent
+func (sc *Connection) SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	sc.metrics = m
+}
+
+// observeRequest reports a call to the BankID server, and the number of open connections after it
+func (sc *Connection) observeRequest(endpoint string, code int, start time.Time) {
+	sc.metrics.ObserveHistogram(MetricRequestDuration, time.Since(start).Seconds(), map[string]string{"endpoint": endpoint, "code": strconv.Itoa(code)})
+	sc.metrics.SetGauge(MetricOpenConnections, float64(atomic.LoadInt64(&sc.stats.openConns)), nil)
+}
+
+// observeEvent reports a status update
+func (sc *Connection) observeEvent(ev Event) {
+	if ev.Status == StatusPending {
+		sc.metrics.IncCounter(MetricHints, map[string]string{"hint": string(ev.HintCode)})
+		return
+	}
+	sc.metrics.IncCounter(MetricOrders, map[string]string{"status": string(ev.Status)})
+}
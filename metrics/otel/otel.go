@@ -0,0 +1,87 @@
+// Package otel reports the metrics of a bankid.Connection to OpenTelemetry
+//
+//	conn.SetMetrics(otel.New(otelapi.Meter("github.com/hossner/bankid")))
+package otel
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Metrics implements the bankid.Metrics interface. Instruments are created on first use
+type Metrics struct {
+	meter      metric.Meter
+	mu         sync.Mutex
+	counters   map[string]metric.Float64Counter
+	histograms map[string]metric.Float64Histogram
+	gauges     map[string]metric.Float64Gauge
+}
+
+// New returns a Metrics creating its instruments with meter
+func New(meter metric.Meter) *Metrics {
+	return &Metrics{
+		meter:      meter,
+		counters:   make(map[string]metric.Float64Counter),
+		histograms: make(map[string]metric.Float64Histogram),
+		gauges:     make(map[string]metric.Float64Gauge),
+	}
+}
+
+// IncCounter implements the bankid.Metrics interface
+func (m *Metrics) IncCounter(name string, labels map[string]string) {
+	m.mu.Lock()
+	c, ok := m.counters[name]
+	if !ok {
+		var err error
+		if c, err = m.meter.Float64Counter(name); err != nil {
+			m.mu.Unlock()
+			return
+		}
+		m.counters[name] = c
+	}
+	m.mu.Unlock()
+	c.Add(context.Background(), 1, metric.WithAttributes(attributes(labels)...))
+}
+
+// ObserveHistogram implements the bankid.Metrics interface
+func (m *Metrics) ObserveHistogram(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	h, ok := m.histograms[name]
+	if !ok {
+		var err error
+		if h, err = m.meter.Float64Histogram(name, metric.WithUnit("s")); err != nil {
+			m.mu.Unlock()
+			return
+		}
+		m.histograms[name] = h
+	}
+	m.mu.Unlock()
+	h.Record(context.Background(), value, metric.WithAttributes(attributes(labels)...))
+}
+
+// SetGauge implements the bankid.Metrics interface
+func (m *Metrics) SetGauge(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	g, ok := m.gauges[name]
+	if !ok {
+		var err error
+		if g, err = m.meter.Float64Gauge(name); err != nil {
+			m.mu.Unlock()
+			return
+		}
+		m.gauges[name] = g
+	}
+	m.mu.Unlock()
+	g.Record(context.Background(), value, metric.WithAttributes(attributes(labels)...))
+}
+
+func attributes(labels map[string]string) []attribute.KeyValue {
+	kv := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		kv = append(kv, attribute.String(k, v))
+	}
+	return kv
+}
@@ -0,0 +1,93 @@
+// Package prometheus reports the metrics of a bankid.Connection to Prometheus
+//
+//	conn.SetMetrics(prometheus.New(prom.DefaultRegisterer))
+package prometheus
+
+import (
+	"sort"
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements the bankid.Metrics interface. Collectors are created and registered on first
+// use, with the label names of that first use
+type Metrics struct {
+	reg        prom.Registerer
+	mu         sync.Mutex
+	counters   map[string]*prom.CounterVec
+	histograms map[string]*prom.HistogramVec
+	gauges     map[string]*prom.GaugeVec
+}
+
+// New returns a Metrics registering its collectors with reg
+func New(reg prom.Registerer) *Metrics {
+	return &Metrics{
+		reg:        reg,
+		counters:   make(map[string]*prom.CounterVec),
+		histograms: make(map[string]*prom.HistogramVec),
+		gauges:     make(map[string]*prom.GaugeVec),
+	}
+}
+
+// IncCounter implements the bankid.Metrics interface
+func (m *Metrics) IncCounter(name string, labels map[string]string) {
+	m.mu.Lock()
+	c, ok := m.counters[name]
+	if !ok {
+		c = prom.NewCounterVec(prom.CounterOpts{Name: name, Help: "BankID " + name}, labelNames(labels))
+		c = m.register(c).(*prom.CounterVec)
+		m.counters[name] = c
+	}
+	m.mu.Unlock()
+	c.With(labels).Inc()
+}
+
+// ObserveHistogram implements the bankid.Metrics interface
+func (m *Metrics) ObserveHistogram(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	h, ok := m.histograms[name]
+	if !ok {
+		h = prom.NewHistogramVec(prom.HistogramOpts{Name: name, Help: "BankID " + name}, labelNames(labels))
+		h = m.register(h).(*prom.HistogramVec)
+		m.histograms[name] = h
+	}
+	m.mu.Unlock()
+	h.With(labels).Observe(value)
+}
+
+// SetGauge implements the bankid.Metrics interface
+func (m *Metrics) SetGauge(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	g, ok := m.gauges[name]
+	if !ok {
+		g = prom.NewGaugeVec(prom.GaugeOpts{Name: name, Help: "BankID " + name}, labelNames(labels))
+		g = m.register(g).(*prom.GaugeVec)
+		m.gauges[name] = g
+	}
+	m.mu.Unlock()
+	g.With(labels).Set(value)
+}
+
+// register registers c, returning the collector already registered if there is one, e.g. by a
+// second connection
+func (m *Metrics) register(c prom.Collector) prom.Collector {
+	if m.reg == nil {
+		return c
+	}
+	if err := m.reg.Register(c); err != nil {
+		if are, ok := err.(prom.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+	}
+	return c
+}
+
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
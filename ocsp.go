@@ -0,0 +1,127 @@
+package bankid
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrCertificateNotGood is the error returned by VerifyOCSP when the OCSP responder did not state the
+// user certificate as good
+var ErrCertificateNotGood = errors.New("user certificate was not good at signing time")
+
+// x509CertRegexp matches the certificates in the KeyInfo of an XML signature, with any namespace prefix
+var x509CertRegexp = regexp.MustCompile(`<(?:\w+:)?X509Certificate>([^<]+)</(?:\w+:)?X509Certificate>`)
+
+// OCSPStatus is the result of verifying the OCSP response of a completed request, see VerifyOCSP
+type OCSPStatus struct {
+	Status     string    // "good", "revoked" or "unknown", as stated by the OCSP responder
+	ProducedAt time.Time // When the response was produced, i.e. at signing time
+	RevokedAt  time.Time // Set when Status is "revoked"
+	Err        error     // Set if the response could not be verified
+}
+
+// VerifyOCSP parses the OCSP response of the completion data and confirms that it is signed on
+// behalf of the issuer of the user certificate, and that the user certificate was good at signing
+// time. The user certificate and its issuer are taken from the signature. The result is stored in
+// OCSP. Set verifyOcsp in the config file to have every completed request verified
+func (cd *CompletionData) VerifyOCSP() error {
+	st := &OCSPStatus{}
+	cd.OCSP = st
+	st.Err = cd.verifyOCSP(st)
+	return st.Err
+}
+
+func (cd *CompletionData) verifyOCSP(st *OCSPStatus) error {
+	der, err := base64.StdEncoding.DecodeString(cd.OCSPResponse)
+	if err != nil {
+		return fmt.Errorf("could not decode OCSP response: %v", err)
+	}
+	chain, err := signatureCertificates(cd.Signature)
+	if err != nil {
+		return err
+	}
+	// The serial number tells which certificate the response is about; the signature is checked below
+	unverified, err := ocsp.ParseResponse(der, nil)
+	if err != nil {
+		return fmt.Errorf("could not parse OCSP response: %v", err)
+	}
+	var user, issuer *x509.Certificate
+	for _, c := range chain {
+		if c.SerialNumber.Cmp(unverified.SerialNumber) == 0 {
+			user = c
+		}
+	}
+	if user == nil {
+		return errors.New("OCSP response is not about a certificate in the signature")
+	}
+	for _, c := range chain {
+		if c != user && user.CheckSignatureFrom(c) == nil {
+			issuer = c
+		}
+	}
+	if issuer == nil {
+		return errors.New("issuer of the user certificate not found in the signature")
+	}
+	resp, err := ocsp.ParseResponseForCert(der, user, issuer)
+	if err != nil {
+		return fmt.Errorf("could not verify OCSP response: %v", err)
+	}
+	st.ProducedAt = resp.ProducedAt
+	switch resp.Status {
+	case ocsp.Good:
+		st.Status = "good"
+	case ocsp.Revoked:
+		st.Status = "revoked"
+		st.RevokedAt = resp.RevokedAt
+	default:
+		st.Status = "unknown"
+	}
+	if resp.Status != ocsp.Good {
+		return ErrCertificateNotGood
+	}
+	if resp.ProducedAt.Before(user.NotBefore) || resp.ProducedAt.After(user.NotAfter) {
+		return fmt.Errorf("%w: signed outside the validity of the certificate", ErrCertificateNotGood)
+	}
+	return nil
+}
+
+// signatureCertificates returns the certificates of the Base64 encoded XML signature
+func signatureCertificates(signature string) ([]*x509.Certificate, error) {
+	xml, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode signature: %v", err)
+	}
+	var chain []*x509.Certificate
+	for _, m := range x509CertRegexp.FindAllSubmatch(xml, -1) {
+		der, err := base64.StdEncoding.DecodeString(string(removeWhitespace(m[1])))
+		if err != nil {
+			return nil, fmt.Errorf("could not decode certificate in signature: %v", err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate in signature: %v", err)
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificates in signature")
+	}
+	return chain, nil
+}
+
+// removeWhitespace removes the line breaks and indentation of Base64 data in XML
+func removeWhitespace(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c != ' ' && c != '\n' && c != '\r' && c != '\t' {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package bankid
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Option configures a Connection as it is created, see New. Each option has the effect of the Set
+// method of the same name, applied before any request can be sent and before the background go
+// routines of the connection are started
+type Option func(sc *Connection) error
+
+// WithLogHandler sends the log of the connection to h, see SetLogHandler
+func WithLogHandler(h slog.Handler) Option {
+	return func(sc *Connection) error {
+		sc.SetLogHandler(h)
+		return nil
+	}
+}
+
+// WithLogger sends the log of the connection to the handler of l, see SetLogHandler
+func WithLogger(l *slog.Logger) Option {
+	return func(sc *Connection) error {
+		if l == nil {
+			return errors.New("no logger provided")
+		}
+		sc.SetLogHandler(l.Handler())
+		return nil
+	}
+}
+
+// WithHTTPClient makes the calls to the BankID server with c instead of a client built from the
+// config file, e.g. to add tracing or a proxy. The client must present the RP certificate and trust
+// the BankID server itself, so no certificate is read from the config file; the certificate set with
+// SetCertificate, or reloaded, is not used by it. The calls are still simulated, recorded or replayed
+// as set in the config file
+func WithHTTPClient(c *http.Client) Option {
+	return func(sc *Connection) error {
+		if c == nil {
+			return errors.New("no HTTP client provided")
+		}
+		sc.customClient = c
+		return nil
+	}
+}
+
+// WithSigner presents an RP client certificate whose private key is held by key, e.g. in an HSM or a
+// PKCS#11 token, instead of the P12 file of the config file, see CertificateFromSigner. The certificate
+// chain is chain if provided, otherwise read from the PEM file userCertFileName of the certStore section
+func WithSigner(key crypto.Signer, chain ...*x509.Certificate) Option {
+	return func(sc *Connection) error {
+		if len(chain) == 0 {
+			var err error
+			if chain, err = readCertChain(sc.cfg); err != nil {
+				return err
+			}
+		}
+		cert, err := CertificateFromSigner(key, chain...)
+		if err != nil {
+			return err
+		}
+		sc.clientCert.set(cert)
+		return nil
+	}
+}
+
+// WithClock sets the Clock of the connection, see SetClock
+func WithClock(c Clock) Option {
+	return func(sc *Connection) error {
+		sc.SetClock(c)
+		return nil
+	}
+}
+
+// WithAuditStore sets the store of the audit log, see SetAuditStore
+func WithAuditStore(s AuditStore) Option {
+	return func(sc *Connection) error {
+		return sc.SetAuditStore(s)
+	}
+}
+
+// WithRetentionPolicy sets the time to live of the orders of the connection, see SetRetentionPolicy
+func WithRetentionPolicy(p RetentionPolicy) Option {
+	return func(sc *Connection) error {
+		sc.SetRetentionPolicy(p)
+		return nil
+	}
+}
+
+// WithStore sets the store of the orders of the connection, the audit log, as WithAuditStore
+func WithStore(s AuditStore) Option {
+	return WithAuditStore(s)
+}
+
+// WithMetrics sets the receiver of the metrics of the connection, see SetMetrics
+func WithMetrics(m Metrics) Option {
+	return func(sc *Connection) error {
+		sc.SetMetrics(m)
+		return nil
+	}
+}
+
+// WithEventHandler sets the FOnEvent call back function, see SetEventHandler
+func WithEventHandler(f FOnEvent) Option {
+	return func(sc *Connection) error {
+		sc.SetEventHandler(f)
+		return nil
+	}
+}
+
+// WithCodec sets the JSON codec of the calls to the BankID server, see SetCodec
+func WithCodec(c Codec) Option {
+	return func(sc *Connection) error {
+		sc.SetCodec(c)
+		return nil
+	}
+}
+
+// WithQRRenderer sets the renderer of the animated QR codes, see SetQRRenderer
+func WithQRRenderer(r QRRenderer) Option {
+	return func(sc *Connection) error {
+		sc.SetQRRenderer(r)
+		return nil
+	}
+}
+
+// WithPublisher sets the publisher of the lifecycle events, see SetPublisher
+func WithPublisher(p Publisher) Option {
+	return func(sc *Connection) error {
+		sc.SetPublisher(p)
+		return nil
+	}
+}
+
+// WithPseudonymizer sets the Pseudonymizer of personal numbers, see SetPseudonymizer
+func WithPseudonymizer(p Pseudonymizer) Option {
+	return func(sc *Connection) error {
+		sc.SetPseudonymizer(p)
+		return nil
+	}
+}
+
+// WithLocalizer sets the Localizer of the user messages of events, see SetLocalizer
+func WithLocalizer(l Localizer) Option {
+	return func(sc *Connection) error {
+		sc.SetLocalizer(l)
+		return nil
+	}
+}
+
+// WithPanicHandler sets the FOnPanic call back function, see SetPanicHandler
+func WithPanicHandler(f FOnPanic) Option {
+	return func(sc *Connection) error {
+		sc.SetPanicHandler(f)
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package bankid
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+// countingTransport counts the calls made with it, answering each with an error
+type countingTransport struct {
+	calls int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.calls, 1)
+	return simResponse(req, http.StatusBadRequest, serverError{ErrorCode: "invalidParameters", Details: "counted"}), nil
+}
+
+// TestWithHTTPClientSimulated checks that the calls of a client set with WithHTTPClient are simulated
+// in environment simulation
+func TestWithHTTPClientSimulated(t *testing.T) {
+	tr := &countingTransport{}
+	sc := newSimConnection(t, 1000, func(string, string, string) {}, WithHTTPClient(&http.Client{Transport: tr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sc.AuthenticateAsync(testIP).Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if n := atomic.LoadInt32(&tr.calls); n != 0 {
+		t.Errorf("%d calls made with the client, want none", n)
+	}
+}
+
+// TestWithHTTPClientNoCertificate checks that no certificate is read from the config file for a client
+// set with WithHTTPClient, and that the calls are made with it
+func TestWithHTTPClientNoCertificate(t *testing.T) {
+	cfg, err := config.FromReader(strings.NewReader(`{"environment": "test", "apiVersion": "6.0", "pollDelay": 2000, "enableLogging": false, "certStore": {"userCertFileName": "missing.p12"}}`), "config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFromConfig(cfg, func(string, string, string) {}); err == nil {
+		t.Fatal("connection created without the certificate")
+	}
+	tr := &countingTransport{}
+	sc, err := NewFromConfig(cfg, func(string, string, string) {}, WithHTTPClient(&http.Client{Transport: tr}))
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	defer sc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sc.AuthenticateAsync(testIP).Wait(ctx); err == nil {
+		t.Fatal("order completed")
+	}
+	if n := atomic.LoadInt32(&tr.calls); n == 0 {
+		t.Error("no calls made with the client")
+	}
+}
@@ -0,0 +1,141 @@
+package bankid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/xid"
+)
+
+// Errors returned by Order.Wait for orders that ended without completing
+var (
+	ErrOrderFailed    = errors.New("the order failed")
+	ErrOrderCancelled = errors.New("the order was cancelled")
+)
+
+// Result is the outcome of an order started with AuthenticateAsync or SignAsync
+type Result struct {
+	RequestID  string
+	Status     Status          // StatusComplete, StatusFailed, StatusCancelled or StatusError
+	HintCode   HintCode        // Set when Status is StatusFailed
+	Completion *CompletionData // Set when Status is StatusComplete
+	Event      Event           // The last event of the order
+}
+
+// Order is a handle to an order started with AuthenticateAsync or SignAsync, safe for concurrent use
+type Order struct {
+	RequestID string
+	sc        *Connection
+	done      chan struct{}
+	mu        sync.Mutex
+	status    Status
+	result    *Result
+}
+
+// AuthenticateAsync sends an authentication request to the BankID server, as Authenticate, and returns
+// a handle to wait for the outcome of the order. The order is reported to the call back functions as
+// usual
+func (sc *Connection) AuthenticateAsync(endUserIP string, opts ...RequestOption) *Order {
+	return sc.sendAsync(newRequest(endUserIP, false, "", opts))
+}
+
+// SignAsync sends a request to sign userVisibleData, which must not be empty, to the BankID server, as
+// Sign, and returns a handle to wait for the outcome of the order. The order is reported to the call
+// back functions as usual
+func (sc *Connection) SignAsync(endUserIP, userVisibleData string, opts ...RequestOption) *Order {
+	return sc.sendAsync(newRequest(endUserIP, true, userVisibleData, opts))
+}
+
+// SendRequestAsync sends a request to the BankID server, to sign userVisibleData if not empty,
+// otherwise to authenticate, and returns a handle to wait for the outcome of the order.
+//
+// Deprecated: Use AuthenticateAsync or SignAsync, which do not tell the kind of order from
+// userVisibleData, so that a sign order with empty data is refused rather than sent as an
+// authentication
+func (sc *Connection) SendRequestAsync(endUserIP, userVisibleData string, opts ...RequestOption) *Order {
+	return sc.sendAsync(newRequest(endUserIP, userVisibleData != "", userVisibleData, opts))
+}
+
+// sendAsync sends r, returning the handle of its order
+func (sc *Connection) sendAsync(r *request) *Order {
+	if r.requestID == "" {
+		r.requestID = xid.New().String()
+	}
+	o := &Order{RequestID: r.requestID, sc: sc, done: make(chan struct{})}
+	if err := sc.start(r, func() { sc.observe(r.requestID, o.update) }); err != nil {
+		ev := Event{RequestID: r.requestID, Status: StatusError, Message: err.Error(), Err: err}
+		o.status, o.result = ev.Status, &Result{RequestID: r.requestID, Status: ev.Status, Event: ev}
+		close(o.done)
+	}
+	return o
+}
+
+// update records an event of the order, ending it on a final status
+func (o *Order) update(ev Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.result != nil {
+		return
+	}
+	o.status = ev.Status
+	if !ev.Status.final() {
+		return
+	}
+	o.sc.unobserve(ev.RequestID)
+	o.result = &Result{RequestID: ev.RequestID, Status: ev.Status, HintCode: ev.HintCode, Completion: ev.Completion, Event: ev}
+	close(o.done)
+}
+
+// Done returns a channel closed when the order has ended
+func (o *Order) Done() <-chan struct{} {
+	return o.done
+}
+
+// Status returns the last status reported for the order, empty until the order has been sent
+func (o *Order) Status() Status {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.status
+}
+
+// Cancel cancels the order, which then ends with the status "cancelled". Does nothing if the order
+// has already ended
+func (o *Order) Cancel() {
+	select {
+	case <-o.done:
+		return
+	default:
+	}
+	if s := o.sc.session(o.RequestID); s != nil {
+		s.requestCancel()
+	}
+}
+
+// Wait waits for the order to end, or for ctx to be done, in which case the order goes on and ctx.Err()
+// is returned. The error is nil only for completed orders; failed orders return ErrOrderFailed and
+// cancelled orders ErrOrderCancelled, and orders ending with an error Event.Err, or the message of the
+// event, each together with the Result
+func (o *Order) Wait(ctx context.Context) (*Result, error) {
+	select {
+	case <-o.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	o.mu.Lock()
+	res := o.result
+	o.mu.Unlock()
+	switch res.Status {
+	case StatusComplete:
+		return res, nil
+	case StatusFailed:
+		return res, fmt.Errorf("%w: %s", ErrOrderFailed, res.HintCode)
+	case StatusCancelled:
+		return res, ErrOrderCancelled
+	}
+	if res.Event.Err != nil {
+		return res, res.Event.Err
+	}
+	return res, errors.New(res.Event.Message)
+}
@@ -0,0 +1,108 @@
+package bankid
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateAsync(t *testing.T) {
+	sc := newSimConnection(t, 1000, func(requestID, status, message string) {})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := sc.AuthenticateAsync("192.0.2.1").Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusComplete || res.Completion == nil {
+		t.Errorf("got %+v, want a completed order", res)
+	}
+}
+
+func TestSignAsync(t *testing.T) {
+	sc := newSimConnection(t, 1000, func(requestID, status, message string) {})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := sc.SignAsync("192.0.2.1", "Contract").Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusComplete || res.Completion == nil {
+		t.Errorf("got %+v, want a completed order", res)
+	}
+	// A sign order without data is refused, rather than sent as an authentication
+	res, err = sc.SignAsync("192.0.2.1", "").Wait(ctx)
+	if err == nil || res.Status != StatusError {
+		t.Errorf("got %+v, %v, want an error", res, err)
+	}
+}
+
+func TestOrderCancel(t *testing.T) {
+	sc := newSimConnection(t, 10, func(requestID, status, message string) {})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o := sc.AuthenticateAsync("192.0.2.1")
+	for o.Status() == "" {
+		time.Sleep(time.Millisecond)
+	}
+	o.Cancel()
+	if _, err := o.Wait(ctx); !errors.Is(err, ErrOrderCancelled) {
+		t.Errorf("got %v, want %v", err, ErrOrderCancelled)
+	}
+}
+
+// TestDuplicateRequestID starts orders with the same request ID concurrently, and checks that one is
+// sent and the others refused, without ending the one sent
+func TestDuplicateRequestID(t *testing.T) {
+	sc := newSimConnection(t, 1, func(requestID, status, message string) {})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	const n = 8
+	orders := make(chan *Order, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			<-start
+			orders <- sc.AuthenticateAsync("192.0.2.1", WithRequestID("dup"))
+		}()
+	}
+	close(start)
+	var sent *Order
+	for i := 0; i < n; i++ {
+		o := <-orders
+		select {
+		case <-o.Done():
+			if _, err := o.Wait(ctx); !errors.Is(err, ErrDuplicateRequestID) {
+				t.Errorf("got %v, want %v", err, ErrDuplicateRequestID)
+			}
+		default:
+			if sent != nil {
+				t.Fatal("two orders sent with the same request ID")
+			}
+			sent = o
+		}
+	}
+	if sent == nil {
+		t.Fatal("no order sent")
+	}
+	if st, err := sc.Status("dup"); err != nil || st.Status == StatusError {
+		t.Errorf("order sent got status %+v, %v", st, err)
+	}
+	sent.Cancel()
+	if _, err := sent.Wait(ctx); !errors.Is(err, ErrOrderCancelled) {
+		t.Errorf("got %v, want %v", err, ErrOrderCancelled)
+	}
+	// The request ID is free again once the order has ended
+	for sc.session("dup") != nil {
+		time.Sleep(time.Millisecond)
+	}
+	o := sc.AuthenticateAsync("192.0.2.1", WithRequestID("dup"))
+	defer o.Cancel()
+	select {
+	case <-o.Done():
+		_, err := o.Wait(ctx)
+		t.Errorf("order refused: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+package bankid
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrCallbackPanic is the error reported for requests ended because a call back function of the
+// caller panicked while the request was ongoing
+var ErrCallbackPanic = errors.New("a call back function panicked, the request was cancelled")
+
+// FOnPanic is a call back function receiving panics recovered from the call back functions of the
+// caller, see SetPanicHandler. The request ID is empty for panics not tied to a request
+type FOnPanic func(requestID, callback string, recovered interface{}, stack []byte)
+
+// SetPanicHandler sets a call back function receiving the panics recovered from the FOnResponse,
+// FOnEvent, FOnStarted and FOnNewQRCode call back functions and the Localizer, e.g. to report them to
+// an error tracker. Should be called before any request is sent
+func (sc *Connection) SetPanicHandler(f FOnPanic) {
+	sc.onPanic = f
+}
+
+// callback calls f, the call back function named name of the caller, for requestID. A panic in f is
+// recovered instead of ending the go routine of the request, and the request is cancelled. Returns
+// false if f panicked
+func (sc *Connection) callback(requestID, name string, f func()) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			sc.recovered(requestID, name, v, debug.Stack())
+		}
+	}()
+	f()
+	return true
+}
+
+// recovered logs and reports a panic in the call back function name, and has the owner of the
+// ongoing request, if any, cancel the order and end it with ErrCallbackPanic
+func (sc *Connection) recovered(requestID, name string, v interface{}, stack []byte) {
+	sc.logprint(ERROR, requestID, ":", name, "panicked:", fmt.Sprint(v), "\n"+string(stack))
+	sc.metrics.IncCounter(MetricCallbackPanics, map[string]string{"callback": name})
+	if f := sc.onPanic; f != nil {
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					sc.logprint(ERROR, requestID, ": FOnPanic panicked:", fmt.Sprint(v))
+				}
+			}()
+			f(requestID, name, v, stack)
+		}()
+	}
+	if s := sc.session(requestID); s != nil {
+		s.cancelWith(ErrCallbackPanic)
+	}
+}
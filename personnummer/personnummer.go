@@ -0,0 +1,130 @@
+// Package personnummer validates and normalizes Swedish personal identity numbers (personnummer)
+// and coordination numbers (samordningsnummer), as accepted by the BankID service.
+package personnummer
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned when a personal number can not be validated
+var (
+	ErrMalformed   = errors.New("personal number malformed")
+	ErrInvalidDate = errors.New("personal number has an invalid date of birth")
+	ErrChecksum    = errors.New("personal number has an invalid check digit")
+)
+
+// coordinationOffset is added to the day of birth in a coordination number
+const coordinationOffset = 60
+
+// Normalize returns the 12 digit form, YYYYMMDDNNNC, of a personal number or coordination number
+// given in any of the forms YYYYMMDDNNNC, YYYYMMDD-NNNC, YYMMDDNNNC, YYMMDD-NNNC or YYMMDD+NNNC.
+// For the 10 digit forms the century is the one giving the latest date of birth not after now,
+// with the '+' separator denoting a person aged 100 or more. The result is validated with Validate
+func Normalize(s string, now time.Time) (string, error) {
+	s = strings.TrimSpace(s)
+	sep := byte(0)
+	if l := len(s); l == 11 || l == 13 {
+		sep = s[l-5]
+		if sep != '-' && sep != '+' {
+			return "", ErrMalformed
+		}
+		if l == 13 && sep == '+' {
+			return "", ErrMalformed
+		}
+		s = s[:l-5] + s[l-4:]
+	}
+	if !allDigits(s) {
+		return "", ErrMalformed
+	}
+	switch len(s) {
+	case 12:
+	case 10:
+		yy, _ := strconv.Atoi(s[:2])
+		year := now.Year() - (now.Year()-yy)%100
+		born, err := birthDate(strconv.Itoa(year) + s[2:6])
+		if err == nil && born.After(now) {
+			year -= 100
+		}
+		if sep == '+' {
+			year -= 100
+		}
+		s = strconv.Itoa(year) + s[2:]
+	default:
+		return "", ErrMalformed
+	}
+	if err := Validate(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// Validate checks that pnr is a 12 digit personal number or coordination number, YYYYMMDDNNNC,
+// with a valid date of birth and check digit
+func Validate(pnr string) error {
+	if len(pnr) != 12 || !allDigits(pnr) {
+		return ErrMalformed
+	}
+	if _, err := birthDate(pnr[:8]); err != nil {
+		return err
+	}
+	if !luhn(pnr[2:]) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// IsCoordinationNumber reports whether the 12 digit pnr is a coordination number, i.e. has 60
+// added to the day of birth
+func IsCoordinationNumber(pnr string) bool {
+	if len(pnr) != 12 || !allDigits(pnr) {
+		return false
+	}
+	day, _ := strconv.Atoi(pnr[6:8])
+	return day > coordinationOffset
+}
+
+// birthDate parses YYYYMMDD, where DD may be a coordination number day
+func birthDate(s string) (time.Time, error) {
+	year, _ := strconv.Atoi(s[:4])
+	month, _ := strconv.Atoi(s[4:6])
+	day, _ := strconv.Atoi(s[6:8])
+	if day > coordinationOffset {
+		day -= coordinationOffset
+	}
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || int(t.Month()) != month || t.Day() != day {
+		return time.Time{}, ErrInvalidDate
+	}
+	return t, nil
+}
+
+// luhn validates the check digit of the 10 digit form YYMMDDNNNC
+func luhn(s string) bool {
+	sum := 0
+	for i := 0; i < len(s); i++ {
+		d := int(s[i] - '0')
+		if i%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func allDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package personnummer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		in      string
+		want    string
+		wantErr error
+	}{
+		// 12 and 10 digit forms
+		{"198112289874", "198112289874", nil},
+		{"19811228-9874", "198112289874", nil},
+		{" 198112289874 ", "198112289874", nil},
+		{"8112289874", "198112289874", nil},
+		{"811228-9874", "198112289874", nil},
+		// Century of the 10 digit forms, around the date of now
+		{"2610161230", "202610161230", nil},
+		{"261016-1230", "202610161230", nil},
+		{"2610171239", "192610171239", nil},
+		{"261017-1239", "192610171239", nil},
+		{"811228+9874", "188112289874", nil},
+		{"261016+1230", "192610161230", nil},
+		{"261017+1239", "182610171239", nil},
+		// Check digit
+		{"198112289875", "", ErrChecksum},
+		{"811228-9870", "", ErrChecksum},
+		// Coordination numbers
+		{"198112889871", "198112889871", nil},
+		{"811288-9871", "198112889871", nil},
+		{"198112889874", "", ErrChecksum},
+		{"198112929871", "", ErrInvalidDate},
+		// February 29
+		{"200002291235", "200002291235", nil},
+		{"000229-1235", "200002291235", nil},
+		{"000229+1235", "", ErrInvalidDate},
+		{"190002291235", "", ErrInvalidDate},
+		{"202302291238", "", ErrInvalidDate},
+		{"230229-1238", "", ErrInvalidDate},
+		{"000289-1232", "200002891232", nil},
+		// Malformed
+		{"", "", ErrMalformed},
+		{"19811228+9874", "", ErrMalformed},
+		{"19811228/9874", "", ErrMalformed},
+		{"1981122898a4", "", ErrMalformed},
+		{"811228-98 4", "", ErrMalformed},
+		{"８１１２２８９８７４", "", ErrMalformed},
+		{"81122898741", "", ErrMalformed},
+		{"98112289874", "", ErrMalformed},
+	} {
+		got, err := Normalize(tt.in, now)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		wantErr error
+	}{
+		{"198112289874", nil},
+		{"198112889871", nil},
+		{"200002291235", nil},
+		{"198112289870", ErrChecksum},
+		{"198113289874", ErrInvalidDate},
+		{"190002291235", ErrInvalidDate},
+		{"8112289874", ErrMalformed},
+		{"19811228-9874", ErrMalformed},
+		{"19811228987x", ErrMalformed},
+	} {
+		if err := Validate(tt.in); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsCoordinationNumber(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want bool
+	}{
+		{"198112889871", true},
+		{"198112289874", false},
+		{"198112609874", false},
+		{"8112889871", false},
+		{"1981128898x1", false},
+	} {
+		if got := IsCoordinationNumber(tt.in); got != tt.want {
+			t.Errorf("IsCoordinationNumber(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
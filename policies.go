@@ -0,0 +1,46 @@
+package bankid
+
+import "github.com/hossner/bankid/config"
+
+// Certificate policies, the types of BankID a request may be restricted to with CertificatePolicies
+// in the Requirements. Used in the test environment, the production policies are replaced by their
+// test equivalents
+const (
+	PolicyBankIDOnFile     = "1.2.752.78.1.1"
+	PolicyBankIDOnCard     = "1.2.752.78.1.2"
+	PolicyMobileBankID     = "1.2.752.78.1.5"
+	PolicyNordeaEID        = "1.2.752.71.1.3" // Nordea e-id on file and on card, the same in both environments
+	PolicyTestBankIDOnFile = "1.2.3.4.5"
+	PolicyTestBankIDOnCard = "1.2.3.4.10"
+	PolicyTestMobileBankID = "1.2.3.4.25"
+	PolicyTestBankIDBanks  = "1.2.752.60.1.6" // Test BankID of some BankID banks
+)
+
+// testPolicies maps the production certificate policies to those of the test environment
+var testPolicies = map[string]string{
+	PolicyBankIDOnFile: PolicyTestBankIDOnFile,
+	PolicyBankIDOnCard: PolicyTestBankIDOnCard,
+	PolicyMobileBankID: PolicyTestMobileBankID,
+}
+
+// RequireMobileBankID returns Requirements restricting a request to Mobile BankID
+func RequireMobileBankID() *Requirements {
+	return &Requirements{CertificatePolicies: []string{PolicyMobileBankID}}
+}
+
+// environmentPolicies returns a copy of the requirements with the production certificate policies
+// replaced by their test equivalents, if the connection is to the test environment
+func environmentPolicies(req *Requirements, env config.Environment) *Requirements {
+	if req == nil || env != config.EnvironmentTest || len(req.CertificatePolicies) == 0 {
+		return req
+	}
+	cp := *req
+	cp.CertificatePolicies = make([]string, len(req.CertificatePolicies))
+	for i, p := range req.CertificatePolicies {
+		if t, ok := testPolicies[p]; ok {
+			p = t
+		}
+		cp.CertificatePolicies[i] = p
+	}
+	return &cp
+}
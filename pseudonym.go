@@ -0,0 +1,49 @@
+package bankid
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/hossner/bankid/personnummer"
+)
+
+// Pseudonymizer replaces personal numbers with pseudonyms before they reach logs, metrics or audit
+// records. The same personal number must always give the same pseudonym, so that records can still be
+// correlated. Implementations must be safe for concurrent use
+type Pseudonymizer interface {
+	Pseudonymize(personalNumber string) string
+}
+
+// HMACPseudonymizer is a Pseudonymizer using HMAC-SHA256 with a secret key of the RP. The pseudonym
+// can not be reversed, nor recomputed, without the key. Personal numbers are normalized first, so
+// that e.g. "198112289874" and "811228-9874" give the same pseudonym
+type HMACPseudonymizer struct {
+	Key []byte
+}
+
+// Pseudonymize implements the Pseudonymizer interface
+func (p HMACPseudonymizer) Pseudonymize(personalNumber string) string {
+	if pnr, err := personnummer.Normalize(personalNumber, time.Now()); err == nil {
+		personalNumber = pnr
+	}
+	h := hmac.New(sha256.New, p.Key)
+	h.Write([]byte(personalNumber))
+	return "pn_" + hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+// SetPseudonymizer sets the Pseudonymizer used for personal numbers in logs. Without one, personal
+// numbers are masked. Should be called before any request is sent
+func (sc *Connection) SetPseudonymizer(p Pseudonymizer) {
+	sc.pseudonymizer = p
+}
+
+// Pseudonymize returns the pseudonym of the personal number from the Pseudonymizer of the connection,
+// for use in records kept by the caller. Returns an empty string if no Pseudonymizer is set
+func (sc *Connection) Pseudonymize(personalNumber string) string {
+	if sc.pseudonymizer == nil {
+		return ""
+	}
+	return sc.pseudonymizer.Pseudonymize(personalNumber)
+}
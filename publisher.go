@@ -0,0 +1,112 @@
+package bankid
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const publishTimeout = 5 * time.Second
+
+// LifecycleType is the type of a LifecycleEvent
+type LifecycleType string
+
+// The lifecycle event types
+const (
+	LifecycleStarted     LifecycleType = "started"     // The order was accepted by the BankID server
+	LifecycleHintChanged LifecycleType = "hintChanged" // The hint code of the pending order changed
+	LifecycleCompleted   LifecycleType = "completed"
+	LifecycleFailed      LifecycleType = "failed" // Failed, cancelled or ended with an error, see Status
+)
+
+// LifecycleEvent is published to the Publisher of the connection for every step of an order. The
+// personal number is replaced by its pseudonym if a Pseudonymizer is set
+type LifecycleEvent struct {
+	Type           LifecycleType `json:"type"`
+	RequestID      string        `json:"requestId"`
+	OrderRef       string        `json:"orderRef,omitempty"`
+	Status         Status        `json:"status"`
+	HintCode       HintCode      `json:"hintCode,omitempty"`
+	ErrorCode      string        `json:"errorCode,omitempty"`
+	PersonalNumber string        `json:"personalNumber,omitempty"` // Set when completed
+	Risk           string        `json:"risk,omitempty"`
+	Time           time.Time     `json:"time"`
+}
+
+// Publisher publishes lifecycle events to an event bus, see SetPublisher. Payload is the event
+// encoded as JSON. The events of an order are published in order, from the go routine polling it, so
+// Publish should not block for long. Implementations must be safe for concurrent use. Adapters for
+// NATS, Kafka and RabbitMQ are found in the events/nats, events/kafka and events/rabbitmq packages
+type Publisher interface {
+	Publish(ctx context.Context, ev LifecycleEvent, payload []byte) error
+}
+
+// lifecycle holds the publisher of a connection, and the last hint code of every pending order
+type lifecycle struct {
+	mu    sync.Mutex
+	pub   Publisher
+	hints map[string]HintCode
+}
+
+// SetPublisher sets the publisher receiving the lifecycle events of all orders. Publishing is
+// disabled with nil, which is the default. Should be called before any request is sent
+func (sc *Connection) SetPublisher(p Publisher) {
+	sc.lifecycle.mu.Lock()
+	defer sc.lifecycle.mu.Unlock()
+	sc.lifecycle.pub = p
+	sc.lifecycle.hints = make(map[string]HintCode)
+}
+
+// publish converts a status update to a lifecycle event, if it is one, and publishes it
+func (sc *Connection) publish(ev Event) {
+	lc := &sc.lifecycle
+	lc.mu.Lock()
+	pub := lc.pub
+	if pub == nil {
+		lc.mu.Unlock()
+		return
+	}
+	le := LifecycleEvent{RequestID: ev.RequestID, Status: ev.Status, HintCode: ev.HintCode, ErrorCode: ev.ErrorCode, Time: ev.Time}
+	switch ev.Status {
+	case StatusSent:
+		le.Type = LifecycleStarted
+		if ev.Started != nil {
+			le.OrderRef = ev.Started.OrderRef
+		}
+	case StatusPending:
+		if last, ok := lc.hints[ev.RequestID]; ok && last == ev.HintCode {
+			lc.mu.Unlock()
+			return
+		}
+		lc.hints[ev.RequestID] = ev.HintCode
+		le.Type = LifecycleHintChanged
+	case StatusComplete:
+		le.Type = LifecycleCompleted
+		if cd := ev.Completion; cd != nil {
+			le.OrderRef, le.Risk, le.PersonalNumber = cd.OrderRef, cd.Risk, cd.User.PersonalNumber
+			if sc.pseudonymizer != nil {
+				le.PersonalNumber = sc.pseudonymizer.Pseudonymize(le.PersonalNumber)
+			}
+		}
+	case StatusFailed, StatusCancelled, StatusError:
+		le.Type = LifecycleFailed
+	default:
+		lc.mu.Unlock()
+		return
+	}
+	if ev.Status.final() {
+		delete(lc.hints, ev.RequestID)
+	}
+	lc.mu.Unlock()
+	payload, err := json.Marshal(le)
+	if err != nil {
+		sc.logprint(ERROR, ev.RequestID, ": could not encode lifecycle event:", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := pub.Publish(ctx, le, payload); err != nil {
+		sc.logprint(WARN, ev.RequestID, ": could not publish lifecycle event", string(le.Type), ":", err.Error())
+	}
+}
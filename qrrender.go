@@ -0,0 +1,97 @@
+package bankid
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QRRenderer turns the content of a QR code into something that can be displayed to the user.
+// Implement it to render QR codes for targets not covered by the built-in renderers
+type QRRenderer interface {
+	// Render returns the rendered QR code and its MIME type
+	Render(content string) (data []byte, mimeType string, err error)
+}
+
+// PNGRenderer renders QR codes as PNG images. A positive Size is the width and height of the image
+// in pixels, a negative Size is the number of pixels per QR module (as in the go-qrcode package)
+type PNGRenderer struct {
+	Size int
+}
+
+// SVGRenderer renders QR codes as SVG images, with ModuleSize being the size of each module in
+// user units (defaults to 1)
+type SVGRenderer struct {
+	ModuleSize int
+}
+
+// ASCIIRenderer renders QR codes as text, using half block characters so that the code can be
+// printed in a terminal. Set Inverse for terminals with dark text on light background
+type ASCIIRenderer struct {
+	Inverse bool
+}
+
+// defaultQRRenderer is used when no renderer has been set with SetQRRenderer
+var defaultQRRenderer QRRenderer = PNGRenderer{Size: -5}
+
+// Render implements the QRRenderer interface
+func (r PNGRenderer) Render(content string) ([]byte, string, error) {
+	png, err := qrcode.Encode(content, qrcode.Low, r.Size)
+	if err != nil {
+		return nil, "", err
+	}
+	return png, "image/png", nil
+}
+
+// Render implements the QRRenderer interface
+func (r SVGRenderer) Render(content string) ([]byte, string, error) {
+	q, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return nil, "", err
+	}
+	ms := r.ModuleSize
+	if ms < 1 {
+		ms = 1
+	}
+	bm := q.Bitmap()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, len(bm)*ms, len(bm)*ms)
+	fmt.Fprintf(&sb, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bm {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&sb, "M%d %dh%dv%dh-%dz", x*ms, y*ms, ms, ms, ms)
+			}
+		}
+	}
+	sb.WriteString(`"/></svg>`)
+	return []byte(sb.String()), "image/svg+xml", nil
+}
+
+// Render implements the QRRenderer interface
+func (r ASCIIRenderer) Render(content string) ([]byte, string, error) {
+	q, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return nil, "", err
+	}
+	return []byte(q.ToSmallString(r.Inverse)), "text/plain; charset=utf-8", nil
+}
+
+// SetQRRenderer sets the renderer used for the animated QR codes passed to the FOnNewQRCode call back
+// function. By default the QR codes are rendered as PNG images. Should be called before any request is sent
+func (sc *Connection) SetQRRenderer(r QRRenderer) {
+	if r == nil {
+		r = defaultQRRenderer
+	}
+	sc.qrRenderer = r
+}
+
+// QRMimeType returns the MIME type of the QR codes passed to the FOnNewQRCode call back functions, as
+// returned by the QRRenderer for the last one rendered, or "image/png" before any has been
+func (sc *Connection) QRMimeType() string {
+	if mimeType, _ := sc.qrMimeType.Load().(string); mimeType != "" {
+		return mimeType
+	}
+	return "image/png"
+}
@@ -0,0 +1,57 @@
+package bankid
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket limits the rate of calls to the BankID server. Callers reserve a token and wait until
+// it is available, so calls are let through in the order they were made
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting int64
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token, returning how long to wait before it may be used
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// waitRateLimit blocks until the rate limit allows another call to the BankID server, reporting the
+// number of queued calls and the time spent waiting
+func (sc *Connection) waitRateLimit() {
+	if sc.limiter == nil {
+		return
+	}
+	d := sc.limiter.reserve()
+	if d > 0 {
+		sc.metrics.SetGauge(MetricRateLimitQueue, float64(atomic.AddInt64(&sc.limiter.waiting, 1)), nil)
+		time.Sleep(d)
+		sc.metrics.SetGauge(MetricRateLimitQueue, float64(atomic.AddInt64(&sc.limiter.waiting, -1)), nil)
+	}
+	sc.metrics.ObserveHistogram(MetricRateLimitWait, d.Seconds(), nil)
+}
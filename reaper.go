@@ -0,0 +1,65 @@
+package bankid
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// reapGrace is the time the owner of an expired session is given to cancel the order and end the
+// request, before the session is removed by the reaper
+const reapGrace = time.Minute
+
+// ErrSessionExpired is the error reported for requests cancelled by the session reaper, because they
+// were still ongoing long after the BankID server ends its orders, see sessionReapInterval
+var ErrSessionExpired = errors.New("the request outlived the validity of BankID orders and was cancelled")
+
+// reapSessions runs reap at every interval, until the connection is closed
+func (sc *Connection) reapSessions(interval time.Duration) {
+	defer sc.stats.goStop()
+	ticker := sc.clock.NewTicker(interval)
+	atomic.AddInt64(&sc.stats.tickers, 1)
+	defer func() {
+		ticker.Stop()
+		atomic.AddInt64(&sc.stats.tickers, -1)
+	}()
+	for {
+		select {
+		case <-ticker.C():
+			sc.reap()
+		case <-sc.quit:
+			return
+		}
+	}
+}
+
+// reap cancels the sessions older than maxOrderAge, having the owner cancel the order at the BankID
+// server and end the request with ErrSessionExpired. Sessions still there reapGrace later, e.g.
+// because the owner is stuck delivering to a consumer that has gone away, are removed without it,
+// which also stops their QR code generator and event queue
+func (sc *Connection) reap() {
+	atomic.AddInt64(&sc.stats.sessReaps, 1)
+	now := sc.clock.Now()
+	var old []*session
+	sc.sessMu.Lock()
+	for _, s := range sc.sessions {
+		if now.Sub(s.started) > maxOrderAge {
+			old = append(old, s)
+		}
+	}
+	sc.sessMu.Unlock()
+	for _, s := range old {
+		if s.cancelWith(ErrSessionExpired) {
+			sc.logprint(WARN, s.requestID, ": request ongoing since", s.started.Format(time.RFC3339), "cancelled")
+			atomic.AddInt64(&sc.stats.sessExpire, 1)
+			continue
+		}
+		if now.Sub(s.started) > maxOrderAge+reapGrace {
+			sc.logprint(ERROR, s.requestID, ": request did not end after being cancelled, session removed")
+			s.events.close()
+			s.endCtx()
+			sc.removeSession(s)
+			atomic.AddInt64(&sc.stats.sessRemove, 1)
+		}
+	}
+}
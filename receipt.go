@@ -0,0 +1,91 @@
+package bankid
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// usrVisibleDataRegexp matches the text shown to the user in the XML signature of a sign request
+var usrVisibleDataRegexp = regexp.MustCompile(`<(?:\w+:)?usrVisibleData[^>]*>([^<]*)</(?:\w+:)?usrVisibleData>`)
+
+// Receipt is a human-readable receipt of a completed signature, for RPs to send to the user
+type Receipt struct {
+	SignedText     string // The text shown to, and signed by, the user
+	Name           string
+	PersonalNumber string // Formatted YYYYMMDD-NNNN
+	SignedAt       time.Time
+	OrderRef       string
+}
+
+// receiptHTML is the template of Receipt.HTML, with all values escaped
+var receiptHTML = template.Must(template.New("receipt").Parse(`<div class="bankid-receipt">
+<h1>Signature receipt</h1>
+<p>The following text was signed with BankID:</p>
+<blockquote>{{range .Lines}}{{.}}<br>
+{{end}}</blockquote>
+<table>
+<tr><th>Signed by</th><td>{{.Name}}</td></tr>
+<tr><th>Personal number</th><td>{{.PersonalNumber}}</td></tr>
+<tr><th>Signed at</th><td>{{.SignedAt}}</td></tr>
+<tr><th>Order reference</th><td>{{.OrderRef}}</td></tr>
+</table>
+</div>
+`))
+
+// NewReceipt creates the receipt of a completed sign request, with the signed text taken from the
+// signature. signedAt is normally the time of the completion Event
+func NewReceipt(cd *CompletionData, signedAt time.Time) (*Receipt, error) {
+	xml, err := base64.StdEncoding.DecodeString(cd.Signature)
+	if err != nil {
+		return nil, errors.New("could not decode signature")
+	}
+	m := usrVisibleDataRegexp.FindSubmatch(xml)
+	if m == nil {
+		return nil, errors.New("no signed text in signature, not a sign request")
+	}
+	text, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(m[1])))
+	if err != nil {
+		return nil, errors.New("could not decode signed text")
+	}
+	pnr := cd.User.PersonalNumber
+	if len(pnr) == 12 {
+		pnr = pnr[:8] + "-" + pnr[8:]
+	}
+	return &Receipt{
+		SignedText:     string(text),
+		Name:           cd.User.Name,
+		PersonalNumber: pnr,
+		SignedAt:       signedAt,
+		OrderRef:       cd.OrderRef,
+	}, nil
+}
+
+// Text returns the receipt as plain text
+func (r *Receipt) Text() string {
+	var b strings.Builder
+	b.WriteString("Signature receipt\n\nThe following text was signed with BankID:\n\n")
+	for _, l := range strings.Split(r.SignedText, "\n") {
+		b.WriteString("    " + l + "\n")
+	}
+	b.WriteString("\nSigned by:       " + r.Name + "\n")
+	b.WriteString("Personal number: " + r.PersonalNumber + "\n")
+	b.WriteString("Signed at:       " + r.SignedAt.Format("2006-01-02 15:04:05 MST") + "\n")
+	b.WriteString("Order reference: " + r.OrderRef + "\n")
+	return b.String()
+}
+
+// HTML returns the receipt as an HTML fragment, suitable for an e-mail
+func (r *Receipt) HTML() (string, error) {
+	var buf bytes.Buffer
+	err := receiptHTML.Execute(&buf, struct {
+		Lines                          []string
+		Name, PersonalNumber, OrderRef string
+		SignedAt                       string
+	}{strings.Split(r.SignedText, "\n"), r.Name, r.PersonalNumber, r.OrderRef, r.SignedAt.Format("2006-01-02 15:04:05 MST")})
+	return buf.String(), err
+}
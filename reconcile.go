@@ -0,0 +1,128 @@
+package bankid
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// maxOrderAge is the longest time an order may be ongoing; the BankID server ends orders well before
+const maxOrderAge = 10 * time.Minute
+
+// ReconcileReport is the outcome of reconciling the audit log, see Reconcile
+type ReconcileReport struct {
+	Time     time.Time
+	Orders   int            // Orders found in the audit log
+	Ended    map[Status]int // Orders ended, by status, including those expired by this run
+	Ongoing  int            // Orders started within the Pending time of the RetentionPolicy, or by this connection, and not yet ended
+	Expired  int            // Orders without an end, now recorded as expired
+	Pruned   int            // Records removed by the RetentionPolicy, see SetRetentionPolicy
+	ChainErr error          // Set if the hash chain of the audit log is broken
+}
+
+// FOnReconcile is a call back function receiving the outcome of every scheduled reconciliation
+type FOnReconcile func(rep ReconcileReport, err error)
+
+// SetReconcileHandler sets the call back function receiving the outcome of the reconciliations
+// scheduled with reconcileInterval. Should be called before SetAuditStore
+func (sc *Connection) SetReconcileHandler(f FOnReconcile) {
+	sc.auditMu.Lock()
+	defer sc.auditMu.Unlock()
+	sc.onReconcile = f
+}
+
+// Reconcile reads the audit log and records the orders that were started but never ended, e.g.
+// because the application crashed while polling them, as ended with status "expired", keeping the
+// log consistent with the orders at the BankID server. Orders still ongoing are left alone. The records
+// past the time to live of the RetentionPolicy are then removed, see SetRetentionPolicy
+func (sc *Connection) Reconcile() (ReconcileReport, error) {
+	now := sc.clock.Now()
+	rep := ReconcileReport{Time: now, Ended: make(map[Status]int)}
+	sc.auditMu.Lock()
+	a, retention := sc.auditor, sc.retention
+	sc.auditMu.Unlock()
+	if a == nil {
+		return rep, errors.New("no audit store set")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	recs, err := a.store.Records()
+	if err != nil {
+		return rep, fmt.Errorf("could not read audit store: %v", err)
+	}
+	rep.ChainErr = VerifyAuditChain(recs)
+	started := make(map[string]AuditRecord)
+	var order []string
+	for _, rec := range recs {
+		if rec.Status == StatusSent {
+			started[rec.RequestID] = rec
+			order = append(order, rec.RequestID)
+			continue
+		}
+		rep.Ended[rec.Status]++
+		delete(started, rec.RequestID)
+	}
+	rep.Orders = len(order)
+	for _, requestID := range order {
+		rec, ok := started[requestID]
+		if !ok {
+			continue
+		}
+		delete(started, requestID) // Request IDs may be reused
+		if _, ours := a.orders[requestID]; ours || now.Sub(rec.OrderTime) < retention.pendingTTL() {
+			rep.Ongoing++
+			continue
+		}
+		exp := AuditRecord{Time: now.UTC(), Status: StatusExpired, RequestID: requestID, OrderRef: rec.OrderRef, OrderTime: rec.OrderTime, EndUserIP: rec.EndUserIP}
+		if err := a.append(exp); err != nil {
+			return rep, fmt.Errorf("could not write audit record: %v", err)
+		}
+		sc.logprint(WARN, requestID, ": order started", rec.OrderTime.Format(time.RFC3339), "never ended, recorded as expired")
+		sc.metrics.IncCounter(MetricOrders, map[string]string{"status": string(StatusExpired)})
+		rep.Expired++
+		rep.Ended[StatusExpired]++
+	}
+	if p, ok := a.store.(AuditPruner); ok {
+		if i := retention.firstKept(recs, now); i > 0 {
+			if rep.Pruned, err = p.Prune(recs[i-1].Seq + 1); err != nil {
+				return rep, fmt.Errorf("could not prune audit store: %v", err)
+			}
+			sc.logprint(INFO, "audit log pruned,", fmt.Sprint(rep.Pruned), "records removed")
+		}
+	}
+	return rep, nil
+}
+
+// reconcile runs Reconcile at the interval, until the connection is closed
+func (sc *Connection) reconcile(interval time.Duration) {
+	defer sc.stats.goStop()
+	ticker := sc.clock.NewTicker(interval)
+	atomic.AddInt64(&sc.stats.tickers, 1)
+	defer func() {
+		ticker.Stop()
+		atomic.AddInt64(&sc.stats.tickers, -1)
+	}()
+	for {
+		select {
+		case <-ticker.C():
+			rep, err := sc.Reconcile()
+			if err != nil {
+				sc.logprint(ERROR, "reconciliation failed:", err.Error())
+			} else {
+				sc.logprint(INFO, "reconciliation:", fmt.Sprint(rep.Orders), "orders,", fmt.Sprint(rep.Ongoing), "ongoing,", fmt.Sprint(rep.Expired), "expired")
+			}
+			if rep.ChainErr != nil {
+				sc.logprint(ERROR, "audit log:", rep.ChainErr.Error())
+			}
+			sc.auditMu.Lock()
+			f := sc.onReconcile
+			sc.auditMu.Unlock()
+			if f != nil {
+				f(rep, err)
+			}
+		case <-sc.quit:
+			return
+		}
+	}
+}
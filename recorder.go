@@ -0,0 +1,191 @@
+package bankid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// scrubbedKeys are the JSON keys whose values are replaced in golden files, being personal data or
+// secrets. The order references and start tokens are kept, as the calls of an order refer to them
+var scrubbedKeys = map[string]bool{
+	"personalNumber": true,
+	"name":           true,
+	"givenName":      true,
+	"surname":        true,
+	"endUserIp":      true,
+	"ipAddress":      true,
+	"qrStartSecret":  true,
+	"signature":      true,
+	"ocspResponse":   true,
+}
+
+// recordedCall is a call to the BankID server in a golden file
+type recordedCall struct {
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// recorder keeps the calls of the golden file of the recording section of the config file. When
+// recording, every call to the BankID server is appended to the file as it is made; when replaying,
+// the calls are answered from it, in the recorded order
+type recorder struct {
+	fileName string
+	replay   bool
+	mu       sync.Mutex
+	calls    []recordedCall
+	used     []bool
+}
+
+// newRecorder returns the recorder of the golden file fileName, read if replay is set
+func newRecorder(fileName string, replay bool) (*recorder, error) {
+	rec := &recorder{fileName: fileName, replay: replay}
+	if !replay {
+		return rec, nil
+	}
+	raw, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read golden file: %v", err)
+	}
+	if err := json.Unmarshal(raw, &rec.calls); err != nil {
+		return nil, fmt.Errorf("could not unmarshal golden file %s: %v", fileName, err)
+	}
+	// The request bodies are indented in the file, and compared compact
+	for i := range rec.calls {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, rec.calls[i].Request); err == nil {
+			rec.calls[i].Request = buf.Bytes()
+		}
+	}
+	rec.used = make([]bool, len(rec.calls))
+	return rec, nil
+}
+
+// transport returns the transport of a client recording its calls made with next, or replaying them
+func (rec *recorder) transport(next http.RoundTripper) http.RoundTripper {
+	return &recordTransport{rec: rec, next: next}
+}
+
+// answer returns the first unused recorded call matching method, path and the scrubbed request body,
+// or the last matching one if all are used, e.g. when polled once more than when recorded
+func (rec *recorder) answer(method, path string, body []byte) (*recordedCall, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	last := -1
+	for i, c := range rec.calls {
+		if c.Method != method || c.Path != path || !bytes.Equal(c.Request, body) {
+			continue
+		}
+		if !rec.used[i] {
+			rec.used[i] = true
+			return &rec.calls[i], true
+		}
+		last = i
+	}
+	if last < 0 {
+		return nil, false
+	}
+	return &rec.calls[last], true
+}
+
+// save appends c to the golden file, rewritten as a whole so that it is complete after every call
+func (rec *recorder) save(c recordedCall) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.calls = append(rec.calls, c)
+	data, err := json.MarshalIndent(rec.calls, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rec.fileName, append(data, '\n'), 0600)
+}
+
+// recordTransport is the transport of a client of a connection with a recording section
+type recordTransport struct {
+	rec  *recorder
+	next http.RoundTripper // The transport of the calls recorded, not used when replaying
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if t.rec.replay {
+		c, ok := t.rec.answer(req.Method, req.URL.Path, scrubJSON(body))
+		if !ok {
+			return nil, fmt.Errorf("no recorded call matches %s %s", req.Method, req.URL.Path)
+		}
+		return simResponse(req, c.Status, c.Response), nil
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	c := recordedCall{Method: req.Method, Path: req.URL.Path, Request: scrubJSON(body), Status: resp.StatusCode, Response: scrubJSON(respBody)}
+	if err := t.rec.save(c); err != nil {
+		return nil, fmt.Errorf("could not save the call to the golden file: %v", err)
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections of the transport of the recorded calls
+func (t *recordTransport) CloseIdleConnections() {
+	if ci, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// scrubJSON returns the JSON document body with the non-empty string values of all scrubbedKeys
+// replaced, at any depth, and its keys sorted, so that the same request is always recorded the same
+// way. A body that is not JSON is returned as a JSON string
+func scrubJSON(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return json.RawMessage("null")
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		s, _ := json.Marshal(string(body))
+		return s
+	}
+	b, _ := json.Marshal(scrubValue(v))
+	return b
+}
+
+func scrubValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if s, ok := val.(string); ok && s != "" && scrubbedKeys[k] {
+				t[k] = "scrubbed"
+			} else {
+				t[k] = scrubValue(val)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = scrubValue(t[i])
+		}
+	}
+	return v
+}
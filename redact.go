@@ -0,0 +1,111 @@
+package bankid
+
+import (
+	"encoding/json"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hossner/bankid/personnummer"
+)
+
+var (
+	pnrPattern  = regexp.MustCompile(`\b(?:19|20)?\d{6}[-+]?\d{4}\b`)
+	ipv4Pattern = regexp.MustCompile(`\b(\d{1,3}\.\d{1,3}\.\d{1,3})\.\d{1,3}\b`)
+	ipv6Pattern = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+)
+
+// redactLine masks the personal numbers and IP addresses found in a log line. Personal numbers are
+// replaced altogether, IPv4 addresses keep their first three octets and IPv6 addresses their first
+// three groups, so that the network of a user can still be told
+func redactLine(s string) string {
+	s = pnrPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if _, err := personnummer.Normalize(m, time.Now()); err != nil {
+			return m // Some other number
+		}
+		return "<personal number>"
+	})
+	s = ipv6Pattern.ReplaceAllStringFunc(s, func(m string) string {
+		ip := net.ParseIP(m)
+		if ip == nil || ip.To4() != nil {
+			return m
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	})
+	return ipv4Pattern.ReplaceAllStringFunc(s, func(m string) string {
+		if net.ParseIP(m) == nil {
+			return m
+		}
+		return m[:strings.LastIndexByte(m, '.')] + ".x"
+	})
+}
+
+// redactedKeys are the JSON keys whose values are never logged in clear text
+var redactedKeys = map[string]bool{
+	"personalNumber":     true,
+	"name":               true,
+	"givenName":          true,
+	"surname":            true,
+	"endUserIp":          true,
+	"ipAddress":          true,
+	"userVisibleData":    true,
+	"userNonVisibleData": true,
+	"autoStartToken":     true,
+	"qrStartToken":       true,
+	"qrStartSecret":      true,
+	"orderRef":           true,
+	"signature":          true,
+	"ocspResponse":       true,
+}
+
+// redactedKey reports whether the value of the JSON key k is never logged in clear text: one of
+// redactedKeys, or a key naming a password or a secret, e.g. userPrivateKeyPassword
+func redactedKey(k string) bool {
+	lk := strings.ToLower(k)
+	return redactedKeys[k] || strings.Contains(lk, "password") || strings.Contains(lk, "secret")
+}
+
+// redactJSON returns a copy of the JSON document body with the values of all redacted keys masked, at
+// any depth. Personal numbers are replaced by their pseudonyms if p is not nil. A body that is not
+// valid JSON is never returned, only its size
+func redactJSON(body []byte, p Pseudonymizer) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "<non-JSON body, " + strconv.Itoa(len(body)) + " bytes>"
+	}
+	b, err := json.Marshal(redactValue(v, p))
+	if err != nil {
+		return "<unprintable body, " + strconv.Itoa(len(body)) + " bytes>"
+	}
+	return string(b)
+}
+
+func redactValue(v interface{}, p Pseudonymizer) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if s, ok := val.(string); ok && k == "personalNumber" && p != nil {
+				t[k] = p.Pseudonymize(s)
+			} else if redactedKey(k) {
+				t[k] = mask(val)
+			} else {
+				t[k] = redactValue(val, p)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redactValue(t[i], p)
+		}
+	}
+	return v
+}
+
+// mask replaces a value with a marker telling only its length, for strings
+func mask(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return "***(" + strconv.Itoa(len(s)) + ")"
+	}
+	return "***"
+}
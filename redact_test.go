@@ -0,0 +1,162 @@
+package bankid
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testPersonalNumber = "198112289874"
+	testIP             = "192.0.2.57"
+	testTTBS           = "Transfer 100 SEK to account 1234"
+	testPassword       = "qwerty123"
+	testSignature      = "PHNpZ25hdHVyZT5zZWNyZXQ8L3NpZ25hdHVyZT4="
+	testOCSP           = "TUlJSG9BWUpLb1pJaHZjTkFRY0NvSUlIa1RDQ0I="
+)
+
+// secrets are the values that must never be found in the wire log
+var secrets = []string{testPersonalNumber, testPersonalNumber[2:], testIP, testTTBS, base64.StdEncoding.EncodeToString([]byte(testTTBS)), testPassword, testSignature, testOCSP, "Karl", "Karlsson"}
+
+// assertRedacted fails the test if any of the secrets is found in s
+func assertRedacted(t *testing.T, s string) {
+	t.Helper()
+	for _, secret := range secrets {
+		if strings.Contains(s, secret) {
+			t.Errorf("%q found in %s", secret, s)
+		}
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"sign request", `{"endUserIp": "` + testIP + `", "userVisibleData": "` + base64.StdEncoding.EncodeToString([]byte(testTTBS)) + `", "requirement": {"personalNumber": "` + testPersonalNumber + `"}}`},
+		{"completed collect", `{"orderRef": "131daac9-16c6-4618-beb0-365768f37288", "status": "complete", "completionData": {
+			"user": {"personalNumber": "` + testPersonalNumber + `", "name": "Karl Karlsson", "givenName": "Karl", "surname": "Karlsson"},
+			"device": {"ipAddress": "` + testIP + `"}, "signature": "` + testSignature + `", "ocspResponse": "` + testOCSP + `"}}`},
+		{"nested in arrays", `{"orders": [{"users": [{"personalNumber": "` + testPersonalNumber + `"}, {"ipAddress": "` + testIP + `"}]}], "deep": [[{"signature": "` + testSignature + `"}]]}`},
+		{"password", `{"certStore": {"userPrivateKeyPassword": "` + testPassword + `"}, "password": "` + testPassword + `"}`},
+		{"non-string values", `{"personalNumber": 198112289874, "ocspResponse": {"value": "` + testOCSP + `"}, "signature": ["` + testSignature + `"]}`},
+		{"truncated", `{"completionData": {"user": {"personalNumber": "` + testPersonalNumber + `", "name": "Karl`},
+		{"not JSON", `personalNumber=` + testPersonalNumber + `&endUserIp=` + testIP},
+		{"trailing data", `{"status": "pending"} {"personalNumber": "` + testPersonalNumber + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactJSON([]byte(tt.body), nil)
+			assertRedacted(t, got)
+			if json.Valid([]byte(tt.body)) && !json.Valid([]byte(got)) {
+				t.Errorf("redacted body is not JSON: %s", got)
+			}
+		})
+	}
+}
+
+func TestRedactJSONKeepsOtherFields(t *testing.T) {
+	got := redactJSON([]byte(`{"status": "pending", "hintCode": "userSign", "user": {"personalNumber": "`+testPersonalNumber+`"}}`), nil)
+	want := `{"hintCode":"userSign","status":"pending","user":{"personalNumber":"***(12)"}}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRedactJSONPseudonymizes(t *testing.T) {
+	p := HMACPseudonymizer{Key: []byte("key")}
+	got := redactJSON([]byte(`{"completionData": {"user": {"personalNumber": "`+testPersonalNumber+`"}}}`), p)
+	assertRedacted(t, got)
+	if !strings.Contains(got, p.Pseudonymize(testPersonalNumber)) {
+		t.Errorf("no pseudonym in %s", got)
+	}
+}
+
+func TestRedactLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"completed by " + testPersonalNumber, "completed by <personal number>"},
+		{"completed by 811228-9874", "completed by <personal number>"},
+		{"completed by 19811228-9874.", "completed by <personal number>."},
+		{"from " + testIP + ":443", "from 192.0.2.x:443"},
+		{"from 2001:db8:1:2::5", "from 2001:db8:1::/48"},
+		{"HTTP 200 in 1234 ms, order 8112289875", "HTTP 200 in 1234 ms, order 8112289875"}, // Not a valid personal number
+		{"version 1.2.3", "version 1.2.3"},
+	}
+	for _, tt := range tests {
+		if got := redactLine(tt.line); got != tt.want {
+			t.Errorf("redactLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestWireLogRedacted signs with a simulated order with wireDebug set, and checks that no personal
+// data or secrets of the order are found in the log or in the bodies passed to the HTTP call backs
+func TestWireLogRedacted(t *testing.T) {
+	log := newResponseLog()
+	var mu sync.Mutex
+	var calls []string
+	onCall := func(call HTTPCall) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call.Request, call.Response)
+	}
+	sc := newSimConnection(t, 1000, log.onResponse, WithHTTPRequestHandler(onCall), WithHTTPResponseHandler(onCall))
+	sc.cfg.WireDebug = true
+	var buf syncBuffer
+	sc.SetLogHandler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	done := log.expect("sign")
+	sc.Sign(testIP, testTTBS, WithRequestID("sign"), WithRequirement(&Requirements{PersonalNumber: testPersonalNumber}))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no final status")
+	}
+	log.mu.Lock()
+	final := log.calls["sign"][len(log.calls["sign"])-1]
+	log.mu.Unlock()
+	if final != string(StatusComplete)+" "+testPersonalNumber {
+		t.Fatalf("ended with %q", final)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "wire <") {
+		t.Fatalf("no wire log in %s", out)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range []string{out, strings.Join(calls, "\n")} {
+		assertRedacted(t, s)
+		// The signature and OCSP response of the simulator
+		for _, secret := range []string{base64.StdEncoding.EncodeToString([]byte("<simulated/>")), base64.StdEncoding.EncodeToString([]byte("simulated"))} {
+			if strings.Contains(s, secret) {
+				t.Errorf("%q found in %s", secret, s)
+			}
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
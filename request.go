@@ -0,0 +1,260 @@
+package bankid
+
+import (
+	"context"
+
+	"github.com/rs/xid"
+)
+
+// RequestOption configures an authentication or sign request
+type RequestOption func(*request)
+
+// request holds the parameters of an auth/sign request, as set by the caller
+type request struct {
+	requestID          string
+	endUserIP          string
+	sign               bool
+	userVisibleData    string
+	userNonVisibleData string
+	requirements       *Requirements
+	returnRisk         bool
+	rawResponse        bool
+	returnURL          string
+	web                *WebContext
+	app                *AppContext
+	phone              bool   // Phone order, with the user on the phone instead of in front of a screen
+	callInitiator      string // Of phone orders
+	language           string // Of the user messages of the events
+	certName           string // RP certificate of the calls, the default one if empty
+	ctx                context.Context
+	onQRCodeCtx        FOnNewQRCodeContext
+	onQRCode           FOnNewQRCode
+	onStarted          FOnStarted
+}
+
+// WithRequestID sets the request ID used in call backs. If not set, an ID is generated. Requests with
+// the request ID of an ongoing request are refused with ErrDuplicateRequestID
+func WithRequestID(requestID string) RequestOption {
+	return func(r *request) {
+		r.requestID = requestID
+	}
+}
+
+// WithQRCallback enables animated QR codes for the request, calling f every second with a new QR code
+func WithQRCallback(f FOnNewQRCode) RequestOption {
+	return func(r *request) {
+		r.onQRCode = f
+	}
+}
+
+// WithStartedCallback sets a call back function receiving the StartedOrder once the order has been
+// accepted by the BankID server, before the "sent" status update
+func WithStartedCallback(f FOnStarted) RequestOption {
+	return func(r *request) {
+		r.onStarted = f
+	}
+}
+
+// WithRequirement sets the requirements of the request
+func WithRequirement(requirements *Requirements) RequestOption {
+	return func(r *request) {
+		r.requirements = requirements
+	}
+}
+
+// WithNonVisibleData sets data, not displayed to the user, that is included in the signature. Only allowed
+// in sign requests
+func WithNonVisibleData(data string) RequestOption {
+	return func(r *request) {
+		r.userNonVisibleData = data
+	}
+}
+
+// WithReturnRisk asks the BankID server for the risk indicator of the order, returned in the Risk of
+// the CompletionData. Supported from API version 6.0
+func WithReturnRisk() RequestOption {
+	return func(r *request) {
+		r.returnRisk = true
+	}
+}
+
+// WithRawResponse keeps the body of every collect response of the request, as received from the
+// BankID server, in Raw of the Event.Collect, e.g. to store the exact response for dispute handling
+func WithRawResponse() RequestOption {
+	return func(r *request) {
+		r.rawResponse = true
+	}
+}
+
+// WebContext describes the browser the user started the order in, used by the BankID server in its
+// risk assessment. Supported from API version 6.0
+type WebContext struct {
+	ReferringDomain  string `json:"referringDomain,omitempty"`  // Domain of the page starting the order, e.g. "example.com"
+	UserAgent        string `json:"userAgent,omitempty"`        // User-Agent header of the browser
+	DeviceIdentifier string `json:"deviceIdentifier,omitempty"` // Identifier of the device, e.g. a hash of a cookie, kept between orders
+}
+
+// AppContext describes the app the user started the order in, used by the BankID server in its risk
+// assessment. Supported from API version 6.0
+type AppContext struct {
+	AppIdentifier    string `json:"appIdentifier,omitempty"` // E.g. the package name, "com.example.app"
+	DeviceOS         string `json:"deviceOS,omitempty"`      // E.g. "IOS 16.7.7"
+	DeviceModelName  string `json:"deviceModelName,omitempty"`
+	DeviceIdentifier string `json:"deviceIdentifier,omitempty"` // Identifier of the device, kept between orders
+}
+
+// WithReturnURL sets the URL the BankID app returns the user to, when started on the same device
+// with the autostart token. Supported from API version 6.0
+func WithReturnURL(url string) RequestOption {
+	return func(r *request) {
+		r.returnURL = url
+	}
+}
+
+// WithLanguage sets the language of the user messages of the events of the request, e.g. "sv" or
+// "en-GB", typically from the Accept-Language header of the end user. Defaults to the language set
+// in the config file
+func WithLanguage(lang string) RequestOption {
+	return func(r *request) {
+		r.language = lang
+	}
+}
+
+// WithCertificate makes the calls of the request with the RP certificate of the name, added with
+// AddCertificate or in certStore.certificates of the config file, instead of the default one, e.g. for
+// a platform making orders on behalf of several BankID agreements. The request ends with an error if
+// there is no certificate of the name
+func WithCertificate(name string) RequestOption {
+	return func(r *request) {
+		r.certName = name
+	}
+}
+
+// WithWeb passes the browser the order was started in to the BankID server. Not to be combined with
+// WithApp
+func WithWeb(web WebContext) RequestOption {
+	return func(r *request) {
+		r.web = &web
+	}
+}
+
+// WithApp passes the app the order was started in to the BankID server. Not to be combined with
+// WithWeb
+func WithApp(app AppContext) RequestOption {
+	return func(r *request) {
+		r.app = &app
+	}
+}
+
+// Authenticate sends an authentication request to the BankID server. Returns the request ID used
+// in call backs; the one set with WithRequestID if provided, otherwise a generated one
+func (sc *Connection) Authenticate(endUserIP string, opts ...RequestOption) string {
+	return sc.send(newRequest(endUserIP, false, "", opts))
+}
+
+// Sign sends a request to sign userVisibleData, which must not be empty, to the BankID server. Returns
+// the request ID used in call backs; the one set with WithRequestID if provided, otherwise a generated one
+func (sc *Connection) Sign(endUserIP, userVisibleData string, opts ...RequestOption) string {
+	return sc.send(newRequest(endUserIP, true, userVisibleData, opts))
+}
+
+// The parties that may have initiated the call of a phone order
+const (
+	CallInitiatorUser = "user"
+	CallInitiatorRP   = "RP"
+)
+
+// StartPhoneAuth sends a request to authenticate the user with personalNumber over the phone, e.g. in
+// a call center, to the BankID server. The call was initiated by callInitiator, CallInitiatorUser or
+// CallInitiatorRP. There are no QR codes or autostart tokens; the user is told to open the BankID app.
+// Requires version 6.0 or later of the RP API. Returns the request ID used in call backs
+func (sc *Connection) StartPhoneAuth(personalNumber, callInitiator string, opts ...RequestOption) string {
+	return sc.send(newPhoneRequest(personalNumber, callInitiator, false, "", opts))
+}
+
+// StartPhoneSign sends a request to sign userVisibleData by the user with personalNumber over the
+// phone, see StartPhoneAuth. Returns the request ID used in call backs
+func (sc *Connection) StartPhoneSign(personalNumber, callInitiator, userVisibleData string, opts ...RequestOption) string {
+	return sc.send(newPhoneRequest(personalNumber, callInitiator, true, userVisibleData, opts))
+}
+
+func newPhoneRequest(personalNumber, callInitiator string, sign bool, userVisibleData string, opts []RequestOption) *request {
+	r := newRequest("", sign, userVisibleData, opts)
+	r.phone, r.callInitiator = true, callInitiator
+	r.onQRCode, r.onQRCodeCtx = nil, nil
+	// The requirements are normalized when validated, so the request needs its own copy
+	var req Requirements
+	if r.requirements != nil {
+		req = *r.requirements
+	}
+	req.PersonalNumber = personalNumber
+	r.requirements = &req
+	return r
+}
+
+func newRequest(endUserIP string, sign bool, userVisibleData string, opts []RequestOption) *request {
+	r := &request{endUserIP: endUserIP, sign: sign, userVisibleData: userVisibleData}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// send starts handling of the request in a separate go routine
+func (sc *Connection) send(r *request) string {
+	sc.start(r, nil)
+	return r.requestID
+}
+
+// start starts handling of the request in a separate go routine, calling registered first, if not nil,
+// once the request ID is taken by the request. Returns ErrDuplicateRequestID if a request with the same
+// request ID is ongoing, reporting it to the call back functions only, as the observers, audit log and
+// webhook of the request ID are those of the ongoing request
+func (sc *Connection) start(r *request, registered func()) error {
+	if r.requestID == "" {
+		r.requestID = xid.New().String()
+		sc.logprint(DEBUG, "requestID", r.requestID, "created")
+	}
+	sc.logprint(DEBUG, r.requestID, ": new request to send")
+	s := newSession(r, sc.clock.Now())
+	if sc.cfg.EventQueueSize > 0 {
+		s.events = newEventQueue(sc.cfg.EventQueueSize, EventQueueOverflow(sc.cfg.EventQueueOverflow))
+	}
+	err := sc.addSession(s)
+	if err != nil {
+		sc.logprint(WARN, r.requestID, ": request refused:", err.Error())
+		s.endCtx()
+	}
+	if err == ErrDuplicateRequestID {
+		ev := Event{RequestID: r.requestID, Status: StatusError, Message: err.Error(), Err: err, Time: sc.clock.Now(), Language: sc.cfg.Language}
+		ev.UserMessage = sc.localize(ev)
+		sc.notify(eventContext(nil, r.requestID), ev)
+		return err
+	}
+	if registered != nil {
+		registered()
+	}
+	if err != nil {
+		sc.emit(Event{RequestID: r.requestID, Status: StatusError, Message: err.Error(), Err: err})
+		return nil
+	}
+	if s.events != nil {
+		sc.stats.goStart()
+		go func() {
+			defer sc.stats.goStop()
+			defer s.endCtx()
+			s.events.run()
+		}()
+	}
+	sc.stats.goStart()
+	go func() {
+		defer sc.stats.goStop()
+		defer sc.removeSession(s)
+		defer s.events.close()
+		if s.events == nil {
+			defer s.endCtx()
+		}
+		sc.handleAuthSignRequest(s)
+	}()
+	return nil
+}
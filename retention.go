@@ -0,0 +1,121 @@
+package bankid
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RetentionPolicy is the time to live of the data of the orders kept by the connection, see
+// SetRetentionPolicy. A zero time to live keeps the data for ever, but for Pending. Evidence kept by
+// the application, e.g. the signatures and OCSP responses of completions, is not covered; its time to
+// live has to be enforced by the application
+type RetentionPolicy struct {
+	Pending   time.Duration // Orders started but not ended are recorded as expired by Reconcile after this, 10 minutes if zero
+	Completed time.Duration // Audit records are removed by Reconcile this long after they were written, e.g. years
+}
+
+// AuditPruner is implemented by the AuditStores able to remove old records, to enforce the Completed
+// time to live of a RetentionPolicy
+type AuditPruner interface {
+	// Prune removes the records of the log before the record with sequence number seq, and returns the
+	// number of records removed
+	Prune(seq uint64) (int, error)
+}
+
+// pendingTTL returns the time after which an order started but not ended is recorded as expired
+func (p RetentionPolicy) pendingTTL() time.Duration {
+	if p.Pending <= 0 {
+		return maxOrderAge
+	}
+	return p.Pending
+}
+
+// firstKept returns the index of the first of the records, in the order appended, not to be removed at
+// now. Only records at the start of the log are removed, so that the hash chain of the rest holds
+func (p RetentionPolicy) firstKept(recs []AuditRecord, now time.Time) int {
+	if p.Completed <= 0 {
+		return 0
+	}
+	for i, rec := range recs {
+		if now.Sub(rec.Time) < p.Completed {
+			return i
+		}
+	}
+	return len(recs)
+}
+
+// SetRetentionPolicy sets the time to live of the orders of the connection. The audit log is pruned
+// by Reconcile, if the AuditStore implements AuditPruner. Should be called before SetAuditStore
+func (sc *Connection) SetRetentionPolicy(p RetentionPolicy) {
+	sc.auditMu.Lock()
+	defer sc.auditMu.Unlock()
+	sc.retention = p
+}
+
+// Prune implements the AuditPruner interface
+func (m *MemoryAuditStore) Prune(seq uint64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for n < len(m.recs) && m.recs[n].Seq < seq {
+		n++
+	}
+	m.recs = append([]AuditRecord(nil), m.recs[n:]...)
+	return n, nil
+}
+
+// Prune implements the AuditPruner interface. The file is rewritten without the records removed, and
+// replaces the old one once synced to disk
+func (fs *FileAuditStore) Prune(seq uint64) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	recs, err := fs.records()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for n < len(recs) && recs[n].Seq < seq {
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fs.name), filepath.Base(fs.name)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, rec := range recs[n:] {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), fs.name); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(fs.name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	fs.f.Close()
+	fs.f = f
+	return n, nil
+}
@@ -0,0 +1,110 @@
+package bankid
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeAuditLog appends the chained records of orders started at the times to store, ended with status
+// complete, or not ended if the status is empty
+func writeAuditLog(t *testing.T, store AuditStore, started []time.Time, statuses []Status) {
+	t.Helper()
+	a := &auditor{store: store}
+	for i, at := range started {
+		requestID := "req-" + string(rune('a'+i))
+		if err := a.append(AuditRecord{Time: at, Status: StatusSent, RequestID: requestID, OrderTime: at}); err != nil {
+			t.Fatal(err)
+		}
+		if statuses[i] == "" {
+			continue
+		}
+		if err := a.append(AuditRecord{Time: at.Add(time.Minute), Status: statuses[i], RequestID: requestID, OrderTime: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRetentionPrunes(t *testing.T) {
+	file, err := NewFileAuditStore(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for name, store := range map[string]interface {
+		AuditStore
+		AuditPruner
+	}{"memory": &MemoryAuditStore{}, "file": file} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			old, recent := now.AddDate(-2, 0, 0), now.AddDate(0, -1, 0)
+			writeAuditLog(t, store, []time.Time{old, old, recent}, []Status{StatusComplete, StatusFailed, StatusComplete})
+			sc := newSimConnection(t, 1, func(string, string, string) {}, WithRetentionPolicy(RetentionPolicy{Completed: 365 * 24 * time.Hour}), WithAuditStore(store))
+			rep, err := sc.Reconcile()
+			if err != nil {
+				t.Fatalf("Reconcile: %v", err)
+			}
+			if rep.Pruned != 4 {
+				t.Errorf("%d records pruned, want 4", rep.Pruned)
+			}
+			recs, err := store.Records()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != 2 || recs[0].Seq != 5 {
+				t.Fatalf("records %+v left, want 5 and 6", recs)
+			}
+			// The chain goes on from the records left
+			sc.audit(Event{RequestID: "req-d", Status: StatusSent, Time: now})
+			if recs, err = store.Records(); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyAuditChain(recs); err != nil || len(recs) != 3 {
+				t.Errorf("%d records, chain %v", len(recs), err)
+			}
+		})
+	}
+}
+
+func TestRetentionPending(t *testing.T) {
+	for _, tt := range []struct {
+		pending     time.Duration
+		wantExpired int
+	}{
+		{0, 1},
+		{30 * time.Minute, 0},
+	} {
+		store := &MemoryAuditStore{}
+		writeAuditLog(t, store, []time.Time{time.Now().Add(-20 * time.Minute)}, []Status{""})
+		sc := newSimConnection(t, 1, func(string, string, string) {}, WithRetentionPolicy(RetentionPolicy{Pending: tt.pending}), WithAuditStore(store))
+		rep, err := sc.Reconcile()
+		if err != nil {
+			t.Fatalf("Reconcile: %v", err)
+		}
+		if rep.Expired != tt.wantExpired || rep.Pruned != 0 {
+			t.Errorf("pending %v: %d expired, %d pruned, want %d expired", tt.pending, rep.Expired, rep.Pruned, tt.wantExpired)
+		}
+	}
+}
+
+// shiftedClock is the wall clock shifted by shift
+type shiftedClock struct {
+	realClock
+	shift time.Duration
+}
+
+func (c shiftedClock) Now() time.Time { return time.Now().Add(c.shift) }
+
+// TestReconcileClock checks that the age of the orders is measured with the clock of the connection
+func TestReconcileClock(t *testing.T) {
+	store := &MemoryAuditStore{}
+	writeAuditLog(t, store, []time.Time{time.Now().Add(-time.Minute)}, []Status{""})
+	sc := newSimConnection(t, 1, func(string, string, string) {}, WithClock(shiftedClock{shift: time.Hour}), WithAuditStore(store))
+	rep, err := sc.Reconcile()
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if rep.Expired != 1 {
+		t.Errorf("%d orders expired, want 1", rep.Expired)
+	}
+}
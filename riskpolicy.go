@@ -0,0 +1,159 @@
+package bankid
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Decision is the outcome of evaluating a RiskPolicy
+type Decision string
+
+// The decisions of a RiskPolicy, from the least to the most severe
+const (
+	DecisionAllow  Decision = "allow"
+	DecisionStepUp Decision = "stepUp" // Ask for a new authentication with the PIN code, see StepUp
+	DecisionDeny   Decision = "deny"
+)
+
+// ErrRiskTooHigh is the error reported for completed requests rejected because the risk indicator
+// returned by the BankID server is above maxRisk, see the config file setting of the same name
+var ErrRiskTooHigh = errors.New("risk of the order above maxRisk, completion rejected")
+
+// ErrRiskMissing is the error reported for completed requests rejected because maxRisk is set and the
+// BankID server returned no risk indicator
+var ErrRiskMissing = errors.New("no risk indicator of the order with maxRisk set, completion rejected")
+
+// riskLevels orders the risk indicators returned by the BankID server
+var riskLevels = map[string]int{"low": 1, "moderate": 2, "high": 3}
+
+// decisionSeverity orders the decisions, the most severe decision of all matching rules wins
+var decisionSeverity = map[Decision]int{DecisionAllow: 1, DecisionStepUp: 2, DecisionDeny: 3}
+
+// RiskPolicy is a declarative policy for post-authentication decisions, evaluated on the completion
+// data of a request. It may be declared in code, or loaded from JSON with ParseRiskPolicy
+type RiskPolicy struct {
+	Rules   []RiskRule `json:"rules"`
+	Default Decision   `json:"default"` // Decision when no rule matches, allow if empty
+}
+
+// RiskRule gives a decision for completions matching all of its conditions. Conditions left out
+// always match, so a rule without conditions matches every completion
+type RiskRule struct {
+	Name                string   `json:"name"`                // Reported as the reason when the rule decides
+	MinRisk             string   `json:"minRisk"`             // Risk indicator at least "low", "moderate" or "high"
+	IPMismatch          bool     `json:"ipMismatch"`          // The IP address of the device differs from the end user IP of the request
+	IssuerNotIn         []string `json:"issuerNotIn"`         // The common name of the issuer of the user certificate is not one of these
+	CertYoungerThanDays int      `json:"certYoungerThanDays"` // The BankID of the user was issued less than this many days ago
+	Decision            Decision `json:"decision"`
+}
+
+// ParseRiskPolicy parses a RiskPolicy in JSON, and checks its decisions and risk levels
+func ParseRiskPolicy(data []byte) (*RiskPolicy, error) {
+	var p RiskPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("could not parse risk policy: %v", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *RiskPolicy) validate() error {
+	if p.Default != "" && decisionSeverity[p.Default] == 0 {
+		return fmt.Errorf("risk policy: invalid default decision %q", p.Default)
+	}
+	for i, r := range p.Rules {
+		if decisionSeverity[r.Decision] == 0 {
+			return fmt.Errorf("risk policy: rule %d: invalid decision %q", i, r.Decision)
+		}
+		if r.MinRisk != "" && riskLevels[r.MinRisk] == 0 {
+			return fmt.Errorf("risk policy: rule %d: invalid minRisk %q", i, r.MinRisk)
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the decision of the policy for a completed request sent with endUserIP, and the
+// name of the deciding rule. If several rules match, the most severe decision is returned
+func (p *RiskPolicy) Evaluate(cd *CompletionData, endUserIP string) (Decision, string) {
+	decision, reason := p.Default, "default"
+	if decision == "" {
+		decision = DecisionAllow
+	}
+	matched := false
+	var issuer string
+	if cert, err := userCertificate(cd.Signature); err == nil {
+		issuer = cert.Issuer.CommonName
+	}
+	for _, r := range p.Rules {
+		if !r.matches(cd, endUserIP, issuer) {
+			continue
+		}
+		if !matched || decisionSeverity[r.Decision] > decisionSeverity[decision] {
+			decision, reason, matched = r.Decision, r.Name, true
+		}
+	}
+	return decision, reason
+}
+
+func (r *RiskRule) matches(cd *CompletionData, endUserIP, issuer string) bool {
+	if r.MinRisk != "" && riskLevels[cd.Risk] < riskLevels[r.MinRisk] {
+		return false
+	}
+	if r.IPMismatch && cd.Device.IPAddress == endUserIP {
+		return false
+	}
+	if len(r.IssuerNotIn) > 0 {
+		for _, cn := range r.IssuerNotIn {
+			if strings.EqualFold(cn, issuer) {
+				return false
+			}
+		}
+	}
+	if r.CertYoungerThanDays > 0 && time.Since(cd.Cert.NotBefore) >= time.Duration(r.CertYoungerThanDays)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
+// checkRisk returns ErrRiskTooHigh if maxRisk is set and the risk indicator of a completion is above
+// it, and ErrRiskMissing if there is none, so that a risk that cannot be checked is not accepted
+func (sc *Connection) checkRisk(risk string) error {
+	if sc.cfg.MaxRisk == "" {
+		return nil
+	}
+	if risk == "" {
+		return ErrRiskMissing
+	}
+	if riskLevels[risk] > riskLevels[sc.cfg.MaxRisk] {
+		return ErrRiskTooHigh
+	}
+	return nil
+}
+
+// userCertificate returns the user certificate of the Base64 encoded XML signature, i.e. the one
+// certificate of the signature not issuing any of the others
+func userCertificate(signature string) (*x509.Certificate, error) {
+	chain, err := signatureCertificates(signature)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chain {
+		leaf := true
+		for _, o := range chain {
+			if o != c && o.CheckSignatureFrom(c) == nil {
+				leaf = false
+				break
+			}
+		}
+		if leaf {
+			return c, nil
+		}
+	}
+	return nil, errors.New("no user certificate in signature")
+}
@@ -0,0 +1,78 @@
+package bankid
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+func TestCheckRisk(t *testing.T) {
+	tests := []struct {
+		maxRisk string
+		risk    string
+		want    error
+	}{
+		{"", "", nil},
+		{"", "high", nil},
+		{"low", "low", nil},
+		{"low", "moderate", ErrRiskTooHigh},
+		{"moderate", "moderate", nil},
+		{"moderate", "high", ErrRiskTooHigh},
+		{"high", "high", nil},
+		{"low", "", ErrRiskMissing},
+		{"high", "", ErrRiskMissing},
+	}
+	for _, tt := range tests {
+		sc := &Connection{cfg: &config.Config{MaxRisk: tt.maxRisk}}
+		if got := sc.checkRisk(tt.risk); got != tt.want {
+			t.Errorf("maxRisk %q, risk %q: got %v, want %v", tt.maxRisk, tt.risk, got, tt.want)
+		}
+	}
+}
+
+// TestMaxRiskAPIVersion checks that maxRisk is refused with an API version not returning the risk
+// indicator
+func TestMaxRiskAPIVersion(t *testing.T) {
+	tests := []struct {
+		config  string
+		wantErr bool
+	}{
+		{`"environment": "test"`, true},
+		{`"environment": "test", "apiVersion": "5.1"`, true},
+		{`"serviceUrl": "https://appapi2.test.bankid.com/rp/v5.1/"`, true},
+		{`"environment": "test", "apiVersion": "6.0"`, false},
+		{`"serviceUrl": "https://appapi2.test.bankid.com/rp/v6.0/"`, false},
+	}
+	for _, tt := range tests {
+		_, err := config.FromReader(strings.NewReader(`{`+tt.config+`, "maxRisk": "low", "pollDelay": 2000, "enableLogging": false}`), "config.json")
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+// TestMaxRiskSimulated completes a simulated order with maxRisk set, the simulator returning the risk
+// indicator low when asked for it
+func TestMaxRiskSimulated(t *testing.T) {
+	cfg, err := config.FromReader(strings.NewReader(`{"environment": "simulation", "apiVersion": "6.0", "maxRisk": "low", "pollDelay": 2000, "enableLogging": false}`), "config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewFromConfig(cfg, func(string, string, string) {}, WithClock(fastClock{start: time.Now(), speedup: 1000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := sc.AuthenticateAsync(testIP).Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if res.Completion.Risk != "low" {
+		t.Errorf("risk %q, want low", res.Completion.Risk)
+	}
+}
@@ -0,0 +1,37 @@
+package bankid
+
+import (
+	"context"
+	"time"
+)
+
+// secretsTimeout bounds the time spent retrieving secrets from a SecretProvider
+const secretsTimeout = 30 * time.Second
+
+// SecretProvider retrieves the RP certificate, and the password protecting it, from an external secret
+// store such as HashiCorp Vault, AWS Secrets Manager or Azure Key Vault, so that the password does not
+// have to be stored in plain text in the config file. See NewWithSecrets
+type SecretProvider interface {
+	// P12 returns the PKCS#12 data holding the RP certificate and private key. Returning nil, and no
+	// error, means that the userP12FileName of the config file is used
+	P12(ctx context.Context) ([]byte, error)
+	// P12Password returns the password of the PKCS#12 data. Returning an empty string, and no error,
+	// means that the userPrivateKeyPassword of the config file is used
+	P12Password(ctx context.Context) (string, error)
+}
+
+// StaticSecrets is a SecretProvider returning fixed values, e.g. read by the application itself at startup
+type StaticSecrets struct {
+	P12Data  []byte
+	Password string
+}
+
+// P12 implements the SecretProvider interface
+func (s StaticSecrets) P12(context.Context) ([]byte, error) {
+	return s.P12Data, nil
+}
+
+// P12Password implements the SecretProvider interface
+func (s StaticSecrets) P12Password(context.Context) (string, error) {
+	return s.Password, nil
+}
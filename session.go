@@ -0,0 +1,229 @@
+package bankid
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSessionNotFound is the error returned for a request ID without an ongoing request
+var ErrSessionNotFound = errors.New("no ongoing session with the request ID")
+
+// ErrTooManySessions is the error reported for requests refused because maxSessions requests are
+// already ongoing, see the config file setting of the same name
+var ErrTooManySessions = errors.New("too many ongoing requests, new orders are refused")
+
+// ErrDuplicateRequestID is the error reported for requests refused because a request with the same
+// request ID, see WithRequestID, is already ongoing
+var ErrDuplicateRequestID = errors.New("a request with the request ID is already ongoing")
+
+// SessionStatus is the state of an ongoing request, see Status
+type SessionStatus struct {
+	RequestID string
+	Status    Status   // Last status reported, empty until the order has been sent
+	HintCode  HintCode // Last hint code reported for the pending order
+	OrderRef  string   // Empty until the order has been sent
+	Elapsed   time.Duration
+	QRActive  bool // Animated QR codes are being generated
+}
+
+// SessionInfo describes an ongoing request, see ActiveSessions
+type SessionInfo struct {
+	SessionStatus
+	Type      string // "auth" or "sign"
+	EndUserIP string
+	Started   time.Time
+}
+
+// session is the lifecycle of a single request, from send until the order has ended. It is owned by
+// the go routine handling the request, which alone changes its state and stops its QR code generator.
+// Other go routines only read its state, and ask the owner to cancel by closing the cancel channel
+type session struct {
+	requestID string
+	req       *request
+	started   time.Time
+	ctx       context.Context    // Passed to the call back functions, see SetEventHandlerContext
+	endCtx    context.CancelFunc // Ends ctx, once the request has been cancelled or its last event delivered
+	cancel    chan struct{}      // Closed, once, to have the owner cancel the order
+	cancelled sync.Once
+	done      chan struct{} // Closed when the session has ended
+	qrStop    func()        // Stops the QR code generator and waits for it; only touched by the owner
+	events    *eventQueue   // Delivers the events of the session, nil if delivered from the owner
+	removed   sync.Once
+
+	personalNumber string // Set, under the lock of the sessions, once claimed by the session
+
+	mu             sync.Mutex // Guards the fields below, set by the owner and read by others
+	orderRef       string
+	autoStartToken string
+	animatedQR     bool
+	qrActive       bool
+	status         Status
+	hint           HintCode
+	cancelErr      error // Why the session was cancelled, if not at the request of the caller
+}
+
+func newSession(r *request, now time.Time) *session {
+	s := &session{requestID: r.requestID, req: r, started: now, cancel: make(chan struct{}), done: make(chan struct{})}
+	s.ctx, s.endCtx = requestContext(r.ctx, r.requestID)
+	return s
+}
+
+// requestCancel asks the owner of the session to cancel the order, and ends the context of the
+// request. Safe to call any number of times, from any go routine, also after the session has ended
+func (s *session) requestCancel() {
+	s.cancelled.Do(func() { close(s.cancel) })
+	s.endCtx()
+}
+
+// cancelWith asks the owner of the session to cancel the order and end the request with err. Returns
+// false if the session has already been cancelled with an error
+func (s *session) cancelWith(err error) bool {
+	s.mu.Lock()
+	first := s.cancelErr == nil
+	if first {
+		s.cancelErr = err
+	}
+	s.mu.Unlock()
+	s.requestCancel()
+	return first
+}
+
+// cancelReason returns the error the session was cancelled with, nil if cancelled by the caller
+func (s *session) cancelReason() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancelErr
+}
+
+// isCancelled tells whether cancelling the session has been requested
+func (s *session) isCancelled() bool {
+	select {
+	case <-s.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// setOrder records the order started at the BankID server for the session
+func (s *session) setOrder(orderRef, autoStartToken string, animatedQR bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orderRef, s.autoStartToken, s.animatedQR = orderRef, autoStartToken, animatedQR
+}
+
+// order returns the order reference and autostart token of the session, empty until the order has been
+// started, and whether animated QR codes are used
+func (s *session) order() (orderRef, autoStartToken string, animatedQR bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orderRef, s.autoStartToken, s.animatedQR
+}
+
+// setStatus records the last status update of the session
+func (s *session) setStatus(status Status, hint HintCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.hint = status, hint
+}
+
+// startQR records the QR code generator of the session, stopped with stop. Only called by the owner
+func (s *session) startQR(stop func()) {
+	s.qrStop = stop
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.qrActive = stop != nil
+}
+
+// stopQR stops the QR code generator of the session, if running, so that no QR code is passed on
+// after the final status. Only called by the owner
+func (s *session) stopQR() {
+	if s.qrStop != nil {
+		s.qrStop()
+		s.qrStop = nil
+		s.mu.Lock()
+		s.qrActive = false
+		s.mu.Unlock()
+	}
+}
+
+// snapshot returns the state of the session at now
+func (s *session) snapshot(now time.Time) SessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionStatus{RequestID: s.requestID, Status: s.status, HintCode: s.hint, OrderRef: s.orderRef, Elapsed: now.Sub(s.started), QRActive: s.qrActive}
+}
+
+// addSession registers s. Returns ErrDuplicateRequestID if a request with the same request ID is
+// ongoing, or ErrTooManySessions if maxSessions requests are, without registering s
+func (sc *Connection) addSession(s *session) error {
+	sc.sessMu.Lock()
+	defer sc.sessMu.Unlock()
+	if _, ok := sc.sessions[s.requestID]; ok {
+		return ErrDuplicateRequestID
+	}
+	if sc.cfg.MaxSessions > 0 && len(sc.sessions) >= sc.cfg.MaxSessions {
+		return ErrTooManySessions
+	}
+	sc.sessions[s.requestID] = s
+	atomic.AddInt64(&sc.stats.channels, 1)
+	return nil
+}
+
+// removeSession unregisters s and releases its personal number to any request queued for it. Only
+// the first call has any effect
+func (sc *Connection) removeSession(s *session) {
+	s.removed.Do(func() {
+		sc.sessMu.Lock()
+		defer sc.sessMu.Unlock()
+		delete(sc.sessions, s.requestID)
+		if s.personalNumber != "" && sc.byPersonalNumber[s.personalNumber] == s {
+			delete(sc.byPersonalNumber, s.personalNumber)
+		}
+		close(s.done)
+		atomic.AddInt64(&sc.stats.channels, -1)
+	})
+}
+
+// session returns the ongoing session of requestID, or nil
+func (sc *Connection) session(requestID string) *session {
+	sc.sessMu.Lock()
+	defer sc.sessMu.Unlock()
+	return sc.sessions[requestID]
+}
+
+// Status returns the current state of the ongoing request requestID, e.g. for an HTTP layer to answer
+// where a transaction is without keeping its own copy of the status updates. Returns
+// ErrSessionNotFound once the request has ended
+func (sc *Connection) Status(requestID string) (SessionStatus, error) {
+	s := sc.session(requestID)
+	if s == nil {
+		return SessionStatus{}, ErrSessionNotFound
+	}
+	return s.snapshot(sc.clock.Now()), nil
+}
+
+// ActiveSessions returns all ongoing requests, the oldest first, e.g. for admin dashboards, or to wait
+// for the requests to end before shutting down
+func (sc *Connection) ActiveSessions() []SessionInfo {
+	sc.sessMu.Lock()
+	ss := make([]*session, 0, len(sc.sessions))
+	for _, s := range sc.sessions {
+		ss = append(ss, s)
+	}
+	sc.sessMu.Unlock()
+	infos := make([]SessionInfo, len(ss))
+	now := sc.clock.Now()
+	for i, s := range ss {
+		infos[i] = SessionInfo{SessionStatus: s.snapshot(now), Type: "auth", EndUserIP: s.req.endUserIP, Started: s.started}
+		if s.req.sign {
+			infos[i].Type = "sign"
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos
+}
@@ -0,0 +1,155 @@
+package bankid
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+// SetupCheck is the outcome of one of the checks of VerifySetup
+type SetupCheck struct {
+	Name    string `json:"name"` // "config", "clientCertificate", "caCertificate", "environment", "tlsVerification" or "serviceUrl"
+	OK      bool   `json:"ok"`
+	Warning bool   `json:"warning,omitempty"` // Passed, but needs attention, e.g. a certificate about to expire
+	Detail  string `json:"detail"`
+}
+
+// SetupReport is the result of VerifySetup
+type SetupReport struct {
+	OK     bool         `json:"ok"` // All checks passed
+	Checks []SetupCheck `json:"checks"`
+}
+
+func (r *SetupReport) add(name string, ok bool, detail string) {
+	r.Checks = append(r.Checks, SetupCheck{Name: name, OK: ok, Detail: detail})
+	if !ok {
+		r.OK = false
+	}
+}
+
+func (r *SetupReport) warn(name, detail string) {
+	r.Checks = append(r.Checks, SetupCheck{Name: name, OK: true, Warning: true, Detail: detail})
+}
+
+// String lists the checks, one per line, for operators
+func (r *SetupReport) String() string {
+	var b strings.Builder
+	for _, c := range r.Checks {
+		mark := "ok  "
+		if !c.OK {
+			mark = "FAIL"
+		} else if c.Warning {
+			mark = "warn"
+		}
+		fmt.Fprintf(&b, "%s %-18s %s\n", mark, c.Name, c.Detail)
+	}
+	return b.String()
+}
+
+// VerifySetup checks the config file, the RP client certificate, the CA certificate, that the server
+// certificate is verified, and that the BankID server can be reached with them, without starting any
+// order. It is meant for validating a deployment, e.g. of a new RP certificate, before going live.
+// The certificate and its password may come from the secret provider sp, which may be nil. Checks
+// that depend on a failed one are left out
+func VerifySetup(ctx context.Context, configFileName string, sp SecretProvider) *SetupReport {
+	r := &SetupReport{OK: true}
+	cfg, err := config.New(configFileName)
+	if err != nil {
+		r.add("config", false, err.Error())
+		return r
+	}
+	r.add("config", true, "service URL "+cfg.ServiceURL)
+
+	var cc clientCert
+	cert, err := loadClientCertificate(cfg, sp)
+	if err != nil {
+		r.add("clientCertificate", false, err.Error())
+		return r
+	}
+	cc.set(cert)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		r.add("clientCertificate", false, err.Error())
+		return r
+	}
+	now := time.Now()
+	certDetail := fmt.Sprintf("%q issued by %q, valid %s to %s", leaf.Subject.CommonName, leaf.Issuer.CommonName,
+		leaf.NotBefore.Format(time.RFC3339), leaf.NotAfter.Format(time.RFC3339))
+	switch {
+	case now.Before(leaf.NotBefore):
+		r.add("clientCertificate", false, "not yet valid: "+certDetail)
+	case now.After(leaf.NotAfter):
+		r.add("clientCertificate", false, "expired: "+certDetail)
+	case cfg.CertExpiryMinDays > 0 && leaf.NotAfter.Sub(now) < time.Duration(cfg.CertExpiryMinDays)*24*time.Hour:
+		r.warn("clientCertificate", "within certExpiryMinDays of expiry: "+certDetail)
+	default:
+		r.add("clientCertificate", true, certDetail)
+	}
+	// Test RP certificates are issued by test CAs, named as such
+	testCert := strings.Contains(strings.ToLower(leaf.Issuer.CommonName), "test")
+	switch {
+	case cfg.Environment == config.EnvironmentProduction && testCert:
+		r.add("environment", false, "a test RP certificate is used in production")
+	case cfg.Environment == config.EnvironmentTest && !testCert:
+		r.warn("environment", "the RP certificate does not seem to be a test certificate")
+	}
+
+	ca, err := serverRootCA(cfg)
+	if err != nil {
+		r.add("caCertificate", false, err.Error())
+		return r
+	}
+	block, _ := pem.Decode(ca)
+	if block == nil {
+		r.add("caCertificate", false, "no PEM encoded certificate found")
+		return r
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		r.add("caCertificate", false, err.Error())
+		return r
+	}
+	caDetail := fmt.Sprintf("%q, valid to %s", caCert.Subject.CommonName, caCert.NotAfter.Format(time.RFC3339))
+	switch {
+	case !caCert.IsCA:
+		r.add("caCertificate", false, "not a CA certificate: "+caDetail)
+	case now.After(caCert.NotAfter):
+		r.add("caCertificate", false, "expired: "+caDetail)
+	default:
+		r.add("caCertificate", true, caDetail)
+	}
+
+	tlsCfg, err := getTLSConfig(cfg, &cc)
+	if err == nil {
+		err = verifyTLSConfig(tlsCfg)
+	}
+	if err != nil {
+		r.add("tlsVerification", false, err.Error())
+		return r
+	}
+	r.add("tlsVerification", true, fmt.Sprintf("TLS %s or later, server certificate verified against the CA certificate", cfg.HTTPClientConfig.TLS.MinVersion))
+
+	cl, err := getHTTPClient(cfg, &stats{}, &cc)
+	if err != nil {
+		r.add("serviceUrl", false, err.Error())
+		return r
+	}
+	defer cl.CloseIdleConnections()
+	h := ping(ctx, cfg, cl)
+	switch {
+	case h.Healthy:
+		r.add("serviceUrl", true, fmt.Sprintf("answered in %v", h.Latency.Round(time.Millisecond)))
+	case !h.DNS:
+		r.add("serviceUrl", false, "host name not resolved: "+h.Err.Error())
+	case !h.TLS:
+		r.add("serviceUrl", false, "TLS handshake failed, the server is not trusted by the CA certificate or refused the RP certificate: "+h.Err.Error())
+	default:
+		r.add("serviceUrl", false, h.Err.Error())
+	}
+	return r
+}
@@ -0,0 +1,170 @@
+package bankid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hossner/bankid/config"
+	"github.com/rs/xid"
+)
+
+// simulator answers the calls to the BankID server locally, as the transport of connections with
+// environment "simulation". Every order goes through the hint codes a real one would, with the delays
+// and the outcome of the scenario of its personal number, or else of the simulation section of the
+// config file
+type simulator struct {
+	cfg       *config.Config
+	now       func() time.Time
+	scenarios map[string]config.Scenario // By personal number
+	mu        sync.Mutex
+	orders    map[string]*simOrder // By order reference
+}
+
+// simOrder is an ongoing simulated order
+type simOrder struct {
+	started        time.Time
+	personalNumber string
+	endUserIP      string
+	returnRisk     bool
+	scenario       config.Scenario
+}
+
+func newSimulator(cfg *config.Config, now func() time.Time) (*simulator, error) {
+	scenarios, err := cfg.SimulationScenarios()
+	if err != nil {
+		return nil, err
+	}
+	return &simulator{cfg: cfg, now: now, scenarios: scenarios, orders: make(map[string]*simOrder)}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (sim *simulator) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body struct {
+		OrderRef       string `json:"orderRef"`
+		PersonalNumber string `json:"personalNumber"`
+		EndUserIP      string `json:"endUserIp"`
+		ReturnRisk     bool   `json:"returnRisk"`
+	}
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			return simResponse(req, http.StatusBadRequest, serverError{ErrorCode: "invalidParameters", Details: "Invalid JSON"}), nil
+		}
+	}
+	switch path.Base(req.URL.Path) {
+	case "auth", "sign":
+		return simResponse(req, http.StatusOK, sim.start(body.PersonalNumber, body.EndUserIP, body.ReturnRisk)), nil
+	case "collect":
+		sim.mu.Lock()
+		o, ok := sim.orders[body.OrderRef]
+		sim.mu.Unlock()
+		if !ok {
+			return simResponse(req, http.StatusBadRequest, serverError{ErrorCode: "invalidParameters", Details: "No such order"}), nil
+		}
+		resp := sim.collect(body.OrderRef, o)
+		if resp.Status != string(StatusPending) {
+			sim.end(body.OrderRef)
+		}
+		return simResponse(req, http.StatusOK, resp), nil
+	case "cancel":
+		if !sim.end(body.OrderRef) {
+			return simResponse(req, http.StatusBadRequest, serverError{ErrorCode: "invalidParameters", Details: "No such order"}), nil
+		}
+		return simResponse(req, http.StatusOK, struct{}{}), nil
+	}
+	return simResponse(req, http.StatusNotFound, serverError{ErrorCode: "notFound", Details: "No such endpoint"}), nil
+}
+
+// start creates a simulated order
+func (sim *simulator) start(personalNumber, endUserIP string, returnRisk bool) serverResponse {
+	ref := xid.New().String()
+	scenario, ok := sim.scenarios[personalNumber]
+	if !ok {
+		scenario = config.Scenario{Outcome: sim.cfg.Simulation.Outcome, StartDelay: sim.cfg.Simulation.StartDelay, SignDelay: sim.cfg.Simulation.SignDelay}
+	}
+	sim.mu.Lock()
+	sim.orders[ref] = &simOrder{started: sim.now(), personalNumber: personalNumber, endUserIP: endUserIP, returnRisk: returnRisk, scenario: scenario}
+	sim.mu.Unlock()
+	return serverResponse{OrderRef: ref, AutoStartToken: randomHex(16), QRStartToken: randomHex(16), QRStartSecret: randomHex(16)}
+}
+
+// end removes an order, reporting whether it existed
+func (sim *simulator) end(orderRef string) bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	_, ok := sim.orders[orderRef]
+	delete(sim.orders, orderRef)
+	return ok
+}
+
+// collect returns the state of the order at the current time
+func (sim *simulator) collect(orderRef string, o *simOrder) serverResponse {
+	sc, s := sim.cfg.Simulation, o.scenario
+	elapsed := sim.now().Sub(o.started)
+	// The user never starts the app of orders ending with expiredTransaction or startFailed
+	neverStarted := s.Outcome == "expiredTransaction" || s.Outcome == "startFailed"
+	resp := serverResponse{OrderRef: orderRef, Status: string(StatusPending)}
+	switch {
+	case elapsed < time.Duration(s.StartDelay)*time.Millisecond:
+		resp.HintCode = HintCode("outstandingTransaction")
+	case elapsed < time.Duration(s.StartDelay+s.SignDelay)*time.Millisecond:
+		resp.HintCode = HintCode("userSign")
+		if neverStarted {
+			resp.HintCode = HintCode("outstandingTransaction")
+		}
+	case s.Outcome != "complete":
+		resp.Status, resp.HintCode = string(StatusFailed), HintCode(s.Outcome)
+	default:
+		resp.Status = string(StatusComplete)
+		cd := &resp.CompletionData
+		cd.User.PersonalNumber, cd.User.Name, cd.User.GivenName, cd.User.Surname = sc.User.PersonalNumber, sc.User.Name, sc.User.GivenName, sc.User.Surname
+		if o.personalNumber != "" && o.personalNumber != sc.User.PersonalNumber {
+			cd.User.PersonalNumber = o.personalNumber
+		}
+		cd.Device.IPAddress = o.endUserIP
+		if o.returnRisk {
+			cd.Risk = "low"
+		}
+		now := sim.now()
+		cd.Cert.NotBefore = strconv.FormatInt(now.AddDate(-1, 0, 0).UnixMilli(), 10)
+		cd.Cert.NotAfter = strconv.FormatInt(now.AddDate(1, 0, 0).UnixMilli(), 10)
+		cd.Signature = base64.StdEncoding.EncodeToString([]byte("<simulated/>"))
+		cd.OCSPResponse = base64.StdEncoding.EncodeToString([]byte("simulated"))
+	}
+	return resp
+}
+
+// simResponse returns an HTTP response with v as JSON body
+func simResponse(req *http.Request, code int, v interface{}) *http.Response {
+	body, _ := json.Marshal(v)
+	return &http.Response{
+		Status:        strconv.Itoa(code) + " " + http.StatusText(code),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,58 @@
+package bankid
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxUserVisibleData  = 40000
+	softUserVisibleData = maxUserVisibleData * 8 / 10 // Warn above 80 % of the limit
+)
+
+// CheckUserVisibleData returns warnings for a Base64 encoded userVisibleData that will be accepted by
+// the BankID server, but risks being truncated or poorly displayed in the BankID app. The same warnings
+// are logged when a request is sent; call it directly to catch problematic texts in tests or QA
+func CheckUserVisibleData(userVisibleData string) []string {
+	var warnings []string
+	if l := len(userVisibleData); l > softUserVisibleData {
+		warnings = append(warnings, "userVisibleData is "+strconv.Itoa(l)+" characters, close to the limit of "+strconv.Itoa(maxUserVisibleData))
+	}
+	raw, err := base64.StdEncoding.DecodeString(userVisibleData)
+	if err != nil {
+		return append(warnings, "userVisibleData is not valid Base64")
+	}
+	text := string(raw)
+	if strings.TrimSpace(text) == "" {
+		return append(warnings, "userVisibleData contains no visible text")
+	}
+	var control, format, outsideBMP, invalid bool
+	for _, r := range text {
+		switch {
+		case r == unicode.ReplacementChar:
+			invalid = true
+		case r == '\n':
+		case unicode.IsControl(r):
+			control = true
+		case unicode.Is(unicode.Cf, r):
+			format = true
+		case r > 0xFFFF:
+			outsideBMP = true
+		}
+	}
+	if invalid {
+		warnings = append(warnings, "userVisibleData is not valid UTF-8")
+	}
+	if control {
+		warnings = append(warnings, "userVisibleData contains control characters, only LF is rendered as a line break")
+	}
+	if format {
+		warnings = append(warnings, "userVisibleData contains invisible formatting characters")
+	}
+	if outsideBMP {
+		warnings = append(warnings, "userVisibleData contains emoji or other characters the BankID app may not render")
+	}
+	return warnings
+}
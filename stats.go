@@ -0,0 +1,113 @@
+package bankid
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds counters describing a Connection, see Connection.Stats
+type Stats struct {
+	OpenConnections  int64 // Network connections currently open to the BankID server
+	TotalConnections int64 // Network connections opened since New
+	IdleReaps        int64 // Times idle connections have been closed by the reaper
+	SessionReaps     int64 // Runs of the session reaper, see sessionReapInterval
+	SessionsExpired  int64 // Requests cancelled by the session reaper for outliving the validity of orders
+	SessionsRemoved  int64 // Sessions removed by the session reaper after their request failed to end
+
+	// Resources of the package, which should return to their baseline once all requests have ended
+	Goroutines        int64 // Go routines currently running
+	GoroutinesStarted int64 // Go routines started since New
+	OpenChannels      int64 // Channels of requests and QR code generators currently in use
+	ActiveTickers     int64 // Tickers currently running
+}
+
+// stats holds the live counters of a Connection
+type stats struct {
+	openConns  int64
+	totalConns int64
+	idleReaps  int64
+	sessReaps  int64
+	sessExpire int64
+	sessRemove int64
+	goroutines int64
+	goStarted  int64
+	channels   int64
+	tickers    int64
+}
+
+// goStart accounts for a go routine being started, goStop for it returning
+func (st *stats) goStart() {
+	atomic.AddInt64(&st.goroutines, 1)
+	atomic.AddInt64(&st.goStarted, 1)
+}
+
+func (st *stats) goStop() {
+	atomic.AddInt64(&st.goroutines, -1)
+}
+
+// Stats returns a snapshot of the counters of the connection
+func (sc *Connection) Stats() Stats {
+	return Stats{
+		OpenConnections:   atomic.LoadInt64(&sc.stats.openConns),
+		TotalConnections:  atomic.LoadInt64(&sc.stats.totalConns),
+		IdleReaps:         atomic.LoadInt64(&sc.stats.idleReaps),
+		SessionReaps:      atomic.LoadInt64(&sc.stats.sessReaps),
+		SessionsExpired:   atomic.LoadInt64(&sc.stats.sessExpire),
+		SessionsRemoved:   atomic.LoadInt64(&sc.stats.sessRemove),
+		Goroutines:        atomic.LoadInt64(&sc.stats.goroutines),
+		GoroutinesStarted: atomic.LoadInt64(&sc.stats.goStarted),
+		OpenChannels:      atomic.LoadInt64(&sc.stats.channels),
+		ActiveTickers:     atomic.LoadInt64(&sc.stats.tickers),
+	}
+}
+
+// countingConn decrements the number of open connections when closed
+type countingConn struct {
+	net.Conn
+	st   *stats
+	once sync.Once
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.st.openConns, -1) })
+	return c.Conn.Close()
+}
+
+// countingDialer returns a DialContext function keeping track of the connections opened
+func countingDialer(st *stats) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&st.openConns, 1)
+		atomic.AddInt64(&st.totalConns, 1)
+		return &countingConn{Conn: c, st: st}, nil
+	}
+}
+
+// reapIdleConnections closes the idle connections of tr at every interval, until quit is closed. This
+// keeps half-open connections, left behind by network resets on the BankID side, from being reused
+func reapIdleConnections(tr *http.Transport, interval time.Duration, st *stats, quit chan struct{}) {
+	defer st.goStop()
+	ticker := time.NewTicker(interval)
+	atomic.AddInt64(&st.tickers, 1)
+	defer func() {
+		ticker.Stop()
+		atomic.AddInt64(&st.tickers, -1)
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			tr.CloseIdleConnections()
+			atomic.AddInt64(&st.idleReaps, 1)
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package bankid
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hossner/bankid/personnummer"
+	"github.com/rs/xid"
+)
+
+// ErrStepUpMismatch is the error of a step-up authentication completed by another user than the one
+// of the existing session
+var ErrStepUpMismatch = errors.New("step-up authentication completed by another user")
+
+// StepUpResult is the outcome of a step-up authentication, see StepUp
+type StepUpResult struct {
+	RequestID  string
+	Status     Status
+	HintCode   HintCode        // Set when Status is StatusFailed
+	Completion *CompletionData // Set when Status is StatusComplete
+	Verified   bool            // The authentication completed, by the user of the existing session
+	Err        error
+}
+
+// StepUp starts a fresh authentication of the user of an existing session, identified by the
+// personal number, to confirm a sensitive action. The order is restricted to that personal number
+// and requires the PIN code, not biometrics. The result is sent on the returned channel once the
+// order has ended, with Verified set only if it completed with the same personal number. The order
+// is reported to the call back functions as usual. Requires personalNumberPolicy "allow" or "require"
+func (sc *Connection) StepUp(endUserIP, personalNumber string, opts ...RequestOption) (string, <-chan StepUpResult) {
+	res := make(chan StepUpResult, 1)
+	r := newRequest(endUserIP, false, "", opts)
+	if r.requestID == "" {
+		r.requestID = xid.New().String()
+	}
+	var req Requirements
+	if r.requirements != nil {
+		req = *r.requirements
+	}
+	req.PersonalNumber, req.PinCode, req.AllowFingerprint = personalNumber, true, false
+	r.requirements = &req
+	want, err := personnummer.Normalize(personalNumber, time.Now())
+	if err != nil {
+		res <- StepUpResult{RequestID: r.requestID, Status: StatusError, Err: err}
+		return r.requestID, res
+	}
+	observer := func(ev Event) {
+		if !ev.Status.final() {
+			return
+		}
+		sc.unobserve(ev.RequestID)
+		sur := StepUpResult{RequestID: ev.RequestID, Status: ev.Status, HintCode: ev.HintCode, Completion: ev.Completion, Err: ev.Err}
+		if ev.Status == StatusComplete {
+			if ev.Completion.User.PersonalNumber == want {
+				sur.Verified = true
+			} else {
+				sur.Err = ErrStepUpMismatch
+			}
+		}
+		res <- sur
+	}
+	if err := sc.start(r, func() { sc.observe(r.requestID, observer) }); err != nil {
+		res <- StepUpResult{RequestID: r.requestID, Status: StatusError, Err: err}
+	}
+	return r.requestID, res
+}
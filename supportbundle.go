@@ -0,0 +1,156 @@
+package bankid
+
+import (
+	"encoding/json"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	maxTraceEntries   = 300 // Per request
+	maxTracedRequests = 100
+)
+
+// traceEntry is one exchange with the BankID server, or a failed validation, for a request
+type traceEntry struct {
+	Time       time.Time `json:"time"`
+	Endpoint   string    `json:"endpoint"`
+	HTTPStatus int       `json:"httpStatus,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	HintCode   string    `json:"hintCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorBody  string    `json:"errorBody,omitempty"` // Raw body of non 200 responses
+}
+
+// requestTrace is the poll trace of a single request
+type requestTrace struct {
+	RequestID string       `json:"requestId"`
+	Type      string       `json:"type"`
+	Started   time.Time    `json:"started"`
+	Entries   []traceEntry `json:"entries"`
+	Truncated bool         `json:"truncated,omitempty"`
+}
+
+// tracer keeps the poll traces of the most recent requests
+type tracer struct {
+	mu     sync.Mutex
+	traces map[string]*requestTrace
+	order  []string
+}
+
+func newTracer() *tracer {
+	return &tracer{traces: make(map[string]*requestTrace)}
+}
+
+// start begins a new trace for requestID, dropping the oldest trace if needed
+func (t *tracer) start(requestID, reqType string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.traces[requestID]; !ok {
+		t.order = append(t.order, requestID)
+	}
+	t.traces[requestID] = &requestTrace{RequestID: requestID, Type: reqType, Started: time.Now()}
+	if len(t.order) > maxTracedRequests {
+		delete(t.traces, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// record adds the outcome of a call to endpoint to the trace of requestID
+func (t *tracer) record(requestID, endpoint string, code int, body []byte, err error) {
+	e := traceEntry{Time: time.Now(), Endpoint: endpoint, HTTPStatus: code}
+	switch {
+	case err != nil:
+		e.Error = err.Error()
+	case code != 200:
+		e.ErrorBody = string(body)
+	default:
+		var sr struct {
+			Status   string `json:"status"`
+			HintCode string `json:"hintCode"`
+		}
+		if json.Unmarshal(body, &sr) == nil {
+			e.Status, e.HintCode = sr.Status, sr.HintCode
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr, ok := t.traces[requestID]
+	if !ok {
+		return
+	}
+	if len(tr.Entries) >= maxTraceEntries {
+		tr.Truncated = true
+		return
+	}
+	tr.Entries = append(tr.Entries, e)
+}
+
+// supportBundle is the JSON document returned by SupportBundle
+type supportBundle struct {
+	Generated time.Time     `json:"generated"`
+	Library   buildSummary  `json:"library"`
+	UserAgent string        `json:"userAgent"`
+	Config    configSummary `json:"config"`
+	Request   requestTrace  `json:"request"`
+}
+
+type buildSummary struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// configSummary holds the non-secret parts of the configuration
+type configSummary struct {
+	ServiceURL           string         `json:"serviceUrl"`
+	PollDelay            int            `json:"pollDelay"`
+	PollDelays           map[string]int `json:"pollDelays,omitempty"`
+	PersonalNumberPolicy string         `json:"personalNumberPolicy"`
+	LogLevel             int            `json:"logLevel"`
+	CACertFileName       string         `json:"caCertFileName"`
+	UserP12FileName      string         `json:"userP12FileName"`
+	PasswordSet          bool           `json:"userPrivateKeyPasswordSet"`
+}
+
+// SupportBundle returns a JSON document describing a request, to attach when filing an issue or a
+// ticket with the bank. It holds a summary of the configuration with all secrets left out, the
+// library version, and the trace of calls to the BankID server, including the raw body of error
+// responses. Personal numbers, names and tokens are never part of the bundle. Traces are kept for
+// the most recent requests only
+func (sc *Connection) SupportBundle(requestID string) ([]byte, error) {
+	sc.tracer.mu.Lock()
+	tr, ok := sc.tracer.traces[requestID]
+	var trc requestTrace
+	if ok {
+		trc = *tr
+		trc.Entries = append([]traceEntry(nil), tr.Entries...)
+	}
+	sc.tracer.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no trace for the provided request ID")
+	}
+	sb := supportBundle{
+		Generated: time.Now(),
+		Library: buildSummary{
+			Version:   version,
+			GoVersion: runtime.Version(),
+			Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		},
+		UserAgent: requestUserAgent(sc.cfg),
+		Config: configSummary{
+			ServiceURL:           sc.cfg.ServiceURL,
+			PollDelay:            sc.cfg.PollDelay,
+			PollDelays:           sc.cfg.PollDelays,
+			PersonalNumberPolicy: sc.cfg.PersonalNumberPolicy,
+			LogLevel:             sc.cfg.LogLevel,
+			CACertFileName:       sc.cfg.CertStore.CACertFileName,
+			UserP12FileName:      sc.cfg.CertStore.UserP12FileName,
+			PasswordSet:          sc.cfg.CertStore.UserPrivateKeyPassword != "",
+		},
+		Request: trc,
+	}
+	return json.MarshalIndent(sb, "", "  ")
+}
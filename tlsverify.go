@@ -0,0 +1,27 @@
+package bankid
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+// verifyTLSConfig checks that tlsCfg, the TLS configuration of the calls to the BankID server, verifies
+// the server: its certificate is verified, against the BankID CA certificate rather than those of the
+// system, and TLS versions before 1.2 are refused. The rest of the verification, refusing unknown
+// CAs, expired certificates and other hosts, is that of crypto/tls, which the configuration does not
+// override
+func verifyTLSConfig(tlsCfg *tls.Config) error {
+	if tlsCfg.InsecureSkipVerify {
+		return errors.New("the server certificate is not verified")
+	}
+	if tlsCfg.VerifyPeerCertificate != nil || tlsCfg.VerifyConnection != nil {
+		return errors.New("the verification of the server certificate is overridden")
+	}
+	if tlsCfg.MinVersion < tls.VersionTLS12 {
+		return errors.New("TLS versions before 1.2 are allowed")
+	}
+	if tlsCfg.RootCAs == nil {
+		return errors.New("the CA certificates of the system are trusted instead of the BankID CA certificate")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package bankid
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hossner/bankid/config"
+)
+
+const testServerName = "appapi2.test.bankid.com"
+
+// testTLSConfig returns the TLS configuration of the calls to the BankID test server, with the bundled
+// test CA certificate
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	cfg, err := config.FromReader(strings.NewReader(`{"serviceUrl": "https://`+testServerName+`/rp/v6.0/", "pollDelay": 2000, "enableLogging": false}`), "config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg, err := getTLSConfig(cfg, &clientCert{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tlsCfg
+}
+
+func TestTLSConfigBundledCA(t *testing.T) {
+	tlsCfg := testTLSConfig(t)
+	if err := verifyTLSConfig(tlsCfg); err != nil {
+		t.Fatalf("verifyTLSConfig: %v", err)
+	}
+	block, _ := pem.Decode(bundledRootCAs[config.EnvironmentTest])
+	if block == nil {
+		t.Fatal("no bundled test CA certificate")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := x509.NewCertPool()
+	want.AddCert(ca)
+	if !tlsCfg.RootCAs.Equal(want) {
+		t.Error("the bundled test CA certificate is not the only one trusted")
+	}
+	if tlsCfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x, want %x", tlsCfg.MinVersion, tls.VersionTLS12)
+	}
+}
+
+func TestTLSConfigVerifiesServer(t *testing.T) {
+	trusted, untrusted := newTestCA(t, "trusted"), newTestCA(t, "untrusted")
+	// The configuration of getTLSConfig, trusting a test CA instead of the BankID one, for which there is
+	// no key to issue server certificates with
+	withCA := testTLSConfig(t).Clone()
+	withCA.RootCAs = x509.NewCertPool()
+	withCA.RootCAs.AddCert(trusted.cert)
+
+	tests := []struct {
+		name     string
+		tlsCfg   *tls.Config
+		ca       *testCA
+		host     string
+		validFor time.Duration
+		wantErr  interface{} // Pointer to the x509 error type expected, nil if the handshake must succeed
+	}{
+		{"valid", withCA, trusted, testServerName, time.Hour, nil},
+		{"wrong CA", withCA, untrusted, testServerName, time.Hour, new(x509.UnknownAuthorityError)},
+		{"wrong CA, bundled CA trusted", testTLSConfig(t), trusted, testServerName, time.Hour, new(x509.UnknownAuthorityError)},
+		{"wrong hostname", withCA, trusted, "other.invalid", time.Hour, new(x509.HostnameError)},
+		{"expired leaf", withCA, trusted, testServerName, -time.Hour, new(x509.CertificateInvalidError)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tlsHandshake(t, tt.tlsCfg, tt.ca, tt.host, tt.validFor)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("handshake failed: %v", err)
+			case tt.wantErr == nil:
+			case err == nil:
+				t.Fatal("handshake succeeded")
+			case !errors.As(err, tt.wantErr):
+				t.Fatalf("handshake failed with %v, want %T", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyTLSConfigRefuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*tls.Config)
+	}{
+		{"no verification", func(c *tls.Config) { c.InsecureSkipVerify = true }},
+		{"custom verification", func(c *tls.Config) {
+			c.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error { return nil }
+		}},
+		{"TLS 1.1", func(c *tls.Config) { c.MinVersion = tls.VersionTLS11 }},
+		{"system CAs", func(c *tls.Config) { c.RootCAs = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCfg := testTLSConfig(t)
+			tt.modify(tlsCfg)
+			if err := verifyTLSConfig(tlsCfg); err == nil {
+				t.Error("verifyTLSConfig accepted the configuration")
+			}
+		})
+	}
+}
+
+// tlsHandshake makes a TLS handshake with clientCfg for testServerName, with a server presenting a
+// certificate issued by ca for host, valid for validFor from now, or expired that long ago if negative
+func tlsHandshake(t *testing.T, clientCfg *tls.Config, ca *testCA, host string, validFor time.Duration) error {
+	t.Helper()
+	cert := ca.issue(t, host, validFor)
+	// A loopback connection rather than net.Pipe, whose unbuffered writes would block the alert of a
+	// refusing client until the deadline
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.SetDeadline(deadline)
+		tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+		s.Close()
+	}()
+	c, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(deadline)
+	cfg := clientCfg.Clone()
+	cfg.ServerName = testServerName
+	err = tls.Client(c, cfg).Handshake()
+	c.Close()
+	<-done
+	return err
+}
+
+// testCA is a throwaway CA of server certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bankid " + name + " test CA"},
+		NotBefore:             time.Now().Add(-3 * time.Hour),
+		NotAfter:              time.Now().Add(3 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a server certificate for host, valid for validFor from now, or expired that long ago
+// if negative
+func (ca *testCA) issue(t *testing.T, host string, validFor time.Duration) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(validFor)
+	if validFor < 0 {
+		notBefore, notAfter = time.Now().Add(2*validFor), time.Now().Add(validFor)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
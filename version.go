@@ -0,0 +1,70 @@
+package bankid
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/hossner/bankid/config"
+)
+
+const (
+	modulePath         = "github.com/hossner/bankid"
+	qrAlgorithmVersion = "1" // Animated QR codes: "bankid.<qrStartToken>.<time>.<HMAC-SHA256>"
+)
+
+// supportedAPIVersions are the versions of the BankID RP API this version of the package speaks
+var supportedAPIVersions = []string{"5.1"}
+
+// BuildInformation describes the deployed version of the package, see BuildInfo
+type BuildInformation struct {
+	Version            string   // Version of the package
+	ModuleVersion      string   // Version of the module as recorded by the Go toolchain, if known
+	APIVersions        []string // Supported versions of the BankID RP API
+	QRAlgorithmVersion string   // Version of the animated QR code algorithm
+	GoVersion          string
+}
+
+// BuildInfo returns information about the deployed version of the package, so that operators and
+// BankID support can tell what is running. The same information is sent in the User-Agent header
+func BuildInfo() BuildInformation {
+	bi := BuildInformation{
+		Version:            version,
+		APIVersions:        append([]string(nil), supportedAPIVersions...),
+		QRAlgorithmVersion: qrAlgorithmVersion,
+		GoVersion:          runtime.Version(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath {
+			bi.ModuleVersion = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				bi.ModuleVersion = dep.Version
+			}
+		}
+	}
+	return bi
+}
+
+// UserAgent returns the value of the User-Agent header sent to the BankID server, e.g.
+// "hossner-bankid/0.1 (api 5.1; qr 1; go1.22.1)"
+func (bi BuildInformation) UserAgent() string {
+	v := bi.Version
+	if bi.ModuleVersion != "" && bi.ModuleVersion != "(devel)" {
+		v = bi.ModuleVersion
+	}
+	return "hossner-bankid/" + v + " (api " + strings.Join(bi.APIVersions, ",") + "; qr " + bi.QRAlgorithmVersion + "; " + bi.GoVersion + ")"
+}
+
+// userAgent is computed once, as the build information does not change at runtime
+var userAgent = BuildInfo().UserAgent()
+
+// requestUserAgent returns the User-Agent header of calls to the BankID server, with the application
+// set in httpClientConfig.userAgent first, if any, e.g. "shop/2.1 hossner-bankid/0.1 (...)"
+func requestUserAgent(cfg *config.Config) string {
+	if cfg.HTTPClientConfig.UserAgent == "" {
+		return userAgent
+	}
+	return cfg.HTTPClientConfig.UserAgent + " " + userAgent
+}
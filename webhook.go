@@ -0,0 +1,156 @@
+package bankid
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// The headers of webhook deliveries
+const (
+	WebhookIDHeader        = "X-BankID-Webhook-Id"
+	WebhookTimestampHeader = "X-BankID-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-BankID-Webhook-Signature"
+)
+
+const (
+	webhookTimeout    = 10 * time.Second
+	webhookMaxBackoff = time.Minute
+)
+
+// WebhookPayload is the JSON body POSTed to the webhook URL when a request has ended
+type WebhookPayload struct {
+	ID         string          `json:"id"` // Unique per delivery, the same in retries
+	RequestID  string          `json:"requestId"`
+	Status     Status          `json:"status"`
+	HintCode   HintCode        `json:"hintCode,omitempty"`
+	ErrorCode  string          `json:"errorCode,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Time       time.Time       `json:"time"`
+	Completion *CompletionData `json:"completion,omitempty"`
+}
+
+// webhook delivers ended requests to the URL of the webhook section of the config file
+type webhook struct {
+	url        string
+	secret     []byte
+	maxRetries int
+	client     *http.Client
+}
+
+func newWebhook(url, secret string, maxRetries int) *webhook {
+	return &webhook{url: url, secret: []byte(secret), maxRetries: maxRetries, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// deliverWebhook POSTs the ended request of ev to the webhook in the background, retrying with
+// exponential back-off. Deliveries still being retried are abandoned by Close
+func (sc *Connection) deliverWebhook(ev Event) {
+	id := xid.New().String()
+	body, err := json.Marshal(WebhookPayload{
+		ID:         id,
+		RequestID:  ev.RequestID,
+		Status:     ev.Status,
+		HintCode:   ev.HintCode,
+		ErrorCode:  ev.ErrorCode,
+		Message:    ev.Message,
+		Time:       ev.Time,
+		Completion: ev.Completion,
+	})
+	if err != nil {
+		sc.logprint(ERROR, ev.RequestID, ": could not encode webhook payload:", err.Error())
+		return
+	}
+	sc.stats.goStart()
+	go func() {
+		defer sc.stats.goStop()
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err := sc.webhook.post(id, body)
+			if err == nil {
+				return
+			}
+			if attempt >= sc.webhook.maxRetries {
+				sc.logprint(ERROR, ev.RequestID, ": webhook delivery failed, giving up:", err.Error())
+				return
+			}
+			sc.logprint(WARN, ev.RequestID, ": webhook delivery failed, retrying:", err.Error())
+			select {
+			case <-time.After(backoff):
+			case <-sc.quit:
+				return
+			}
+			if backoff *= 2; backoff > webhookMaxBackoff {
+				backoff = webhookMaxBackoff
+			}
+		}
+	}()
+}
+
+// post makes one delivery attempt, signing the body with the secret
+func (wh *webhook) post(id string, body []byte) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(WebhookIDHeader, id)
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, webhookSignature(wh.secret, ts, body))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookSignature returns the value of the signature header: the hex encoded HMAC-SHA256, keyed
+// with the secret, of the timestamp, a dot and the body
+func webhookSignature(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook delivery received by r, and that it was sent no
+// longer than maxAge ago, returning the decoded payload. For use by the receiver of the webhook
+func VerifyWebhook(r *http.Request, secret string, maxAge time.Duration) (*WebhookPayload, error) {
+	ts := r.Header.Get(WebhookTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("missing or invalid webhook timestamp")
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > maxAge || age < -maxAge {
+		return nil, errors.New("webhook timestamp out of range")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read webhook body: %v", err)
+	}
+	want := webhookSignature([]byte(secret), ts, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(WebhookSignatureHeader))) {
+		return nil, errors.New("invalid webhook signature")
+	}
+	var p WebhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("could not decode webhook payload: %v", err)
+	}
+	return &p, nil
+}
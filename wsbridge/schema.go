@@ -0,0 +1,66 @@
+package wsbridge
+
+import (
+	_ "embed" // For the JSON schema
+	"fmt"
+	"net/url"
+)
+
+// ProtocolVersion is the version of the message schema spoken by the bridge. Messages without a
+// version are of version 1, the schema of the original example application
+const ProtocolVersion = 1
+
+// The actions of messages sent by the client
+const (
+	ActionPnrAuth   = "pnrAuth" // Value holds the personal number
+	ActionQRCode    = "qrCode"
+	ActionAutoStart = "autoStart" // Value optionally holds the URL the BankID app returns the user to
+	ActionCancel    = "cancel"
+)
+
+// The actions of messages sent by the bridge, apart from the status updates of the order, which have
+// the status, or the pending hint code, as action
+const (
+	ActionSession  = "session" // Value holds the session ID
+	ActionQRImage  = "qrcode"  // Value holds a base64 encoded PNG image
+	ActionLaunch   = "launch"  // Value holds the URL launching the BankID app, for autoStart orders
+	ActionMigrated = "migrated"
+	ActionError    = "error" // Value holds the reason
+)
+
+// schema is the JSON Schema of the messages in both directions
+//
+//go:embed schema.json
+var schema []byte
+
+// Schema returns the JSON Schema (draft 2020-12) of the messages exchanged with the bridge, for
+// implementing other clients of the protocol
+func Schema() []byte {
+	return append([]byte(nil), schema...)
+}
+
+// Validate checks a message from the client against the schema. Fields not part of the schema are
+// ignored, as are the values of actions without one
+func (m Message) Validate() error {
+	if m.V < 0 || m.V > ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", m.V)
+	}
+	switch m.Action {
+	case ActionPnrAuth:
+		if m.Value == "" {
+			return fmt.Errorf("%s requires a personal number as value", m.Action)
+		}
+	case ActionAutoStart:
+		if m.Value != "" {
+			if u, err := url.Parse(m.Value); err != nil || !u.IsAbs() {
+				return fmt.Errorf("%s requires an absolute URL as value, if any", m.Action)
+			}
+		}
+	case ActionQRCode, ActionCancel:
+	case "":
+		return fmt.Errorf("no action")
+	default:
+		return fmt.Errorf("unknown action %s", m.Action)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+// Package wsbridge connects browser clients to a bankid.Connection over websockets, replacing the
+// plumbing of the example application with a supported implementation.
+//
+// Every websocket belongs to an app session. A client connecting without a session gets a new one,
+// announced in a "session" message; a client reconnecting, e.g. after a network switch or a page
+// reload, passes it back with ?session={id} and resumes the session, including an ongoing order. The
+// session IDs are 128 bit random values, as anyone holding one may take over the session. A socket
+// opened for an unknown session, e.g. one that has expired, gets an error message and is closed; the
+// client should then connect without a session. A second socket opened for a session that already has
+// one is handled according to DuplicatePolicy.
+//
+// Messages in both directions are JSON objects of the type Message, described by the JSON Schema
+// returned by Schema. The schema is versioned, see ProtocolVersion; the bridge sets the version of
+// every message it sends, and refuses messages of later versions than its own. Messages without a
+// version are of version 1, so clients of the original example application keep working. The client
+// sends:
+//
+//	{"action": "pnrAuth", "value": "{personal number}"}  starts an authentication for the personal number
+//	{"action": "qrCode"}                                 starts an authentication with animated QR codes
+//	{"action": "autoStart", "value": "{return URL}"}    starts an authentication on the same device
+//	{"action": "cancel"}                                 cancels the ongoing order
+//
+// The bridge sends the status updates of the order, with the status as action, as passed to the
+// bankid.FOnResponse call back function, and in addition:
+//
+//	{"action": "session", "value": "{session id}"}  the session of the socket, sent first
+//	{"action": "qrcode", "value": "{base64 PNG}"}   a new animated QR code
+//	{"action": "launch", "value": "{URL}"}          the URL launching the BankID app, for autoStart
+//	{"action": "migrated"}                          the session was taken over by another socket
+//	{"action": "error", "value": "{reason}"}        a message from the client could not be handled
+//
+// For autoStart the client opens the launch URL, which starts the BankID app on the same device. The
+// app returns the user to the return URL, which should carry the session ID so that the page can
+// reconnect with ?session={id} and get the outcome of the order.
+//
+// An ongoing order is cancelled when its session has had no socket for longer than DisconnectGrace.
+package wsbridge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hossner/bankid"
+	"github.com/hossner/bankid/internal/web"
+)
+
+const defaultGrace = 30 * time.Second
+
+// errUnknownSession is sent to a client resuming a session that does not exist, or no longer does
+var errUnknownSession = errors.New("unknown session")
+
+// DuplicatePolicy decides what happens when a second socket is opened for a session that already has one
+type DuplicatePolicy int
+
+// The duplicate policies
+const (
+	// Migrate moves the session, with its ongoing order, to the new socket and closes the old one
+	Migrate DuplicatePolicy = iota
+	// CancelStale cancels the ongoing order of the session, and closes the old socket
+	CancelStale
+)
+
+// Message is the JSON message sent in both directions over the websocket
+type Message struct {
+	V      int    `json:"v,omitempty"` // Protocol version, 1 if left out
+	Action string `json:"action"`
+	Value  string `json:"value,omitempty"`
+	ID     string `json:"id,omitempty"` // The session ID
+}
+
+// Bridge is an http.Handler upgrading requests to websockets connected to BankID requests
+type Bridge struct {
+	// Upgrader upgrades the HTTP requests. Set Upgrader.CheckOrigin to accept cross-origin requests
+	Upgrader websocket.Upgrader
+	// DuplicatePolicy decides what happens when a second socket is opened for a session
+	DuplicatePolicy DuplicatePolicy
+	// DisconnectGrace is how long a session may go without a socket before its ongoing order is
+	// cancelled and the session removed
+	DisconnectGrace time.Duration
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse proxies in front of the bridge.
+	// The end user IP is taken from X-Forwarded-For or X-Real-Ip only for requests from them; empty
+	// trusts no proxy
+	TrustedProxies []string
+
+	conn     *bankid.Connection
+	mu       sync.Mutex
+	sessions map[string]*session // By session ID
+	requests map[string]*session // By request ID of the ongoing order
+}
+
+// session is an app session, outliving the sockets connected to it
+type session struct {
+	id        string
+	ws        *websocket.Conn // Current socket, nil while disconnected
+	writeMu   sync.Mutex      // Serializes writes to ws, as required by the websocket package
+	requestID string          // Ongoing order, if any
+	autoStart bool            // The ongoing order is started on the same device, see ActionAutoStart
+	redirect  string          // Where the BankID app returns the user to, for autoStart
+	last      *Message        // Last status update, replayed on reconnect
+	grace     *time.Timer
+}
+
+// New returns a Bridge with a new connection to the BankID server, configured from configFileName
+func New(configFileName string) (*Bridge, error) {
+	b := &Bridge{
+		DuplicatePolicy: Migrate,
+		DisconnectGrace: defaultGrace,
+		sessions:        make(map[string]*session),
+		requests:        make(map[string]*session),
+	}
+	conn, err := bankid.New(configFileName, b.onResponse)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	return b, nil
+}
+
+// Connection returns the underlying BankID connection
+func (b *Bridge) Connection() *bankid.Connection {
+	return b.conn
+}
+
+// Close closes the underlying BankID connection
+func (b *Bridge) Close() {
+	b.conn.Close()
+}
+
+// ServeHTTP implements the http.Handler interface, upgrading the request to a websocket
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := b.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // The upgrader has replied to the client
+	}
+	s, err := b.attach(r.URL.Query().Get("session"), ws)
+	if err != nil {
+		ws.WriteJSON(Message{V: ProtocolVersion, Action: ActionError, Value: err.Error()})
+		ws.Close()
+		return
+	}
+	b.send(s, Message{Action: ActionSession, Value: s.id, ID: s.id})
+	b.mu.Lock()
+	last := s.last
+	b.mu.Unlock()
+	if last != nil {
+		b.send(s, *last)
+	}
+	b.read(s, ws, web.ClientIP(r, b.TrustedProxies))
+}
+
+// attach binds ws to the session with the ID, or to a new session if the ID is empty. Unknown
+// sessions are not resumed
+func (b *Bridge) attach(id string, ws *websocket.Conn) (*session, error) {
+	var s *session
+	if id == "" {
+		newID, err := web.NewID()
+		if err != nil {
+			return nil, err
+		}
+		s = &session{id: newID}
+	}
+	b.mu.Lock()
+	if s != nil {
+		b.sessions[s.id] = s
+	} else if s = b.sessions[id]; s == nil {
+		b.mu.Unlock()
+		return nil, errUnknownSession
+	}
+	if s.grace != nil {
+		s.grace.Stop()
+		s.grace = nil
+	}
+	old, requestID := s.ws, s.requestID
+	s.ws = ws
+	if old != nil && b.DuplicatePolicy == CancelStale {
+		s.last = nil
+	}
+	b.mu.Unlock()
+	if old != nil {
+		if b.DuplicatePolicy == CancelStale && requestID != "" {
+			b.conn.CancelRequest(requestID)
+		}
+		s.writeTo(old, Message{Action: ActionMigrated, ID: s.id})
+		old.Close()
+	}
+	return s, nil
+}
+
+// detach unbinds ws from the session, unless it has already been replaced. Once the session has had
+// no socket for DisconnectGrace its ongoing order is cancelled and the session removed
+func (b *Bridge) detach(s *session, ws *websocket.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.ws != ws {
+		return
+	}
+	s.ws = nil
+	s.grace = time.AfterFunc(b.DisconnectGrace, func() {
+		b.mu.Lock()
+		abandoned := s.ws == nil
+		requestID := s.requestID
+		if abandoned {
+			delete(b.sessions, s.id)
+		}
+		b.mu.Unlock()
+		if abandoned && requestID != "" {
+			b.conn.CancelRequest(requestID)
+		}
+	})
+}
+
+// read handles the messages from the client until the socket is closed
+func (b *Bridge) read(s *session, ws *websocket.Conn, endUserIP string) {
+	defer func() {
+		ws.Close()
+		b.detach(s, ws)
+	}()
+	for {
+		var msg Message
+		if err := ws.ReadJSON(&msg); err != nil {
+			var se *json.SyntaxError
+			if errors.As(err, &se) {
+				b.send(s, Message{Action: ActionError, Value: "could not decode message", ID: s.id})
+				continue
+			}
+			return
+		}
+		if err := msg.Validate(); err != nil {
+			b.send(s, Message{Action: ActionError, Value: err.Error(), ID: s.id})
+			continue
+		}
+		switch msg.Action {
+		case ActionPnrAuth:
+			b.start(s, endUserIP, nil, bankid.WithRequirement(&bankid.Requirements{PersonalNumber: msg.Value}))
+		case ActionQRCode:
+			b.start(s, endUserIP, nil, bankid.WithRequirement(&bankid.Requirements{TokenStartRequired: true}), bankid.WithQRCallback(b.onQRCode))
+		case ActionAutoStart:
+			redirect := msg.Value
+			b.start(s, endUserIP, &redirect, bankid.WithRequirement(&bankid.Requirements{TokenStartRequired: true}))
+		case ActionCancel:
+			b.mu.Lock()
+			requestID := s.requestID
+			b.mu.Unlock()
+			if requestID != "" {
+				b.conn.CancelRequest(requestID)
+			}
+		}
+	}
+}
+
+// start starts an authentication for the session, unless it already has an ongoing order. The order
+// is started on the same device if redirect is set, see ActionAutoStart
+func (b *Bridge) start(s *session, endUserIP string, redirect *string, opts ...bankid.RequestOption) {
+	requestID, err := web.NewID()
+	if err != nil {
+		b.send(s, Message{Action: ActionError, Value: "could not create request ID", ID: s.id})
+		return
+	}
+	b.mu.Lock()
+	if s.requestID != "" {
+		b.mu.Unlock()
+		b.send(s, Message{Action: ActionError, Value: "an order is already ongoing", ID: s.id})
+		return
+	}
+	s.requestID, s.last, s.autoStart, s.redirect = requestID, nil, redirect != nil, ""
+	if redirect != nil {
+		s.redirect = *redirect
+	}
+	b.requests[requestID] = s
+	b.mu.Unlock()
+	b.conn.Authenticate(endUserIP, append(opts, bankid.WithRequestID(requestID))...)
+}
+
+// onResponse is the call back function registered with the BankID connection
+func (b *Bridge) onResponse(requestID, status, message string) {
+	b.mu.Lock()
+	s, ok := b.requests[requestID]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	msg := Message{Action: status, Value: message, ID: s.id}
+	s.last = &msg
+	var launch *Message
+	if status == string(bankid.StatusSent) && s.autoStart {
+		launch = &Message{Action: ActionLaunch, Value: bankid.AutoStartURL(message, s.redirect), ID: s.id}
+	}
+	if web.IsFinal(status, message) {
+		delete(b.requests, requestID)
+		s.requestID, s.autoStart, s.redirect = "", false, ""
+	}
+	b.mu.Unlock()
+	b.send(s, msg)
+	if launch != nil {
+		b.send(s, *launch)
+	}
+}
+
+// onQRCode is the QR code call back function used for every request started by the bridge
+func (b *Bridge) onQRCode(qrCode []byte, requestID string) {
+	b.mu.Lock()
+	s, ok := b.requests[requestID]
+	b.mu.Unlock()
+	if ok {
+		b.send(s, Message{Action: ActionQRImage, Value: base64.StdEncoding.EncodeToString(qrCode), ID: s.id})
+	}
+}
+
+// send writes msg to the current socket of the session, if any. Messages sent while the session has
+// no socket are lost, apart from the last status update which is replayed on reconnect
+func (b *Bridge) send(s *session, msg Message) {
+	b.mu.Lock()
+	ws := s.ws
+	b.mu.Unlock()
+	if ws != nil {
+		s.writeTo(ws, msg)
+	}
+}
+
+func (s *session) writeTo(ws *websocket.Conn, msg Message) {
+	msg.V = ProtocolVersion
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	ws.WriteJSON(msg)
+}